@@ -4,11 +4,13 @@ import (
 	"net/http"
 
 	"log"
+	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	echojwt "github.com/labstack/echo-jwt/v4"
 	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
 
 	"github.com/perigiweb/go-wa-api/internal"
 	"github.com/perigiweb/go-wa-api/internal/service"
@@ -104,16 +106,71 @@ func (a *Action) Routes(e *echo.Echo) {
 	w.POST("/send", a.ActionPostSendMessage)
 	w.POST("/send-chat-presence", a.ActionPostSendChatPresence)
 	w.POST("/broadcast", a.ActionPostBroadcastMessage)
-	w.PATCH("/broadcast/:broadcastId", a.ActionPatchToggleRun)
+	w.POST("/broadcast/preview", a.ActionPostBroadcastPreview)
+	w.PUT("/broadcast/:broadcastId", a.ActionPutBroadcast)
+	w.PATCH("/broadcast/:broadcastId", a.ActionPatchBroadcastStatus)
+	w.POST("/broadcast/:broadcastId/start", a.ActionPostStartBroadcast)
+	w.POST("/broadcast/:broadcastId/pause", a.ActionPostPauseBroadcast)
 	w.DELETE("/broadcast/:broadcastId", a.ActionDeleteBroadcast)
 	w.GET("/avatar", a.ActionGetProfilePicture)
 	w.GET("/contacts", a.ActionGetWhatsAppContacts)
 	w.GET("/broadcasts", a.ActionGetBroadcasts)
 	w.GET("/broadcast/:broadcastId/recipients", a.ActionGetBroadCastRecipients)
+	w.GET("/broadcast/:broadcastId/stats", a.ActionGetBroadcastStats)
+	w.GET("/broadcast/:broadcastId/report", a.ActionGetBroadcastReport)
+	w.GET("/broadcast/:broadcastId/history", a.ActionGetBroadcastStatusHistory)
+	w.POST("/broadcast/:broadcastId/requeue", a.ActionPostBroadcastRequeue)
+	w.GET("/sender", a.ActionGetSenderSettings)
+	w.POST("/sender", a.ActionPostSenderSettings)
+	w.POST("/timezone", a.ActionPostDeviceTimezone)
 	w.GET("/chats", a.ActionGetChats)
 	w.GET("/conversation", a.ActionGetConversation)
+	w.GET("/chats/:jid/export", a.ActionGetChatExport)
+	w.POST("/chats/:jid/import", a.ActionPostChatImport, middleware.BodyLimit(strconv.FormatInt(service.ChatImportMaxSize(), 10)))
+	w.POST("/mark-as-read", a.ActionPostMarkAsRead)
+	w.GET("/messages/search", a.ActionGetSearchMessages)
+	w.GET("/message/:messageId/receipts", a.ActionGetMessageReceipts)
+	w.GET("/webhooks", a.ActionGetWebhooks)
+	w.POST("/webhook", a.ActionPostWebhook)
+	w.DELETE("/webhook/:webhookId", a.ActionDeleteWebhook)
+	w.GET("/webhook/:webhookId/deliveries", a.ActionGetWebhookDeliveries)
+	w.POST("/webhook/:webhookId/delivery/:deliveryId/replay", a.ActionPostReplayWebhookDelivery)
+	w.GET("/auto-replies", a.ActionGetAutoReplies)
+	w.POST("/auto-reply", a.ActionPostAutoReply)
+	w.POST("/auto-reply/test", a.ActionPostTestAutoReply)
+	w.DELETE("/auto-reply/:ruleId", a.ActionDeleteAutoReply)
+	w.GET("/auto-reply/:ruleId/logs", a.ActionGetAutoReplyLogs)
+	w.GET("/flows", a.ActionGetBotFlows)
+	w.POST("/flow", a.ActionPostBotFlow)
+	w.DELETE("/flow/:flowId", a.ActionDeleteBotFlow)
+	w.GET("/flow-sessions", a.ActionGetBotSessions)
+	w.DELETE("/flow-session/:jid", a.ActionDeleteBotSession)
+	w.GET("/chat/:jid", a.ActionGetChat)
+	w.PATCH("/chat/:jid", a.ActionPatchChat)
+	w.POST("/chat/:jid/assign", a.ActionPostAssignChat)
+	w.POST("/chat/:jid/transfer", a.ActionPostTransferChat)
+	w.POST("/chat/:jid/resolve", a.ActionPostResolveChat)
+	w.POST("/chat/:jid/reopen", a.ActionPostReopenChat)
+	w.PATCH("/chat/:jid/mode", a.ActionPatchChatMode)
+	w.GET("/chat/:jid/notes", a.ActionGetChatNotes)
+	w.POST("/chat/:jid/note", a.ActionPostChatNote)
+	w.DELETE("/chat/:jid/note/:noteId", a.ActionDeleteChatNote)
+	w.GET("/canned-responses", a.ActionGetCannedResponses)
+	w.POST("/canned-response", a.ActionPostCannedResponse)
+	w.DELETE("/canned-response/:cannedId", a.ActionDeleteCannedResponse)
+	w.GET("/handoff-settings", a.ActionGetHandoffSettings)
+	w.POST("/handoff-settings", a.ActionPostHandoffSettings)
+	w.GET("/retention", a.ActionGetRetentionSettings)
+	w.POST("/retention", a.ActionPostRetentionSettings)
+	w.GET("/retention/preview", a.ActionGetRetentionPreview)
+	w.GET("/retention/purges", a.ActionGetRetentionPurges)
 
 	g.POST("/update-profile", a.actionPostUpdateAccount)
+	g.GET("/retention", a.ActionGetRetentionSettings)
+	g.POST("/retention", a.ActionPostRetentionSettings)
+	g.GET("/agents", a.ActionGetAgents)
+	g.POST("/agent", a.ActionPostAgent)
+	g.DELETE("/agent/:agentId", a.ActionDeleteAgent)
 	g.GET("/contacts", a.ActionGetUserContacts)
 	g.GET("/total-contacts", a.ActionGetTotalUserContacts)
 	g.POST("/contact", a.ActionPostUserContact)
@@ -0,0 +1,90 @@
+package action
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+func (a *Action) ActionGetAgents(c echo.Context) error {
+	var responsePayload ResponsePayload
+
+	agents, err := a.service.Repo.GetAgents(a.user.UserId)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	responsePayload.Status = true
+	responsePayload.Data = agents
+
+	return c.JSON(http.StatusOK, responsePayload)
+}
+
+func (a *Action) ActionPostAgent(c echo.Context) error {
+	var (
+		err             error
+		responsePayload ResponsePayload
+	)
+
+	responsePayload.Status = false
+
+	reqBody := new(entity.Agent)
+	if err = c.Bind(reqBody); err != nil {
+		responsePayload.Message = err.Error()
+
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	reqBody.Name = strings.TrimSpace(reqBody.Name)
+	if err = c.Validate(reqBody); err != nil {
+		return err
+	}
+
+	agent, err := a.service.Repo.GetAgentByName(a.user.UserId, reqBody.Name)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+	if agent != nil {
+		responsePayload.Message = "Agent " + reqBody.Name + " already exists"
+		return c.JSON(http.StatusConflict, responsePayload)
+	}
+
+	reqBody.Id = 0
+	reqBody.UserId = a.user.UserId
+	if err = a.service.Repo.InsertAgent(reqBody); err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	responsePayload.Status = true
+	responsePayload.Message = "Agent has been successfully saved"
+	responsePayload.Data = reqBody
+
+	return c.JSON(http.StatusCreated, responsePayload)
+}
+
+func (a *Action) ActionDeleteAgent(c echo.Context) error {
+	var responsePayload ResponsePayload
+
+	code := http.StatusOK
+	responsePayload.Status = true
+
+	agentId, err := strconv.ParseInt(c.Param("agentId"), 10, 64)
+	if err == nil {
+		err = a.service.Repo.DeleteAgent(agentId, a.user.UserId)
+	}
+
+	if err != nil {
+		code = http.StatusUnprocessableEntity
+		responsePayload.Status = false
+		responsePayload.Message = err.Error()
+	}
+
+	return c.JSON(code, responsePayload)
+}
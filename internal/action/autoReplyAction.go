@@ -0,0 +1,210 @@
+package action
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mau.fi/whatsmeow/types"
+
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+func (a *Action) ActionGetAutoReplies(c echo.Context) error {
+	var responsePayload ResponsePayload
+
+	uDevice := c.Get("device").(*entity.Device)
+	rules, err := a.service.Repo.GetAutoReplyRules(uDevice.Id)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	responsePayload.Status = true
+	responsePayload.Data = rules
+
+	return c.JSON(http.StatusOK, responsePayload)
+}
+
+func (a *Action) ActionPostAutoReply(c echo.Context) error {
+	var (
+		err             error
+		responsePayload ResponsePayload
+	)
+
+	responsePayload.Status = false
+
+	reqBody := new(entity.AutoReplyRule)
+	if err = c.Bind(reqBody); err != nil {
+		responsePayload.Message = err.Error()
+
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	if err = c.Validate(reqBody); err != nil {
+		return err
+	}
+
+	if reqBody.MatchType == "regex" {
+		if _, err = regexp.Compile(reqBody.Keyword); err != nil {
+			responsePayload.Message = "Invalid regex keyword: " + err.Error()
+			return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+		}
+	}
+
+	if (reqBody.ActiveFrom == "") != (reqBody.ActiveTo == "") {
+		responsePayload.Message = "activeFrom and activeTo should be set together"
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	uDevice := c.Get("device").(*entity.Device)
+	if reqBody.Id != 0 {
+		rule, err := a.service.Repo.GetAutoReplyRule(reqBody.Id, uDevice.Id)
+		if err != nil {
+			responsePayload.Message = "Can't find auto reply with ID: " + strconv.FormatInt(reqBody.Id, 10)
+			return c.JSON(http.StatusNotFound, responsePayload)
+		}
+		// An edit without active keeps the rule as it is
+		if reqBody.Active == nil {
+			reqBody.Active = rule.Active
+		}
+	}
+	reqBody.DeviceId = uDevice.Id
+
+	err = a.service.SaveAutoReplyRule(reqBody)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	responsePayload.Status = true
+	responsePayload.Message = "Auto reply has been successfully saved"
+	responsePayload.Data = reqBody
+
+	return c.JSON(http.StatusCreated, responsePayload)
+}
+
+func (a *Action) ActionDeleteAutoReply(c echo.Context) error {
+	var responsePayload ResponsePayload
+
+	code := http.StatusOK
+	responsePayload.Status = true
+
+	ruleId, err := strconv.ParseInt(c.Param("ruleId"), 10, 64)
+	if err == nil {
+		uDevice := c.Get("device").(*entity.Device)
+		err = a.service.Repo.DeleteAutoReplyRule(ruleId, uDevice.Id)
+	}
+
+	if err != nil {
+		code = http.StatusUnprocessableEntity
+		responsePayload.Status = false
+		responsePayload.Message = err.Error()
+	}
+
+	return c.JSON(code, responsePayload)
+}
+
+type autoReplyLogsResponsePayload struct {
+	Logs     []entity.AutoReplyLog `json:"logs"`
+	Total    int                   `json:"total"`
+	PrevPage int                   `json:"prevPage"`
+	NextPage int                   `json:"nextPage"`
+	Limit    int                   `json:"limit"`
+}
+
+func (a *Action) ActionGetAutoReplyLogs(c echo.Context) error {
+	var responsePayload ResponsePayload
+
+	responsePayload.Status = false
+
+	ruleId, err := strconv.ParseInt(c.Param("ruleId"), 10, 64)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	uDevice := c.Get("device").(*entity.Device)
+	if _, err = a.service.Repo.GetAutoReplyRule(ruleId, uDevice.Id); err != nil {
+		responsePayload.Message = "Can't find auto reply with ID: " + c.Param("ruleId")
+		return c.JSON(http.StatusNotFound, responsePayload)
+	}
+
+	limit := 50
+	page, err := strconv.Atoi(c.QueryParam("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	offset := (page - 1) * limit
+
+	logs, total, err := a.service.Repo.GetAutoReplyLogs(ruleId, limit, offset)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	prevPage := 0
+	if page > 1 {
+		prevPage = page - 1
+	}
+	nextPage := 0
+	if (limit + offset) < total {
+		nextPage = page + 1
+	}
+
+	responsePayload.Status = true
+	responsePayload.Data = autoReplyLogsResponsePayload{
+		Logs:     logs,
+		Total:    total,
+		PrevPage: prevPage,
+		NextPage: nextPage,
+		Limit:    limit,
+	}
+
+	return c.JSON(http.StatusOK, responsePayload)
+}
+
+type autoReplyTestPayload struct {
+	Message string    `json:"message" validate:"required"`
+	Chat    types.JID `json:"chat"`
+	IsGroup bool      `json:"isGroup"`
+}
+
+// ActionPostTestAutoReply simulates an incoming message and returns the rule
+// that would answer it, nothing is sent.
+func (a *Action) ActionPostTestAutoReply(c echo.Context) error {
+	var (
+		err             error
+		responsePayload ResponsePayload
+	)
+
+	responsePayload.Status = false
+
+	reqBody := new(autoReplyTestPayload)
+	if err = c.Bind(reqBody); err != nil {
+		responsePayload.Message = err.Error()
+
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	if err = c.Validate(reqBody); err != nil {
+		return err
+	}
+
+	uDevice := c.Get("device").(*entity.Device)
+	match, err := a.service.MatchAutoReply(uDevice.Id, reqBody.Chat, reqBody.Message, reqBody.IsGroup || reqBody.Chat.Server == types.GroupServer, time.Now().In(uDevice.Location()))
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	responsePayload.Status = true
+	if match == nil {
+		responsePayload.Message = "No auto reply matches the message"
+	}
+	responsePayload.Data = match
+
+	return c.JSON(http.StatusOK, responsePayload)
+}
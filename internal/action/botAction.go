@@ -0,0 +1,191 @@
+package action
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"go.mau.fi/whatsmeow/types"
+
+	"github.com/perigiweb/go-wa-api/internal/service"
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+// botFlowPayload is a flow to save, a new flow without active is active and
+// an edited one keeps its state.
+type botFlowPayload struct {
+	Id         int64  `json:"id"`
+	Name       string `json:"name" validate:"required"`
+	Active     *bool  `json:"active"`
+	Definition string `json:"definition" validate:"required"`
+}
+
+func (a *Action) ActionGetBotFlows(c echo.Context) error {
+	var responsePayload ResponsePayload
+
+	uDevice := c.Get("device").(*entity.Device)
+	flows, err := a.service.Repo.GetBotFlows(uDevice.Id)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	responsePayload.Status = true
+	responsePayload.Data = flows
+
+	return c.JSON(http.StatusOK, responsePayload)
+}
+
+// ActionPostBotFlow creates or updates a flow, the definition is a YAML or
+// JSON document describing the states of the conversation.
+func (a *Action) ActionPostBotFlow(c echo.Context) error {
+	var (
+		err             error
+		responsePayload ResponsePayload
+	)
+
+	responsePayload.Status = false
+
+	reqBody := new(botFlowPayload)
+	if err = c.Bind(reqBody); err != nil {
+		responsePayload.Message = err.Error()
+
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	if err = c.Validate(reqBody); err != nil {
+		return err
+	}
+
+	definition, err := service.ParseBotFlowDefinition(reqBody.Definition)
+	if err != nil {
+		responsePayload.Message = "Invalid flow definition: " + err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	uDevice := c.Get("device").(*entity.Device)
+	active := true
+	if reqBody.Id != 0 {
+		saved, err := a.service.Repo.GetBotFlow(reqBody.Id, uDevice.Id)
+		if err != nil {
+			responsePayload.Message = "Can't find flow with ID: " + strconv.FormatInt(reqBody.Id, 10)
+			return c.JSON(http.StatusNotFound, responsePayload)
+		}
+		active = saved.Active
+	}
+	if reqBody.Active != nil {
+		active = *reqBody.Active
+	}
+
+	flow := &entity.BotFlow{
+		Id:         reqBody.Id,
+		DeviceId:   uDevice.Id,
+		Name:       reqBody.Name,
+		Definition: definition,
+		Active:     active,
+	}
+
+	err = a.service.Repo.SaveBotFlow(flow)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	responsePayload.Status = true
+	responsePayload.Message = "Flow has been successfully saved"
+	responsePayload.Data = flow
+
+	return c.JSON(http.StatusCreated, responsePayload)
+}
+
+func (a *Action) ActionDeleteBotFlow(c echo.Context) error {
+	var responsePayload ResponsePayload
+
+	code := http.StatusOK
+	responsePayload.Status = true
+
+	flowId, err := strconv.ParseInt(c.Param("flowId"), 10, 64)
+	if err == nil {
+		uDevice := c.Get("device").(*entity.Device)
+		err = a.service.Repo.DeleteBotFlow(flowId, uDevice.Id)
+	}
+
+	if err != nil {
+		code = http.StatusUnprocessableEntity
+		responsePayload.Status = false
+		responsePayload.Message = err.Error()
+	}
+
+	return c.JSON(code, responsePayload)
+}
+
+type botSessionsResponsePayload struct {
+	Sessions []*entity.BotSession `json:"sessions"`
+	Total    int                  `json:"total"`
+	PrevPage int                  `json:"prevPage"`
+	NextPage int                  `json:"nextPage"`
+	Limit    int                  `json:"limit"`
+}
+
+func (a *Action) ActionGetBotSessions(c echo.Context) error {
+	var responsePayload ResponsePayload
+
+	responsePayload.Status = false
+
+	limit := 50
+	page, err := strconv.Atoi(c.QueryParam("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	offset := (page - 1) * limit
+
+	uDevice := c.Get("device").(*entity.Device)
+	sessions, total, err := a.service.Repo.GetActiveBotSessions(uDevice.Id, limit, offset)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	prevPage := 0
+	if page > 1 {
+		prevPage = page - 1
+	}
+	nextPage := 0
+	if (limit + offset) < total {
+		nextPage = page + 1
+	}
+
+	responsePayload.Status = true
+	responsePayload.Data = botSessionsResponsePayload{
+		Sessions: sessions,
+		Total:    total,
+		PrevPage: prevPage,
+		NextPage: nextPage,
+		Limit:    limit,
+	}
+
+	return c.JSON(http.StatusOK, responsePayload)
+}
+
+// ActionDeleteBotSession ends the flow running in the chat, the next message
+// of the contact is handled as if no flow was started.
+func (a *Action) ActionDeleteBotSession(c echo.Context) error {
+	var responsePayload ResponsePayload
+
+	code := http.StatusOK
+	responsePayload.Status = true
+
+	jid, err := types.ParseJID(c.Param("jid"))
+	if err == nil {
+		uDevice := c.Get("device").(*entity.Device)
+		err = a.service.Repo.DeleteBotSession(uDevice.Id, jid)
+	}
+
+	if err != nil {
+		code = http.StatusUnprocessableEntity
+		responsePayload.Status = false
+		responsePayload.Message = err.Error()
+	}
+
+	return c.JSON(code, responsePayload)
+}
@@ -1,20 +1,45 @@
 package action
 
 import (
+	"errors"
+	"log"
+	"mime"
 	"net/http"
+	"slices"
 	"strconv"
+	"time"
 
 	"github.com/labstack/echo/v4"
 
+	"github.com/perigiweb/go-wa-api/internal/service"
 	"github.com/perigiweb/go-wa-api/internal/store/entity"
 )
 
+// broadcastRecipientCount counts who the broadcast would be sent to.
+func (a *Action) broadcastRecipientCount(uDevice *entity.Device, broadcast *entity.Broadcast) int {
+	totalRecipient := 0
+
+	switch broadcast.ContactType {
+	case "c":
+		totalRecipient, _ = a.service.Repo.GetTotalUserContacts(a.user.UserId, broadcast.ContactFilter, broadcast.FilterValue)
+	case "w":
+		totalRecipient, _ = a.service.Repo.CountWhatsAppContact(uDevice, broadcast.ContactFilter, broadcast.FilterValue)
+	case "p":
+		if broadcast.Phones != nil {
+			totalRecipient = len(broadcast.Phones)
+		}
+	}
+
+	return totalRecipient
+}
+
+// ActionPostBroadcastMessage creates a broadcast, scheduled to be sent from
+// its sentStartedAt or as a draft when status is draft.
 func (a *Action) ActionPostBroadcastMessage(c echo.Context) error {
 	var (
 		err             error
 		responsePayload ResponsePayload
 	)
-	totalRecipient := 0
 
 	responsePayload.Status = false
 
@@ -29,20 +54,18 @@ func (a *Action) ActionPostBroadcastMessage(c echo.Context) error {
 		return err
 	}
 
-	uDevice := c.Get("device").(*entity.Device)
+	if reqBody.Status == "" {
+		reqBody.Status = service.BroadcastScheduled
+	}
+	if reqBody.Status != service.BroadcastDraft && reqBody.Status != service.BroadcastScheduled {
+		responsePayload.Message = "A new broadcast can only be draft or scheduled"
 
-	switch reqBody.ContactType {
-	case "c":
-		totalRecipient, _ = a.service.Repo.GetTotalUserContacts(a.user.UserId, reqBody.ContactFilter, reqBody.FilterValue)
-	case "w":
-		totalRecipient, _ = a.service.Repo.CountWhatsAppContact(uDevice, reqBody.ContactFilter, reqBody.FilterValue)
-	case "p":
-		if reqBody.Phones != nil {
-			totalRecipient = len(reqBody.Phones)
-		}
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
 	}
 
-	if totalRecipient == 0 {
+	uDevice := c.Get("device").(*entity.Device)
+
+	if a.broadcastRecipientCount(uDevice, reqBody) == 0 {
 		responsePayload.Message = "No recipients, please change recipient filter"
 
 		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
@@ -51,6 +74,12 @@ func (a *Action) ActionPostBroadcastMessage(c echo.Context) error {
 	reqBody.UserId = a.user.UserId
 	reqBody.Jid = uDevice.Jid.ToNonAD()
 
+	if err = a.service.ValidateBroadcastMessage(reqBody); err != nil {
+		responsePayload.Message = err.Error()
+
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
 	err = a.service.Repo.SaveBroadcast(reqBody)
 	if err != nil {
 		responsePayload.Message = err.Error()
@@ -63,6 +92,81 @@ func (a *Action) ActionPostBroadcastMessage(c echo.Context) error {
 	return c.JSON(http.StatusCreated, responsePayload)
 }
 
+// ActionPutBroadcast changes the message, recipients or schedule of a draft,
+// scheduled or paused broadcast.
+func (a *Action) ActionPutBroadcast(c echo.Context) error {
+	var (
+		err             error
+		responsePayload ResponsePayload
+	)
+
+	responsePayload.Status = false
+
+	broadcast, err := a.deviceBroadcast(c)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	if !service.BroadcastEditable(broadcast.Status) {
+		responsePayload.Message = "Broadcast is " + broadcast.Status + ", only a draft, scheduled or paused broadcast can be edited"
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	reqBody := new(entity.Broadcast)
+	if err = c.Bind(reqBody); err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	if err = c.Validate(reqBody); err != nil {
+		return err
+	}
+
+	uDevice := c.Get("device").(*entity.Device)
+
+	// The recipients of a broadcast that has started are already listed
+	if broadcast.SnapshotAt != nil && (broadcast.ContactType != reqBody.ContactType ||
+		broadcast.ContactFilter != reqBody.ContactFilter ||
+		broadcast.FilterValue != reqBody.FilterValue ||
+		!slices.Equal(broadcast.Phones, reqBody.Phones)) {
+		responsePayload.Message = "Broadcast has started, its recipients can't be changed"
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	if a.broadcastRecipientCount(uDevice, reqBody) == 0 {
+		responsePayload.Message = "No recipients, please change recipient filter"
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	reqBody.UserId = broadcast.UserId
+	if err = a.service.ValidateBroadcastMessage(reqBody); err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	broadcast.Message = reqBody.Message
+	broadcast.Media = reqBody.Media
+	broadcast.ContactType = reqBody.ContactType
+	broadcast.ContactFilter = reqBody.ContactFilter
+	broadcast.FilterValue = reqBody.FilterValue
+	broadcast.Phones = reqBody.Phones
+	broadcast.CampaignName = reqBody.CampaignName
+	broadcast.SentStartedAt = reqBody.SentStartedAt
+
+	err = a.service.Repo.SaveBroadcast(broadcast)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	responsePayload.Message = "Broadcast has been successfully updated"
+	responsePayload.Status = true
+	responsePayload.Data = broadcast
+
+	return c.JSON(http.StatusOK, responsePayload)
+}
+
 type broadcastsResponsePayload struct {
 	Broadcasts []*entity.Broadcast `json:"broadcasts"`
 	Total      int                 `json:"total"`
@@ -116,41 +220,166 @@ func (a *Action) ActionGetBroadcasts(c echo.Context) error {
 	return c.JSON(http.StatusOK, responsePayload)
 }
 
-type toggleRunBody struct {
-	Status string `json:"status"`
+type broadcastStatusBody struct {
+	// start, pause or cancel, or the status they move to
+	Status string `json:"status" validate:"required"`
 }
 
-func (a *Action) ActionPatchToggleRun(c echo.Context) error {
+// ActionPatchBroadcastStatus starts, pauses or cancels a broadcast, the
+// transitions of the sender and of a requeue are rejected.
+func (a *Action) ActionPatchBroadcastStatus(c echo.Context) error {
 	var (
 		err             error
-		body            toggleRunBody
+		body            broadcastStatusBody
 		responsePayload ResponsePayload
-		broadcastId     int
 	)
 
-	code := http.StatusOK
+	responsePayload.Status = false
+
+	if err = c.Bind(&body); err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	if err = c.Validate(body); err != nil {
+		return err
+	}
+
+	return a.applyBroadcastAction(c, body.Status)
+}
+
+// ActionPostStartBroadcast starts a broadcast, like a PATCH with start.
+func (a *Action) ActionPostStartBroadcast(c echo.Context) error {
+	return a.applyBroadcastAction(c, service.BroadcastStart)
+}
+
+// ActionPostPauseBroadcast pauses a broadcast, like a PATCH with pause.
+func (a *Action) ActionPostPauseBroadcast(c echo.Context) error {
+	return a.applyBroadcastAction(c, service.BroadcastPause)
+}
+
+func (a *Action) applyBroadcastAction(c echo.Context, action string) error {
+	var responsePayload ResponsePayload
+
+	responsePayload.Status = false
+
+	broadcast, err := a.deviceBroadcast(c)
+	if err == nil {
+		err = a.service.ApplyBroadcastAction(broadcast, action, a.user.UserId)
+	}
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	responsePayload.Status = true
+	responsePayload.Data = broadcast.Status
+
+	return c.JSON(http.StatusOK, responsePayload)
+}
+
+type broadcastPreviewBody struct {
+	Message string `json:"message" validate:"required,max=65536"`
+	Count   int    `json:"count" validate:"gte=0,lte=50"`
+}
+
+// ActionPostBroadcastPreview lists variants of the spintax of a broadcast
+// message and counts them.
+func (a *Action) ActionPostBroadcastPreview(c echo.Context) error {
+	var (
+		err             error
+		body            broadcastPreviewBody
+		responsePayload ResponsePayload
+	)
+
+	responsePayload.Status = false
+
+	if err = c.Bind(&body); err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	if err = c.Validate(body); err != nil {
+		return err
+	}
+
+	preview, err := a.service.PreviewBroadcastMessage(body.Message, body.Count)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
 	responsePayload.Status = true
+	responsePayload.Data = preview
+
+	return c.JSON(http.StatusOK, responsePayload)
+}
+
+type broadcastRequeueBody struct {
+	Message string               `json:"message" validate:"omitempty,min=100"`
+	Media   *entity.UploadedFile `json:"media"`
+}
 
-	broadcastId, _ = strconv.Atoi(c.Param("broadcastId"))
+// ActionPostBroadcastRequeue queues the failed recipients of a broadcast
+// again, its message can be changed first.
+func (a *Action) ActionPostBroadcastRequeue(c echo.Context) error {
+	var (
+		err             error
+		body            broadcastRequeueBody
+		responsePayload ResponsePayload
+		requeued        int64
+	)
 
-	err = c.Bind(&body)
+	responsePayload.Status = false
+
+	if err = c.Bind(&body); err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	if err = c.Validate(body); err != nil {
+		return err
+	}
+
+	broadcast, err := a.deviceBroadcast(c)
 	if err == nil {
-		err = a.service.Repo.UpdateRunningStatusBroadcast(int64(broadcastId), body.Status)
+		requeued, err = a.service.RequeueFailedBroadcastRecipients(broadcast, body.Message, body.Media, a.user.UserId)
 	}
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	responsePayload.Message = strconv.FormatInt(requeued, 10) + " failed recipients have been queued again"
+	responsePayload.Status = true
+	responsePayload.Data = broadcast
+
+	return c.JSON(http.StatusOK, responsePayload)
+}
+
+// ActionGetBroadcastStatusHistory lists the status changes of a broadcast.
+func (a *Action) ActionGetBroadcastStatusHistory(c echo.Context) error {
+	var (
+		err             error
+		responsePayload ResponsePayload
+		history         []entity.BroadcastStatusChange
+	)
 
+	responsePayload.Status = false
+
+	broadcast, err := a.deviceBroadcast(c)
+	if err == nil {
+		history, err = a.service.Repo.GetBroadcastStatusHistory(broadcast.Id)
+	}
 	if err != nil {
-		code = http.StatusUnprocessableEntity
-		responsePayload.Status = false
 		responsePayload.Message = err.Error()
-	} else {
-		if body.Status == "start" {
-			responsePayload.Data = "pending"
-		} else {
-			responsePayload.Data = "pause"
-		}
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
 	}
 
-	return c.JSON(code, responsePayload)
+	responsePayload.Status = true
+	responsePayload.Data = history
+
+	return c.JSON(http.StatusOK, responsePayload)
 }
 
 func (a *Action) ActionDeleteBroadcast(c echo.Context) error {
@@ -236,3 +465,134 @@ func (a *Action) ActionGetBroadCastRecipients(c echo.Context) error {
 
 	return c.JSON(code, responsePayload)
 }
+
+// deviceBroadcast returns the broadcast of the broadcastId param, it has to
+// be one of the device.
+func (a *Action) deviceBroadcast(c echo.Context) (*entity.Broadcast, error) {
+	broadcastId, err := strconv.ParseInt(c.Param("broadcastId"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	broadcast, err := a.service.Repo.GetBroadcast(broadcastId)
+	if err != nil {
+		return nil, err
+	}
+
+	uDevice := c.Get("device").(*entity.Device)
+	if uDevice.Jid == nil || broadcast.Jid != uDevice.Jid.ToNonAD() {
+		return nil, errors.New("broadcast not found")
+	}
+
+	return broadcast, nil
+}
+
+// broadcastLocation loads the tz param, UTC by default.
+func broadcastLocation(c echo.Context) (*time.Location, error) {
+	if tz := c.QueryParam("tz"); tz != "" {
+		return time.LoadLocation(tz)
+	}
+
+	return time.UTC, nil
+}
+
+type broadcastStatsResponsePayload struct {
+	*entity.BroadcastStats
+	SendHours [24]int                          `json:"sendHours"`
+	Timeline  []entity.BroadcastTimelineBucket `json:"timeline"`
+}
+
+// ActionGetBroadcastStats returns the totals and rates of a broadcast, the
+// messages sent per hour of the day and an hourly timeline.
+func (a *Action) ActionGetBroadcastStats(c echo.Context) error {
+	var (
+		err             error
+		responsePayload ResponsePayload
+	)
+
+	responsePayload.Status = false
+
+	broadcast, err := a.deviceBroadcast(c)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	loc, err := broadcastLocation(c)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	data := broadcastStatsResponsePayload{BroadcastStats: broadcast.Stats}
+	if data.BroadcastStats == nil {
+		data.BroadcastStats, err = a.service.Repo.GetBroadcastStats(broadcast.Id)
+	}
+	if err == nil {
+		data.SendHours, err = a.service.Repo.GetBroadcastSendHours(broadcast.Id, loc)
+	}
+	if err == nil {
+		data.Timeline, err = a.service.Repo.GetBroadcastTimeline(broadcast.Id, loc)
+	}
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	responsePayload.Status = true
+	responsePayload.Data = data
+
+	return c.JSON(http.StatusOK, responsePayload)
+}
+
+var broadcastReportContentTypes = map[string]string{
+	service.BroadcastReportCSV:  "text/csv; charset=utf-8",
+	service.BroadcastReportXLSX: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// ActionGetBroadcastReport downloads the recipients of a broadcast with the
+// status of their message as CSV or XLSX.
+func (a *Action) ActionGetBroadcastReport(c echo.Context) error {
+	var (
+		err             error
+		responsePayload ResponsePayload
+	)
+
+	responsePayload.Status = false
+
+	format := c.QueryParam("format")
+	if format == "" {
+		format = service.BroadcastReportCSV
+	}
+	contentType, ok := broadcastReportContentTypes[format]
+	if !ok {
+		responsePayload.Message = "format must be one of csv xlsx"
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	broadcast, err := a.deviceBroadcast(c)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	loc, err := broadcastLocation(c)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, contentType)
+	res.Header().Set(echo.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{
+		"filename": service.BroadcastReportFileName(broadcast, format),
+	}))
+	res.WriteHeader(http.StatusOK)
+
+	// The response has started, a failure can only cut it short
+	if err = a.service.WriteBroadcastReport(res, broadcast, format, loc); err != nil {
+		log.Printf("Broadcast report %d Error: %s", broadcast.Id, err.Error())
+	}
+
+	return nil
+}
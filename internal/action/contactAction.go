@@ -136,9 +136,10 @@ type importContactPayload struct {
 	UploadedFile string `json:"UploadedFile" validate:"required"`
 }
 type importContact struct {
-	Name  string `json:"name"`
-	Phone string `json:"phone"`
-	Group string `json:"group"`
+	Name   string            `json:"name"`
+	Phone  string            `json:"phone"`
+	Group  string            `json:"group"`
+	Fields map[string]string `json:"fields"`
 }
 
 func (a *Action) ActionPostImportContact(c echo.Context) error {
@@ -180,6 +181,7 @@ func (a *Action) ActionPostImportContact(c echo.Context) error {
 			Name:   ic.Name,
 			Phone:  ic.Phone,
 			InWA:   1,
+			Fields: ic.Fields,
 		}
 
 		if ic.Group != "" {
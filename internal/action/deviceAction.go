@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/labstack/echo/v4"
+	"github.com/perigiweb/go-wa-api/internal/store"
 	"github.com/perigiweb/go-wa-api/internal/store/entity"
 )
 
@@ -24,8 +25,16 @@ func (a *Action) ActionGetDevices(c echo.Context) error {
 	return c.JSON(http.StatusOK, responsePayload)
 }
 
+// AddDeviceReqPayload may limit the history WhatsApp sends once the device
+// is paired, to the last HistoryDays days and HistorySizeMb megabytes.
+// FullSync asks for the whole history instead. Timezone is the IANA time zone
+// the messages of the device are rendered in, the server one when empty.
 type AddDeviceReqPayload struct {
-	Name string `json:"name" validate:"required"`
+	Name          string `json:"name" validate:"required"`
+	HistoryDays   int    `json:"historyDays" validate:"omitempty,min=1,max=3650"`
+	HistorySizeMb int    `json:"historySizeMb" validate:"omitempty,min=1,max=10240"`
+	FullSync      bool   `json:"fullSync"`
+	Timezone      string `json:"timezone" validate:"omitempty,timezone"`
 }
 
 func (a *Action) ActionPostAddDevice(c echo.Context) error {
@@ -49,12 +58,29 @@ func (a *Action) ActionPostAddDevice(c echo.Context) error {
 		return c.JSON(http.StatusOK, responsePayload)
 	}
 
-	device, err = a.service.Repo.InsertNewDevice(a.user.UserId, reqBody.Name)
+	device, err = a.service.Repo.InsertNewDevice(a.user.UserId, reqBody.Name, reqBody.Timezone)
 	if err != nil {
 		responsePayload.Message = err.Error()
 		return c.JSON(http.StatusOK, responsePayload)
 	}
 
+	historySync := &entity.HistorySync{
+		DeviceId: device.Id,
+		Days:     reqBody.HistoryDays,
+		SizeMb:   reqBody.HistorySizeMb,
+		FullSync: reqBody.FullSync,
+	}
+	if historySync.Days == 0 {
+		historySync.Days = store.DefaultHistorySyncDays
+	}
+	if historySync.SizeMb == 0 {
+		historySync.SizeMb = store.DefaultHistorySyncSizeMb
+	}
+	if err = a.service.Repo.SaveHistorySync(historySync); err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusOK, responsePayload)
+	}
+
 	responsePayload.Status = true
 	responsePayload.Data = device
 
@@ -68,6 +94,9 @@ func (a *Action) ActionDeleteDevice(c echo.Context) error {
 	)
 
 	err := a.service.Repo.DeleteDeviceById(c.Param("deviceId"), a.user.UserId)
+	if err == nil {
+		a.service.StopBroadcastSender(c.Param("deviceId"))
+	}
 
 	responsePayload.Status = true
 	if err != nil {
@@ -77,3 +106,41 @@ func (a *Action) ActionDeleteDevice(c echo.Context) error {
 
 	return c.JSON(http.StatusOK, responsePayload)
 }
+
+type deviceTimezoneReqPayload struct {
+	Timezone string `json:"timezone" validate:"omitempty,timezone"`
+}
+
+// ActionPostDeviceTimezone sets the time zone of the device, an empty one
+// falls back to the server time zone.
+func (a *Action) ActionPostDeviceTimezone(c echo.Context) error {
+	var (
+		err             error
+		responsePayload ResponsePayload
+	)
+
+	responsePayload.Status = false
+
+	reqBody := new(deviceTimezoneReqPayload)
+	if err = c.Bind(reqBody); err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	if err = c.Validate(reqBody); err != nil {
+		return err
+	}
+
+	uDevice := c.Get("device").(*entity.Device)
+	if err = a.service.Repo.UpdateTimezone(reqBody.Timezone, uDevice.Id); err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+	uDevice.Timezone = reqBody.Timezone
+
+	responsePayload.Status = true
+	responsePayload.Message = "Timezone has been successfully saved"
+	responsePayload.Data = uDevice
+
+	return c.JSON(http.StatusOK, responsePayload)
+}
@@ -0,0 +1,92 @@
+package action
+
+import (
+	"log"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mau.fi/whatsmeow/types"
+
+	"github.com/perigiweb/go-wa-api/internal/service"
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+var chatExportContentTypes = map[string]string{
+	service.ChatExportText: "text/plain; charset=utf-8",
+	service.ChatExportJSON: "application/json",
+	service.ChatExportHTML: "text/html; charset=utf-8",
+	service.ChatExportZip:  "application/zip",
+}
+
+type chatExportParam struct {
+	Format string `query:"format" validate:"omitempty,oneof=txt json html zip"`
+	// IANA time zone of the exported times, the server time zone by default
+	TimeZone string `query:"tz" validate:"omitempty,timezone"`
+}
+
+// ActionGetChatExport downloads the whole conversation of a chat, as a
+// transcript, JSON, HTML or a zip archive with its media.
+func (a *Action) ActionGetChatExport(c echo.Context) error {
+	var (
+		err             error
+		responsePayload ResponsePayload
+	)
+
+	responsePayload.Status = false
+
+	jid, err := types.ParseJID(c.Param("jid"))
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	param := new(chatExportParam)
+	if err = c.Bind(param); err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	if err = c.Validate(param); err != nil {
+		return err
+	}
+
+	if param.Format == "" {
+		param.Format = service.ChatExportText
+	}
+	loc := time.Local
+	if param.TimeZone != "" {
+		if loc, err = time.LoadLocation(param.TimeZone); err != nil {
+			responsePayload.Message = err.Error()
+			return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+		}
+	}
+
+	uDevice := c.Get("device").(*entity.Device)
+	exists, err := a.service.Repo.HasWAMessages(uDevice.Id, jid)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+	if !exists {
+		responsePayload.Message = "Can't find chat with JID: " + jid.String()
+		return c.JSON(http.StatusNotFound, responsePayload)
+	}
+
+	export := a.service.NewChatExport(uDevice, jid, loc)
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, chatExportContentTypes[param.Format])
+	res.Header().Set(echo.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{
+		"filename": export.FileName(param.Format),
+	}))
+	res.WriteHeader(http.StatusOK)
+
+	// The response has started, a failure can only cut it short
+	if err = export.Write(res, param.Format); err != nil {
+		log.Printf("Export chat %s Error: %s", jid, err.Error())
+	}
+
+	return nil
+}
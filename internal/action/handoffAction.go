@@ -0,0 +1,106 @@
+package action
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.mau.fi/whatsmeow/types"
+
+	"github.com/perigiweb/go-wa-api/internal/service"
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+type chatModePayload struct {
+	Mode string `json:"mode" validate:"required,oneof=bot human paused"`
+}
+
+// ActionPatchChatMode lets an agent take over a chat, pause it or give it
+// back to the bot.
+func (a *Action) ActionPatchChatMode(c echo.Context) error {
+	var (
+		err             error
+		responsePayload ResponsePayload
+	)
+
+	responsePayload.Status = false
+
+	jid, err := types.ParseJID(c.Param("jid"))
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	reqBody := new(chatModePayload)
+	if err = c.Bind(reqBody); err != nil {
+		responsePayload.Message = err.Error()
+
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	if err = c.Validate(reqBody); err != nil {
+		return err
+	}
+
+	uDevice := c.Get("device").(*entity.Device)
+	userChat, err := a.service.SetChatMode(uDevice.Id, jid, reqBody.Mode, service.HandoffReasonManual)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	responsePayload.Status = true
+	responsePayload.Data = userChat
+
+	return c.JSON(http.StatusOK, responsePayload)
+}
+
+func (a *Action) ActionGetHandoffSettings(c echo.Context) error {
+	var responsePayload ResponsePayload
+
+	uDevice := c.Get("device").(*entity.Device)
+	settings, err := a.service.Repo.GetHandoffSettings(uDevice.Id)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	responsePayload.Status = true
+	responsePayload.Data = settings
+
+	return c.JSON(http.StatusOK, responsePayload)
+}
+
+func (a *Action) ActionPostHandoffSettings(c echo.Context) error {
+	var (
+		err             error
+		responsePayload ResponsePayload
+	)
+
+	responsePayload.Status = false
+
+	reqBody := new(entity.HandoffSettings)
+	if err = c.Bind(reqBody); err != nil {
+		responsePayload.Message = err.Error()
+
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	if err = c.Validate(reqBody); err != nil {
+		return err
+	}
+
+	uDevice := c.Get("device").(*entity.Device)
+	reqBody.DeviceId = uDevice.Id
+
+	err = a.service.Repo.SaveHandoffSettings(reqBody)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	responsePayload.Status = true
+	responsePayload.Message = "Handoff settings have been successfully saved"
+	responsePayload.Data = reqBody
+
+	return c.JSON(http.StatusOK, responsePayload)
+}
@@ -0,0 +1,80 @@
+package action
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mau.fi/whatsmeow/types"
+
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+type chatImportParam struct {
+	// Name of the account in the export, the push name of the device by default
+	Me string `form:"me"`
+	// IANA time zone of the phone the chat was exported from, the server time
+	// zone by default
+	TimeZone string `form:"tz" validate:"omitempty,timezone"`
+}
+
+// ActionPostChatImport stores the messages of a WhatsApp chat export, the
+// .txt transcript or the .zip archive with media, uploaded in the file field.
+func (a *Action) ActionPostChatImport(c echo.Context) error {
+	var (
+		err             error
+		responsePayload ResponsePayload
+	)
+
+	responsePayload.Status = false
+
+	jid, err := types.ParseJID(c.Param("jid"))
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	param := new(chatImportParam)
+	if err = c.Bind(param); err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	if err = c.Validate(param); err != nil {
+		return err
+	}
+
+	loc := time.Local
+	if param.TimeZone != "" {
+		if loc, err = time.LoadLocation(param.TimeZone); err != nil {
+			responsePayload.Message = err.Error()
+			return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+		}
+	}
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+	defer file.Close()
+
+	uDevice := c.Get("device").(*entity.Device)
+	result, err := a.service.ImportChat(uDevice, jid, file, header.Size, param.Me, loc)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	responsePayload.Status = true
+	responsePayload.Message = "Chat has been successfully imported"
+	responsePayload.Data = result
+
+	return c.JSON(http.StatusOK, responsePayload)
+}
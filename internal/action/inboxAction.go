@@ -0,0 +1,178 @@
+package action
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.mau.fi/whatsmeow/types"
+
+	"github.com/perigiweb/go-wa-api/internal/service"
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+type chatAssignPayload struct {
+	Assignee string `json:"assignee" validate:"max=255"`
+}
+
+type chatTransferPayload struct {
+	Assignee string `json:"assignee" validate:"required,max=255"`
+}
+
+type chatDetailsPayload struct {
+	Priority string   `json:"priority" validate:"required,oneof=low normal high urgent"`
+	Labels   []string `json:"labels" validate:"dive,required,max=64"`
+}
+
+func (a *Action) ActionGetChat(c echo.Context) error {
+	var responsePayload ResponsePayload
+
+	responsePayload.Status = false
+
+	jid, err := types.ParseJID(c.Param("jid"))
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	uDevice := c.Get("device").(*entity.Device)
+	userChat, err := a.service.Repo.GetUserChat(uDevice.Id, jid)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	responsePayload.Status = true
+	responsePayload.Data = userChat
+
+	return c.JSON(http.StatusOK, responsePayload)
+}
+
+func (a *Action) ActionPatchChat(c echo.Context) error {
+	var (
+		err             error
+		responsePayload ResponsePayload
+	)
+
+	responsePayload.Status = false
+
+	jid, err := types.ParseJID(c.Param("jid"))
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	reqBody := new(chatDetailsPayload)
+	if err = c.Bind(reqBody); err != nil {
+		responsePayload.Message = err.Error()
+
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	if err = c.Validate(reqBody); err != nil {
+		return err
+	}
+
+	uDevice := c.Get("device").(*entity.Device)
+	userChat, err := a.service.UpdateChatDetails(uDevice.Id, jid, reqBody.Priority, reqBody.Labels)
+
+	return a.chatResponse(c, userChat, err)
+}
+
+func (a *Action) ActionPostAssignChat(c echo.Context) error {
+	var (
+		err             error
+		responsePayload ResponsePayload
+	)
+
+	responsePayload.Status = false
+
+	jid, err := types.ParseJID(c.Param("jid"))
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	reqBody := new(chatAssignPayload)
+	if err = c.Bind(reqBody); err != nil {
+		responsePayload.Message = err.Error()
+
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	if err = c.Validate(reqBody); err != nil {
+		return err
+	}
+
+	uDevice := c.Get("device").(*entity.Device)
+	userChat, err := a.service.AssignChat(uDevice.Id, jid, reqBody.Assignee)
+
+	return a.chatResponse(c, userChat, err)
+}
+
+func (a *Action) ActionPostTransferChat(c echo.Context) error {
+	var (
+		err             error
+		responsePayload ResponsePayload
+	)
+
+	responsePayload.Status = false
+
+	jid, err := types.ParseJID(c.Param("jid"))
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	reqBody := new(chatTransferPayload)
+	if err = c.Bind(reqBody); err != nil {
+		responsePayload.Message = err.Error()
+
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	if err = c.Validate(reqBody); err != nil {
+		return err
+	}
+
+	uDevice := c.Get("device").(*entity.Device)
+	userChat, err := a.service.TransferChat(uDevice.Id, jid, reqBody.Assignee)
+
+	return a.chatResponse(c, userChat, err)
+}
+
+func (a *Action) ActionPostResolveChat(c echo.Context) error {
+	return a.setChatStatus(c, service.ConversationResolved)
+}
+
+func (a *Action) ActionPostReopenChat(c echo.Context) error {
+	return a.setChatStatus(c, service.ConversationOpen)
+}
+
+func (a *Action) setChatStatus(c echo.Context, status string) error {
+	var responsePayload ResponsePayload
+
+	jid, err := types.ParseJID(c.Param("jid"))
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	uDevice := c.Get("device").(*entity.Device)
+	userChat, err := a.service.SetChatStatus(uDevice.Id, jid, status)
+
+	return a.chatResponse(c, userChat, err)
+}
+
+func (a *Action) chatResponse(c echo.Context, userChat *entity.UserChat, err error) error {
+	var responsePayload ResponsePayload
+
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	responsePayload.Status = true
+	responsePayload.Data = userChat
+
+	return c.JSON(http.StatusOK, responsePayload)
+}
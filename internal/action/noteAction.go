@@ -0,0 +1,192 @@
+package action
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mau.fi/whatsmeow/types"
+
+	"github.com/perigiweb/go-wa-api/internal/service"
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+var shortcodeRegex = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+func (a *Action) ActionGetChatNotes(c echo.Context) error {
+	var responsePayload ResponsePayload
+
+	responsePayload.Status = false
+
+	jid, err := types.ParseJID(c.Param("jid"))
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	uDevice := c.Get("device").(*entity.Device)
+	notes, err := a.service.Repo.GetChatNotes(uDevice.Id, jid, time.Time{}, time.Time{})
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	responsePayload.Status = true
+	responsePayload.Data = notes
+
+	return c.JSON(http.StatusOK, responsePayload)
+}
+
+func (a *Action) ActionPostChatNote(c echo.Context) error {
+	var (
+		err             error
+		responsePayload ResponsePayload
+	)
+
+	responsePayload.Status = false
+
+	jid, err := types.ParseJID(c.Param("jid"))
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	reqBody := new(entity.ChatNote)
+	if err = c.Bind(reqBody); err != nil {
+		responsePayload.Message = err.Error()
+
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	if err = c.Validate(reqBody); err != nil {
+		return err
+	}
+
+	uDevice := c.Get("device").(*entity.Device)
+	reqBody.DeviceId = uDevice.Id
+	reqBody.TheirJID = jid
+
+	err = a.service.Repo.InsertChatNote(reqBody)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	responsePayload.Status = true
+	responsePayload.Message = "Note has been successfully saved"
+	responsePayload.Data = reqBody
+
+	return c.JSON(http.StatusCreated, responsePayload)
+}
+
+func (a *Action) ActionDeleteChatNote(c echo.Context) error {
+	var responsePayload ResponsePayload
+
+	code := http.StatusOK
+	responsePayload.Status = true
+
+	jid, err := types.ParseJID(c.Param("jid"))
+	if err == nil {
+		var noteId int64
+
+		noteId, err = strconv.ParseInt(c.Param("noteId"), 10, 64)
+		if err == nil {
+			uDevice := c.Get("device").(*entity.Device)
+			err = a.service.Repo.DeleteChatNote(noteId, uDevice.Id, jid)
+		}
+	}
+
+	if err != nil {
+		code = http.StatusUnprocessableEntity
+		responsePayload.Status = false
+		responsePayload.Message = err.Error()
+	}
+
+	return c.JSON(code, responsePayload)
+}
+
+func (a *Action) ActionGetCannedResponses(c echo.Context) error {
+	var responsePayload ResponsePayload
+
+	uDevice := c.Get("device").(*entity.Device)
+	responses, err := a.service.Repo.GetCannedResponses(uDevice.Id)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	responsePayload.Status = true
+	responsePayload.Data = responses
+
+	return c.JSON(http.StatusOK, responsePayload)
+}
+
+func (a *Action) ActionPostCannedResponse(c echo.Context) error {
+	var (
+		err             error
+		responsePayload ResponsePayload
+	)
+
+	responsePayload.Status = false
+
+	reqBody := new(entity.CannedResponse)
+	if err = c.Bind(reqBody); err != nil {
+		responsePayload.Message = err.Error()
+
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	if err = c.Validate(reqBody); err != nil {
+		return err
+	}
+
+	reqBody.Shortcode = service.NormalizeShortcode(reqBody.Shortcode)
+	if !shortcodeRegex.MatchString(reqBody.Shortcode) {
+		responsePayload.Message = "Shortcode can only contain letters, numbers, - and _"
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	uDevice := c.Get("device").(*entity.Device)
+	if reqBody.Id != 0 {
+		if _, err = a.service.Repo.GetCannedResponse(reqBody.Id, uDevice.Id); err != nil {
+			responsePayload.Message = "Can't find canned response with ID: " + strconv.FormatInt(reqBody.Id, 10)
+			return c.JSON(http.StatusNotFound, responsePayload)
+		}
+	}
+	reqBody.DeviceId = uDevice.Id
+
+	err = a.service.Repo.SaveCannedResponse(reqBody)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	responsePayload.Status = true
+	responsePayload.Message = "Canned response has been successfully saved"
+	responsePayload.Data = reqBody
+
+	return c.JSON(http.StatusCreated, responsePayload)
+}
+
+func (a *Action) ActionDeleteCannedResponse(c echo.Context) error {
+	var responsePayload ResponsePayload
+
+	code := http.StatusOK
+	responsePayload.Status = true
+
+	cannedId, err := strconv.ParseInt(c.Param("cannedId"), 10, 64)
+	if err == nil {
+		uDevice := c.Get("device").(*entity.Device)
+		err = a.service.Repo.DeleteCannedResponse(cannedId, uDevice.Id)
+	}
+
+	if err != nil {
+		code = http.StatusUnprocessableEntity
+		responsePayload.Status = false
+		responsePayload.Message = err.Error()
+	}
+
+	return c.JSON(code, responsePayload)
+}
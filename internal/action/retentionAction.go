@@ -0,0 +1,114 @@
+package action
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+// Purges listed by ActionGetRetentionPurges
+const retentionPurgesLimit = 50
+
+type retentionPreviewResponsePayload struct {
+	Policy *entity.RetentionPolicy `json:"policy"`
+	Purge  *entity.RetentionPurge  `json:"purge"`
+}
+
+func (a *Action) ActionGetRetentionSettings(c echo.Context) error {
+	var responsePayload ResponsePayload
+
+	settings, err := a.service.Repo.GetRetentionSettings(a.user.UserId, retentionDeviceId(c))
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	responsePayload.Status = true
+	responsePayload.Data = settings
+
+	return c.JSON(http.StatusOK, responsePayload)
+}
+
+func (a *Action) ActionPostRetentionSettings(c echo.Context) error {
+	var (
+		err             error
+		responsePayload ResponsePayload
+	)
+
+	responsePayload.Status = false
+
+	reqBody := new(entity.RetentionSettings)
+	if err = c.Bind(reqBody); err != nil {
+		responsePayload.Message = err.Error()
+
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	if err = c.Validate(reqBody); err != nil {
+		return err
+	}
+
+	reqBody.UserId = a.user.UserId
+	reqBody.DeviceId = retentionDeviceId(c)
+
+	err = a.service.Repo.SaveRetentionSettings(reqBody)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	responsePayload.Status = true
+	responsePayload.Message = "Retention settings have been successfully saved"
+	responsePayload.Data = reqBody
+
+	return c.JSON(http.StatusOK, responsePayload)
+}
+
+// ActionGetRetentionPreview counts the data of the device the next purge
+// would delete.
+func (a *Action) ActionGetRetentionPreview(c echo.Context) error {
+	var responsePayload ResponsePayload
+
+	uDevice := c.Get("device").(*entity.Device)
+	policy, purge, err := a.service.PreviewRetention(uDevice.Id)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	responsePayload.Status = true
+	responsePayload.Data = retentionPreviewResponsePayload{
+		Policy: policy,
+		Purge:  purge,
+	}
+
+	return c.JSON(http.StatusOK, responsePayload)
+}
+
+func (a *Action) ActionGetRetentionPurges(c echo.Context) error {
+	var responsePayload ResponsePayload
+
+	uDevice := c.Get("device").(*entity.Device)
+	purges, err := a.service.Repo.GetRetentionPurges(uDevice.Id, retentionPurgesLimit)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	responsePayload.Status = true
+	responsePayload.Data = purges
+
+	return c.JSON(http.StatusOK, responsePayload)
+}
+
+// retentionDeviceId returns the device of the route, the retention settings
+// of the user are handled outside of device routes.
+func retentionDeviceId(c echo.Context) string {
+	if uDevice, ok := c.Get("device").(*entity.Device); ok {
+		return uDevice.Id
+	}
+
+	return ""
+}
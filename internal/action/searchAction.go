@@ -0,0 +1,117 @@
+package action
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"go.mau.fi/whatsmeow/types"
+
+	"github.com/perigiweb/go-wa-api/internal/store"
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+type messageSearchParam struct {
+	Query  string    `query:"q" validate:"required,min=2"`
+	Chat   types.JID `query:"chat"`
+	Sender string    `query:"sender"`
+	From   string    `query:"from"`
+	To     string    `query:"to"`
+	Type   string    `query:"type" validate:"omitempty,oneof=text image video audio document sticker location contact reaction poll buttons_response list_response unknown"`
+	Page   int       `query:"page"`
+}
+
+type messageSearchResponsePayload struct {
+	Results  []store.MessageSearchResult `json:"results"`
+	Total    int                         `json:"total"`
+	PrevPage int                         `json:"prevPage"`
+	NextPage int                         `json:"nextPage"`
+	Limit    int                         `json:"limit"`
+}
+
+// parseSearchDate accepts a date or an RFC 3339 timestamp. A date used as the
+// end of a range includes the whole day.
+func parseSearchDate(v string, end bool) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.ParseInLocation(time.DateOnly, v, time.Local)
+	if err != nil {
+		return time.Parse(time.RFC3339, v)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+
+	return t, nil
+}
+
+func (a *Action) ActionGetSearchMessages(c echo.Context) error {
+	var (
+		err             error
+		responsePayload ResponsePayload
+	)
+
+	responsePayload.Status = false
+	reqQuery := new(messageSearchParam)
+	if err = c.Bind(reqQuery); err != nil {
+		responsePayload.Message = err.Error()
+
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	if err = c.Validate(reqQuery); err != nil {
+		return err
+	}
+
+	limit := 50
+	page := reqQuery.Page
+	if page < 1 {
+		page = 1
+	}
+	offset := (page - 1) * limit
+
+	search := store.MessageSearch{
+		Query:  reqQuery.Query,
+		Chat:   reqQuery.Chat,
+		Sender: reqQuery.Sender,
+		Kind:   reqQuery.Type,
+		Limit:  limit,
+		Offset: offset,
+	}
+	if search.From, err = parseSearchDate(reqQuery.From, false); err == nil {
+		search.To, err = parseSearchDate(reqQuery.To, true)
+	}
+	if err != nil {
+		responsePayload.Message = "Invalid date range: " + err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	uDevice := c.Get("device").(*entity.Device)
+	results, total, err := a.service.Repo.SearchWAMessages(uDevice.Id, search)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	prevPage := 0
+	if page > 1 {
+		prevPage = page - 1
+	}
+	nextPage := 0
+	if (limit + offset) < total {
+		nextPage = page + 1
+	}
+
+	responsePayload.Status = true
+	responsePayload.Data = messageSearchResponsePayload{
+		Results:  results,
+		Total:    total,
+		PrevPage: prevPage,
+		NextPage: nextPage,
+		Limit:    limit,
+	}
+
+	return c.JSON(http.StatusOK, responsePayload)
+}
@@ -0,0 +1,62 @@
+package action
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+func (a *Action) ActionGetSenderSettings(c echo.Context) error {
+	var responsePayload ResponsePayload
+
+	uDevice := c.Get("device").(*entity.Device)
+	settings, err := a.service.Repo.GetSenderSettings(uDevice.Id)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	responsePayload.Status = true
+	responsePayload.Data = settings
+
+	return c.JSON(http.StatusOK, responsePayload)
+}
+
+// ActionPostSenderSettings sets the rate limits of the broadcast sender of
+// the device, the sender uses them from its next message.
+func (a *Action) ActionPostSenderSettings(c echo.Context) error {
+	var (
+		err             error
+		responsePayload ResponsePayload
+	)
+
+	responsePayload.Status = false
+
+	reqBody := new(entity.SenderSettings)
+	if err = c.Bind(reqBody); err != nil {
+		responsePayload.Message = err.Error()
+
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	if err = c.Validate(reqBody); err != nil {
+		return err
+	}
+
+	uDevice := c.Get("device").(*entity.Device)
+	reqBody.DeviceId = uDevice.Id
+
+	err = a.service.Repo.SaveSenderSettings(reqBody)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	responsePayload.Status = true
+	responsePayload.Message = "Sender settings have been successfully saved"
+	responsePayload.Data = reqBody
+
+	return c.JSON(http.StatusOK, responsePayload)
+}
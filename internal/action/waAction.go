@@ -3,19 +3,20 @@ package action
 import (
 	"log"
 	"net/http"
-	"time"
 
 	"github.com/labstack/echo/v4"
 	"go.mau.fi/whatsmeow"
 	"go.mau.fi/whatsmeow/types"
 
+	"github.com/perigiweb/go-wa-api/internal/store"
 	"github.com/perigiweb/go-wa-api/internal/store/entity"
 )
 
 type waQRResponsePayload struct {
-	QRImage   string         `json:"qrImage"`
-	QRTimeout int            `json:"qrTimeout"`
-	Device    *entity.Device `json:"device"`
+	QRImage     string              `json:"qrImage"`
+	QRTimeout   int                 `json:"qrTimeout"`
+	Device      *entity.Device      `json:"device"`
+	HistorySync *entity.HistorySync `json:"historySync"`
 }
 
 func (a *Action) ActionPostWhatsAppQR(c echo.Context) error {
@@ -38,12 +39,19 @@ func (a *Action) ActionPostWhatsAppQR(c echo.Context) error {
 		return c.JSON(http.StatusOK, responsePayload)
 	}
 
+	historySync, err := a.service.Repo.GetHistorySync(uDevice.Id)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusOK, responsePayload)
+	}
+
 	responsePayload.Status = true
 	responsePayload.Message = "Generate QR"
 	responsePayload.Data = waQRResponsePayload{
-		QRImage:   qrImage,
-		QRTimeout: qrTimeout,
-		Device:    uDevice,
+		QRImage:     qrImage,
+		QRTimeout:   qrTimeout,
+		Device:      uDevice,
+		HistorySync: historySync,
 	}
 
 	return c.JSON(http.StatusOK, responsePayload)
@@ -51,9 +59,11 @@ func (a *Action) ActionPostWhatsAppQR(c echo.Context) error {
 
 type waSendMsgPayload struct {
 	Recipient    string              `json:"recipient" validate:"required"`
-	Message      string              `json:"message" validate:"required"`
+	Message      string              `json:"message" validate:"required_without=Canned"`
 	MessageType  string              `json:"mType"`
 	UploadedFile entity.UploadedFile `json:"uploadedFile"`
+	// Shortcode of a canned response sent instead of message
+	Canned string `json:"canned"`
 }
 
 func (a *Action) ActionPostSendMessage(c echo.Context) error {
@@ -80,6 +90,14 @@ func (a *Action) ActionPostSendMessage(c echo.Context) error {
 
 	uDevice := c.Get("device").(*entity.Device)
 
+	if reqBody.Canned != "" {
+		reqBody.Message, err = a.service.ExpandCannedResponse(uDevice.Id, reqBody.Canned, reqBody.Recipient)
+		if err != nil {
+			responsePayload.Message = err.Error()
+			return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+		}
+	}
+
 	sendResponse, err = a.service.SendMessage(uDevice.Id, reqBody.Recipient, reqBody.Message, reqBody.UploadedFile)
 
 	if err != nil {
@@ -87,6 +105,10 @@ func (a *Action) ActionPostSendMessage(c echo.Context) error {
 		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
 	}
 
+	if err = a.service.TouchChat(uDevice.Id, reqBody.Recipient); err != nil {
+		log.Printf("TouchChat Error: %s", err.Error())
+	}
+
 	responsePayload.Status = true
 	responsePayload.Data = sendResponse
 
@@ -294,29 +316,103 @@ func (a *Action) ActionPostMarkAsRead(c echo.Context) error {
 	return c.JSON(http.StatusOK, responsePayload)
 }
 
+type cursorParam struct {
+	Before string `query:"before"`
+	After  string `query:"after"`
+	Limit  int    `query:"limit" validate:"min=0,max=200"`
+}
+
+// cursor returns the cursor of the query, before and after take the ID of a
+// message, the one the response returned.
+func (p *cursorParam) cursor() store.Cursor {
+	cur := store.Cursor{Id: p.Before, Limit: p.Limit}
+	if cur.Limit == 0 {
+		cur.Limit = store.DefaultPageLimit
+	}
+
+	if p.After != "" {
+		cur.Id = p.After
+		cur.After = true
+	}
+
+	return cur
+}
+
+type chatsParam struct {
+	cursorParam
+	Assignee string `query:"assignee"`
+	Status   string `query:"status" validate:"omitempty,oneof=open pending resolved"`
+}
+
+// Before is the cursor of the older page, empty when there is none. After is
+// the cursor of the newer entries, to poll for new ones.
+type chatsResponsePayload struct {
+	Chats  []*store.Chat `json:"chats"`
+	Before string        `json:"before"`
+	After  string        `json:"after"`
+	Limit  int           `json:"limit"`
+}
+
 func (a *Action) ActionGetChats(c echo.Context) error {
 	var (
+		err             error
 		responsePayload ResponsePayload
 	)
 
 	responsePayload.Status = false
+	reqQuery := new(chatsParam)
+	if err = c.Bind(reqQuery); err != nil {
+		responsePayload.Message = err.Error()
+
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	if err = c.Validate(reqQuery); err != nil {
+		return err
+	}
+
 	uDevice := c.Get("device").(*entity.Device)
-	chats, err := a.service.Repo.GetWAChats(uDevice.Id)
+	cur := reqQuery.cursor()
+	if err = a.service.Repo.ResolveCursor(uDevice.Id, &cur); err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	chats, hasMore, err := a.service.GetChats(uDevice.Id, reqQuery.Assignee, reqQuery.Status, cur)
 	if err != nil {
 		responsePayload.Message = err.Error()
 		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
 	}
 
+	payload := chatsResponsePayload{
+		Chats: chats,
+		Limit: cur.Limit,
+	}
+	if len(chats) > 0 {
+		if hasMore || cur.After {
+			payload.Before = chats[len(chats)-1].Message.ID
+		}
+		payload.After = chats[0].Message.ID
+	}
+
 	responsePayload.Status = true
-	responsePayload.Data = chats
+	responsePayload.Data = payload
 
 	return c.JSON(http.StatusOK, responsePayload)
 }
 
 type conversationParam struct {
+	cursorParam
 	ChatId types.JID `query:"c" validate:"required"`
 }
 
+type conversationResponsePayload struct {
+	Messages []entity.UserMessage `json:"messages"`
+	Before   string               `json:"before"`
+	After    string               `json:"after"`
+	Limit    int                  `json:"limit"`
+}
+
 func (a *Action) ActionGetConversation(c echo.Context) error {
 	var (
 		err             error
@@ -338,15 +434,58 @@ func (a *Action) ActionGetConversation(c echo.Context) error {
 	}
 
 	uDevice := c.Get("device").(*entity.Device)
+	cur := reqQuery.cursor()
+	if err = a.service.Repo.ResolveCursor(uDevice.Id, &cur); err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
 
-	messages, err = a.service.Repo.GetWaConversation(uDevice.Id, reqQuery.ChatId, time.Time{})
+	messages, hasMore, err := a.service.Repo.GetWaConversation(uDevice.Id, reqQuery.ChatId, cur)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	// Notes can't be used as cursors, point to the messages around them
+	payload := conversationResponsePayload{
+		Messages: messages,
+		Limit:    cur.Limit,
+	}
+	ids := make([]string, 0, len(messages))
+	for _, m := range messages {
+		if m.Note == nil {
+			ids = append(ids, m.ID)
+		}
+	}
+	if len(ids) > 0 {
+		if hasMore || cur.After {
+			payload.Before = ids[len(ids)-1]
+		}
+		payload.After = ids[0]
+	}
+
+	responsePayload.Status = true
+	responsePayload.Data = payload
+
+	return c.JSON(http.StatusOK, responsePayload)
+}
+
+// ActionGetMessageReceipts lists which participants of a group received and
+// read a message sent in it.
+func (a *Action) ActionGetMessageReceipts(c echo.Context) error {
+	var responsePayload ResponsePayload
+
+	responsePayload.Status = false
+
+	uDevice := c.Get("device").(*entity.Device)
+	receipts, err := a.service.Repo.GetMessageReceipts(uDevice.Id, c.Param("messageId"))
 	if err != nil {
 		responsePayload.Message = err.Error()
 		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
 	}
 
 	responsePayload.Status = true
-	responsePayload.Data = messages
+	responsePayload.Data = receipts
 
 	return c.JSON(http.StatusOK, responsePayload)
 }
@@ -0,0 +1,218 @@
+package action
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+// Characters of a webhook secret shown when the webhooks are listed
+const webhookSecretShown = 4
+
+// maskWebhookSecret hides the secret of a webhook but its last characters.
+func maskWebhookSecret(secret string) string {
+	if len(secret) <= webhookSecretShown {
+		return strings.Repeat("*", len(secret))
+	}
+
+	return strings.Repeat("*", len(secret)-webhookSecretShown) + secret[len(secret)-webhookSecretShown:]
+}
+
+func (a *Action) ActionGetWebhooks(c echo.Context) error {
+	var responsePayload ResponsePayload
+
+	uDevice := c.Get("device").(*entity.Device)
+	webhooks, err := a.service.Repo.GetWebhooks(uDevice.Id)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+	for _, webhook := range webhooks {
+		webhook.Secret = maskWebhookSecret(webhook.Secret)
+	}
+
+	responsePayload.Status = true
+	responsePayload.Data = webhooks
+
+	return c.JSON(http.StatusOK, responsePayload)
+}
+
+func (a *Action) ActionPostWebhook(c echo.Context) error {
+	var (
+		err             error
+		responsePayload ResponsePayload
+	)
+
+	responsePayload.Status = false
+
+	reqBody := new(entity.Webhook)
+	if err = c.Bind(reqBody); err != nil {
+		responsePayload.Message = err.Error()
+
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	if err = c.Validate(reqBody); err != nil {
+		return err
+	}
+
+	uDevice := c.Get("device").(*entity.Device)
+	if reqBody.Id != 0 {
+		webhook, err := a.service.Repo.GetWebhook(reqBody.Id, uDevice.Id)
+		if err != nil {
+			responsePayload.Message = "Can't find webhook with ID: " + strconv.FormatInt(reqBody.Id, 10)
+			return c.JSON(http.StatusNotFound, responsePayload)
+		}
+		// An edit without active keeps the webhook as it is
+		if reqBody.Active == nil {
+			reqBody.Active = webhook.Active
+		}
+		// So does an edit without the secret, or with the masked one listed
+		if reqBody.Secret == "" || reqBody.Secret == maskWebhookSecret(webhook.Secret) {
+			reqBody.Secret = webhook.Secret
+		}
+	}
+	if reqBody.Secret == "" {
+		responsePayload.Message = "Secret is required"
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+	reqBody.DeviceId = uDevice.Id
+
+	err = a.service.Repo.SaveWebhook(reqBody)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	responsePayload.Status = true
+	responsePayload.Message = "Webhook has been successfully saved"
+	reqBody.Secret = maskWebhookSecret(reqBody.Secret)
+	responsePayload.Data = reqBody
+
+	return c.JSON(http.StatusCreated, responsePayload)
+}
+
+func (a *Action) ActionDeleteWebhook(c echo.Context) error {
+	var responsePayload ResponsePayload
+
+	code := http.StatusOK
+	responsePayload.Status = true
+
+	webhookId, err := strconv.ParseInt(c.Param("webhookId"), 10, 64)
+	if err == nil {
+		uDevice := c.Get("device").(*entity.Device)
+		err = a.service.Repo.DeleteWebhook(webhookId, uDevice.Id)
+	}
+
+	if err != nil {
+		code = http.StatusUnprocessableEntity
+		responsePayload.Status = false
+		responsePayload.Message = err.Error()
+	}
+
+	return c.JSON(code, responsePayload)
+}
+
+type webhookDeliveriesResponsePayload struct {
+	Deliveries []*entity.WebhookDelivery `json:"deliveries"`
+	Total      int                       `json:"total"`
+	PrevPage   int                       `json:"prevPage"`
+	NextPage   int                       `json:"nextPage"`
+	Limit      int                       `json:"limit"`
+}
+
+func (a *Action) ActionGetWebhookDeliveries(c echo.Context) error {
+	var responsePayload ResponsePayload
+
+	responsePayload.Status = false
+
+	webhook, err := a.getDeviceWebhook(c)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusNotFound, responsePayload)
+	}
+
+	limit := 50
+	page, err := strconv.Atoi(c.QueryParam("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	offset := (page - 1) * limit
+
+	deliveries, total, err := a.service.Repo.GetWebhookDeliveries(webhook.Id, limit, offset)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	prevPage := 0
+	if page > 1 {
+		prevPage = page - 1
+	}
+	nextPage := 0
+	if (limit + offset) < total {
+		nextPage = page + 1
+	}
+
+	responsePayload.Status = true
+	responsePayload.Data = webhookDeliveriesResponsePayload{
+		Deliveries: deliveries,
+		Total:      total,
+		PrevPage:   prevPage,
+		NextPage:   nextPage,
+		Limit:      limit,
+	}
+
+	return c.JSON(http.StatusOK, responsePayload)
+}
+
+func (a *Action) ActionPostReplayWebhookDelivery(c echo.Context) error {
+	var responsePayload ResponsePayload
+
+	responsePayload.Status = false
+
+	webhook, err := a.getDeviceWebhook(c)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusNotFound, responsePayload)
+	}
+
+	deliveryId, err := strconv.ParseInt(c.Param("deliveryId"), 10, 64)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	delivery, err := a.service.Repo.GetWebhookDelivery(deliveryId, webhook.Id)
+	if err != nil {
+		responsePayload.Message = "Can't find delivery with ID: " + c.Param("deliveryId")
+		return c.JSON(http.StatusNotFound, responsePayload)
+	}
+
+	err = a.service.ReplayWebhookDelivery(webhook, delivery)
+	if err != nil {
+		responsePayload.Message = err.Error()
+		return c.JSON(http.StatusUnprocessableEntity, responsePayload)
+	}
+
+	responsePayload.Status = true
+	responsePayload.Message = "Delivery has been queued for replay"
+	responsePayload.Data = delivery
+
+	return c.JSON(http.StatusAccepted, responsePayload)
+}
+
+func (a *Action) getDeviceWebhook(c echo.Context) (*entity.Webhook, error) {
+	webhookId, err := strconv.ParseInt(c.Param("webhookId"), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	uDevice := c.Get("device").(*entity.Device)
+
+	return a.service.Repo.GetWebhook(webhookId, uDevice.Id)
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+// Messages older than this are part of an offline sync, don't answer them
+const autoReplyMaxMessageAge = 10 * time.Minute
+
+type AutoReplyMatch struct {
+	Rule       *entity.AutoReplyRule `json:"rule"`
+	InCooldown bool                  `json:"inCooldown"`
+}
+
+type autoReplyRegexp struct {
+	keyword string
+	re      *regexp.Regexp
+}
+
+// The compiled keywords of the regex rules by rule ID, a rule is compiled
+// when it is saved or, after a restart, the first time it is matched
+var autoReplyRegexps sync.Map
+
+// SaveAutoReplyRule saves the rule and caches its compiled regex keyword.
+func (s *Service) SaveAutoReplyRule(rule *entity.AutoReplyRule) error {
+	if err := s.Repo.SaveAutoReplyRule(rule); err != nil {
+		return err
+	}
+
+	autoReplyRegexps.Delete(rule.Id)
+	if rule.MatchType == "regex" {
+		_, _ = compileAutoReplyRule(rule)
+	}
+
+	return nil
+}
+
+func compileAutoReplyRule(rule *entity.AutoReplyRule) (*regexp.Regexp, error) {
+	if cached, ok := autoReplyRegexps.Load(rule.Id); ok && cached.(autoReplyRegexp).keyword == rule.Keyword {
+		return cached.(autoReplyRegexp).re, nil
+	}
+
+	re, err := regexp.Compile(rule.Keyword)
+	if err != nil {
+		return nil, err
+	}
+	autoReplyRegexps.Store(rule.Id, autoReplyRegexp{keyword: rule.Keyword, re: re})
+
+	return re, nil
+}
+
+func autoReplyMatches(rule *entity.AutoReplyRule, text string) bool {
+	text = strings.TrimSpace(text)
+
+	switch rule.MatchType {
+	case "exact":
+		return strings.EqualFold(text, strings.TrimSpace(rule.Keyword))
+	case "contains":
+		return strings.Contains(strings.ToLower(text), strings.ToLower(rule.Keyword))
+	case "regex":
+		re, err := compileAutoReplyRule(rule)
+		if err != nil {
+			log.Printf("AutoReply rule %d invalid regex: %s", rule.Id, err.Error())
+			return false
+		}
+		return re.MatchString(text)
+	}
+
+	return false
+}
+
+// autoReplyActiveAt reports whether t is within the active hours of the rule,
+// t is in the time zone of the device. A rule without active hours is always
+// active, a window where activeTo is before activeFrom spans midnight.
+func autoReplyActiveAt(rule *entity.AutoReplyRule, t time.Time) bool {
+	if rule.ActiveFrom == "" || rule.ActiveTo == "" {
+		return true
+	}
+
+	now := t.Format("15:04")
+	if rule.ActiveFrom <= rule.ActiveTo {
+		return now >= rule.ActiveFrom && now < rule.ActiveTo
+	}
+
+	return now >= rule.ActiveFrom || now < rule.ActiveTo
+}
+
+// MatchAutoReply returns the highest priority rule of the device that answers
+// the text, or nil. When chat is not empty the cooldown of the rule for that
+// chat is checked too.
+func (s *Service) MatchAutoReply(deviceId string, chat types.JID, text string, isGroup bool, at time.Time) (*AutoReplyMatch, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, nil
+	}
+
+	rules, err := s.Repo.GetActiveAutoReplyRules(deviceId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rule := range rules {
+		if isGroup && !rule.IncludeGroups {
+			continue
+		}
+		if !autoReplyActiveAt(rule, at) || !autoReplyMatches(rule, text) {
+			continue
+		}
+
+		match := &AutoReplyMatch{Rule: rule}
+		if rule.CooldownSeconds > 0 && !chat.IsEmpty() {
+			lastAt, err := s.Repo.GetLastAutoReplyAt(rule.Id, chat)
+			if err != nil {
+				return nil, err
+			}
+			match.InCooldown = !lastAt.IsZero() && at.Sub(lastAt) < time.Duration(rule.CooldownSeconds)*time.Second
+		}
+
+		return match, nil
+	}
+
+	return nil, nil
+}
+
+func (s *Service) handleAutoReply(uDevice *entity.Device, evtMsg *events.Message) {
+	text := (&entity.WAMessage{Message: evtMsg.Message}).Text()
+
+	match, err := s.MatchAutoReply(uDevice.Id, evtMsg.Info.Chat, text, evtMsg.Info.IsGroup, time.Now().In(uDevice.Location()))
+	if err != nil {
+		log.Printf("MatchAutoReply Error: %s", err.Error())
+		return
+	}
+	if match == nil {
+		return
+	}
+	if match.InCooldown {
+		log.Printf("AutoReply rule %d to %s suppressed by its %ds cooldown", match.Rule.Id, evtMsg.Info.Chat, match.Rule.CooldownSeconds)
+		return
+	}
+
+	var media entity.UploadedFile
+	if match.Rule.ReplyMedia != nil {
+		media = *match.Rule.ReplyMedia
+	}
+
+	r, err := s.sendWAMessage(uDevice.Id, evtMsg.Info.Chat, match.Rule.ReplyMessage, media)
+	if err != nil {
+		log.Printf("AutoReply rule %d to %s Error: %s", match.Rule.Id, evtMsg.Info.Chat, err.Error())
+		return
+	}
+
+	err = s.Repo.InsertAutoReplyLog(&entity.AutoReplyLog{
+		RuleId:         match.Rule.Id,
+		DeviceId:       uDevice.Id,
+		TheirJID:       evtMsg.Info.Chat,
+		MessageId:      evtMsg.Info.ID,
+		ReplyMessageId: r.ID,
+	})
+	if err != nil {
+		log.Printf("InsertAutoReplyLog Error: %s", err.Error())
+	}
+}
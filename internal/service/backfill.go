@@ -0,0 +1,77 @@
+package service
+
+import (
+	"log"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+const messageBackfillBatch = 500
+
+// StartMessageBackfill fills the message columns of the messages stored
+// before they existed, in batches in the background.
+func (s *Service) StartMessageBackfill() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		total := 0
+		for {
+			n, err := s.backfillMessages()
+			if err != nil {
+				log.Printf("Message backfill Error: %s", err.Error())
+				return
+			}
+			total += n
+			if n < messageBackfillBatch {
+				break
+			}
+
+			select {
+			case <-s.ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
+		}
+
+		if total > 0 {
+			log.Printf("Message backfill done, %d messages", total)
+		}
+	}()
+}
+
+func (s *Service) backfillMessages() (int, error) {
+	messages, deviceJids, err := s.Repo.GetWAMessagesToNormalize(messageBackfillBatch)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, m := range messages {
+		// A message flagged as unreadable only gets its kind
+		if m.Kind == "" {
+			m.Normalize()
+			if m.Kind == "" {
+				m.Kind = "unknown"
+			}
+
+			// The sender of an old message is only known outside of groups
+			if m.FromMe {
+				m.Sender = deviceJids[i]
+			} else if m.TheirJID.Server != types.GroupServer {
+				m.Sender = m.TheirJID
+			}
+		}
+
+		// A message that can't be updated only gets the unknown kind, the
+		// next batch would pick it again otherwise
+		if err = s.Repo.UpdateWAMessageColumns(m); err != nil {
+			log.Printf("Message backfill: message %s of device %s Error: %s", m.ID, m.DeviceId, err.Error())
+			if err = s.Repo.MarkWAMessageUnknown(m.DeviceId, m.ID); err != nil {
+				return i, err
+			}
+		}
+	}
+
+	return len(messages), nil
+}
@@ -0,0 +1,302 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+	"gopkg.in/yaml.v3"
+
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+// A flow state can jump to its next state without waiting for the contact,
+// stop following those jumps after this many to break loops
+const botFlowMaxSteps = 10
+
+var botFlowVariableRegex = regexp.MustCompile(`\{\{\s*([\w.]+)\s*\}\}`)
+
+// chatLock is the lock of a chat and how many goroutines hold it or wait for
+// it, the last one to release it removes it
+type chatLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+var (
+	chatLocksMu sync.Mutex
+	chatLocks   = make(map[string]*chatLock)
+)
+
+// lockChat serializes the automations of a chat, messages of the same chat
+// are handled in their own goroutines.
+func lockChat(deviceId string, chat types.JID) func() {
+	key := deviceId + "|" + chat.String()
+
+	chatLocksMu.Lock()
+	l, ok := chatLocks[key]
+	if !ok {
+		l = &chatLock{}
+		chatLocks[key] = l
+	}
+	l.refs++
+	chatLocksMu.Unlock()
+
+	l.mu.Lock()
+
+	return func() {
+		l.mu.Unlock()
+
+		chatLocksMu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(chatLocks, key)
+		}
+		chatLocksMu.Unlock()
+	}
+}
+
+// ParseBotFlowDefinition parses a flow written in YAML or JSON and checks
+// every state it refers to exists.
+func ParseBotFlowDefinition(src string) (*entity.BotFlowDefinition, error) {
+	var def entity.BotFlowDefinition
+
+	if err := yaml.Unmarshal([]byte(src), &def); err != nil {
+		return nil, err
+	}
+
+	if len(def.Triggers) == 0 {
+		return nil, errors.New("flow needs at least one trigger")
+	}
+	if _, ok := def.States[def.Start]; !ok {
+		return nil, errors.New("start state \"" + def.Start + "\" is not defined")
+	}
+	if def.Timeout < 0 {
+		return nil, errors.New("timeout should not be negative")
+	}
+
+	checkNext := func(from string, next string) error {
+		if _, ok := def.States[next]; next != "" && !ok {
+			return errors.New("state \"" + from + "\" refers to undefined state \"" + next + "\"")
+		}
+		return nil
+	}
+
+	for name, state := range def.States {
+		if strings.TrimSpace(state.Message) == "" {
+			return nil, errors.New("state \"" + name + "\" has no message")
+		}
+		if err := checkNext(name, state.Next); err != nil {
+			return nil, err
+		}
+		for _, option := range state.Options {
+			if len(option.Match) == 0 || option.Next == "" {
+				return nil, errors.New("options of state \"" + name + "\" need match and next")
+			}
+			if err := checkNext(name, option.Next); err != nil {
+				return nil, err
+			}
+		}
+		if state.Input != nil {
+			if state.Input.Variable == "" {
+				return nil, errors.New("input of state \"" + name + "\" needs a variable")
+			}
+			if _, err := regexp.Compile(state.Input.Pattern); err != nil {
+				return nil, errors.New("input pattern of state \"" + name + "\": " + err.Error())
+			}
+			if err := checkNext(name, state.Input.Next); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &def, nil
+}
+
+func renderBotMessage(message string, variables map[string]string) string {
+	return botFlowVariableRegex.ReplaceAllStringFunc(message, func(m string) string {
+		return variables[botFlowVariableRegex.FindStringSubmatch(m)[1]]
+	})
+}
+
+func botFlowTriggered(def *entity.BotFlowDefinition, text string) bool {
+	for _, trigger := range def.Triggers {
+		if strings.EqualFold(strings.TrimSpace(trigger), text) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// handleIncomingMessage runs the automations of the device for a received
+// message. Nothing is automated while agents handle the chat, otherwise a
+// bot flow owning the chat takes precedence over auto replies.
+func (s *Service) handleIncomingMessage(uDevice *entity.Device, evtMsg *events.Message) {
+	if evtMsg.IsEdit || evtMsg.Info.Chat.String() == "status@broadcast" || time.Since(evtMsg.Info.Timestamp) > autoReplyMaxMessageAge {
+		return
+	}
+
+	unlock := lockChat(uDevice.Id, evtMsg.Info.Chat)
+	defer unlock()
+
+	// Messages sent from the phone are agent activity too, the messages of
+	// the contact don't keep the chat from going back to the bot
+	if evtMsg.Info.IsFromMe {
+		if err := s.Repo.TouchUserChat(uDevice.Id, evtMsg.Info.Chat); err != nil {
+			log.Printf("TouchUserChat %s Error: %s", evtMsg.Info.Chat, err.Error())
+		}
+		return
+	}
+	s.reopenChat(uDevice.Id, evtMsg.Info.Chat)
+
+	text := (&entity.WAMessage{Message: evtMsg.Message}).Text()
+	automate, err := s.handleHandoff(uDevice.Id, evtMsg.Info.Chat, text)
+	if err != nil {
+		log.Printf("Handoff %s Error: %s", evtMsg.Info.Chat, err.Error())
+	}
+	if !automate {
+		return
+	}
+
+	if !evtMsg.Info.IsGroup {
+		handled, err := s.handleBotFlow(uDevice, evtMsg)
+		if err != nil {
+			log.Printf("BotFlow %s Error: %s", evtMsg.Info.Chat, err.Error())
+		}
+		if handled {
+			return
+		}
+	}
+
+	s.handleAutoReply(uDevice, evtMsg)
+}
+
+// handleBotFlow advances the flow session of the chat, or starts a flow when
+// the message matches one of its triggers. It reports whether the message has
+// been handled by a flow.
+func (s *Service) handleBotFlow(uDevice *entity.Device, evtMsg *events.Message) (bool, error) {
+	chat := evtMsg.Info.Chat
+	text := strings.TrimSpace((&entity.WAMessage{Message: evtMsg.Message}).Text())
+
+	session, err := s.Repo.GetBotSession(uDevice.Id, chat)
+	if err != nil {
+		return false, err
+	}
+
+	var (
+		flow  *entity.BotFlow
+		ended bool
+	)
+	if session != nil {
+		if session.ExpiresAt != nil && session.ExpiresAt.Before(time.Now()) {
+			log.Printf("BotFlow %d session of %s expired in state %s", session.FlowId, chat, session.State)
+			session, ended = nil, true
+		} else if flow, err = s.Repo.GetBotFlow(session.FlowId, uDevice.Id); err != nil || !flow.Active {
+			session, flow, ended = nil, nil, true
+		}
+	}
+
+	if session == nil {
+		flows, err := s.Repo.GetActiveBotFlows(uDevice.Id)
+		if err != nil {
+			return false, err
+		}
+
+		for _, f := range flows {
+			if botFlowTriggered(f.Definition, text) {
+				flow = f
+				break
+			}
+		}
+		if flow == nil {
+			if ended {
+				_ = s.Repo.DeleteBotSession(uDevice.Id, chat)
+			}
+			return false, nil
+		}
+
+		session = &entity.BotSession{
+			DeviceId: uDevice.Id,
+			TheirJID: chat,
+			FlowId:   flow.Id,
+			Variables: map[string]string{
+				"name":  evtMsg.Info.PushName,
+				"phone": chat.User,
+			},
+		}
+
+		return true, s.enterBotState(flow, session, flow.Definition.Start)
+	}
+
+	def := flow.Definition
+	state := def.States[session.State]
+
+	for _, option := range state.Options {
+		for _, m := range option.Match {
+			if strings.EqualFold(strings.TrimSpace(m), text) {
+				session.Variables[session.State] = text
+				return true, s.enterBotState(flow, session, option.Next)
+			}
+		}
+	}
+
+	if state.Input != nil && text != "" {
+		if re := regexp.MustCompile(state.Input.Pattern); re.MatchString(text) {
+			session.Variables[state.Input.Variable] = text
+			return true, s.enterBotState(flow, session, state.Input.Next)
+		}
+	}
+
+	if def.Fallback != "" {
+		if err = s.sendBotMessage(session, def.Fallback); err != nil {
+			return true, err
+		}
+	}
+
+	return true, s.touchBotSession(def, session)
+}
+
+// enterBotState sends the messages of the state, and of the states it moves to
+// on its own, then waits for the contact or ends the session.
+func (s *Service) enterBotState(flow *entity.BotFlow, session *entity.BotSession, stateName string) error {
+	def := flow.Definition
+
+	for step := 0; stateName != "" && step < botFlowMaxSteps; step++ {
+		state := def.States[stateName]
+		session.State = stateName
+
+		if err := s.sendBotMessage(session, state.Message); err != nil {
+			return err
+		}
+
+		if len(state.Options) > 0 || state.Input != nil {
+			return s.touchBotSession(def, session)
+		}
+
+		stateName = state.Next
+	}
+
+	// Final state reached, the flow is over
+	return s.Repo.DeleteBotSession(session.DeviceId, session.TheirJID)
+}
+
+func (s *Service) touchBotSession(def *entity.BotFlowDefinition, session *entity.BotSession) error {
+	session.ExpiresAt = nil
+	if def.Timeout > 0 {
+		expiresAt := time.Now().Add(time.Duration(def.Timeout) * time.Second)
+		session.ExpiresAt = &expiresAt
+	}
+
+	return s.Repo.SaveBotSession(session)
+}
+
+func (s *Service) sendBotMessage(session *entity.BotSession, message string) error {
+	_, err := s.SendMessage(session.DeviceId, session.TheirJID.String(), renderBotMessage(message, session.Variables), entity.UploadedFile{})
+
+	return err
+}
@@ -0,0 +1,228 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"slices"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+
+	"github.com/perigiweb/go-wa-api/internal"
+	"github.com/perigiweb/go-wa-api/internal/store"
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+// Statuses of a broadcast
+const (
+	BroadcastDraft     = "draft"
+	BroadcastScheduled = "scheduled"
+	BroadcastRunning   = "running"
+	BroadcastPaused    = "paused"
+	BroadcastCompleted = "completed"
+	BroadcastCancelled = "cancelled"
+	BroadcastFailed    = "failed"
+)
+
+// The statuses a broadcast can move to from each status, cancelled and failed
+// are final. A completed broadcast is only scheduled again to send to its
+// requeued recipients.
+var broadcastTransitions = map[string][]string{
+	BroadcastDraft:     {BroadcastScheduled, BroadcastCancelled},
+	BroadcastScheduled: {BroadcastDraft, BroadcastRunning, BroadcastPaused, BroadcastCancelled, BroadcastFailed},
+	BroadcastRunning:   {BroadcastPaused, BroadcastCompleted, BroadcastCancelled, BroadcastFailed},
+	BroadcastPaused:    {BroadcastScheduled, BroadcastRunning, BroadcastCancelled},
+	BroadcastCompleted: {BroadcastScheduled},
+}
+
+// What a user can do with a broadcast, the other transitions are made by the
+// sender or by a requeue
+const (
+	BroadcastStart  = "start"
+	BroadcastPause  = "pause"
+	BroadcastCancel = "cancel"
+)
+
+// The status each user action moves a broadcast to and the statuses it can
+// be taken from. A started broadcast is scheduled, it runs from its start
+// time.
+var broadcastActions = map[string]struct {
+	to   string
+	from []string
+}{
+	BroadcastStart:  {BroadcastScheduled, []string{BroadcastDraft, BroadcastPaused}},
+	BroadcastPause:  {BroadcastPaused, []string{BroadcastScheduled, BroadcastRunning}},
+	BroadcastCancel: {BroadcastCancelled, []string{BroadcastDraft, BroadcastScheduled, BroadcastRunning, BroadcastPaused}},
+}
+
+// broadcastCanMove reports whether a broadcast can go from a status to
+// another.
+func broadcastCanMove(from string, to string) bool {
+	return slices.Contains(broadcastTransitions[from], to)
+}
+
+// BroadcastEditable reports whether the content of a broadcast in the status
+// can still be changed.
+func BroadcastEditable(status string) bool {
+	return status == BroadcastDraft || status == BroadcastScheduled || status == BroadcastPaused
+}
+
+// SetBroadcastStatus moves the broadcast to the status when the transition
+// is allowed, userId is the user asking for it or 0 for the sender.
+func (s *Service) SetBroadcastStatus(broadcast *entity.Broadcast, status string, userId int) error {
+	if !broadcastCanMove(broadcast.Status, status) {
+		return errors.New("broadcast can't go from " + broadcast.Status + " to " + status)
+	}
+
+	changed, err := s.Repo.UpdateBroadcastStatus(broadcast.Id, broadcast.Status, status, userId)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		return store.ErrBroadcastStatusChanged
+	}
+
+	broadcast.Status = status
+
+	// The audience is fixed when the broadcast starts
+	if status == BroadcastRunning && broadcast.SnapshotAt == nil {
+		if _, err = s.Repo.SnapshotBroadcastRecipients(broadcast); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ApplyBroadcastAction starts, pauses or cancels the broadcast for the user.
+// The status an action moves to is accepted for the action too, e.g. paused
+// for pause.
+func (s *Service) ApplyBroadcastAction(broadcast *entity.Broadcast, action string, userId int) error {
+	for name, a := range broadcastActions {
+		if action == a.to {
+			action = name
+		}
+	}
+
+	a, ok := broadcastActions[action]
+	if !ok {
+		return errors.New("unknown broadcast action " + action + ", use start, pause or cancel")
+	}
+	if !slices.Contains(a.from, broadcast.Status) {
+		return errors.New("a " + broadcast.Status + " broadcast can't " + action)
+	}
+
+	return s.SetBroadcastStatus(broadcast, a.to, userId)
+}
+
+// RequeueFailedBroadcastRecipients queues the failed recipients of the
+// broadcast again, after changing its message when one is given. A completed
+// broadcast goes back to scheduled to send to them.
+func (s *Service) RequeueFailedBroadcastRecipients(broadcast *entity.Broadcast, message string, media *entity.UploadedFile, userId int) (int64, error) {
+	switch broadcast.Status {
+	case BroadcastScheduled, BroadcastRunning, BroadcastPaused, BroadcastCompleted:
+	default:
+		return 0, errors.New("recipients of a " + broadcast.Status + " broadcast can't be queued again")
+	}
+	if message != "" && broadcast.Status == BroadcastRunning {
+		return 0, errors.New("pause the broadcast to change its message")
+	}
+	if message != "" {
+		edited := *broadcast
+		edited.Message = message
+		if err := s.ValidateBroadcastMessage(&edited); err != nil {
+			return 0, err
+		}
+	}
+
+	stats, err := s.Repo.GetBroadcastStats(broadcast.Id)
+	if err != nil {
+		return 0, err
+	}
+	if stats.Failed == 0 {
+		return 0, errors.New("broadcast has no failed recipients")
+	}
+
+	status := broadcast.Status
+	if status == BroadcastCompleted {
+		status = BroadcastScheduled
+		if !broadcastCanMove(broadcast.Status, status) {
+			return 0, errors.New("broadcast can't go from " + broadcast.Status + " to " + status)
+		}
+	}
+
+	requeue := *broadcast
+	if message != "" {
+		requeue.Message = message
+		if media != nil {
+			requeue.Media = media
+		}
+	}
+
+	requeued, err := s.Repo.RequeueFailedBroadcastRecipients(&requeue, status, message != "", userId)
+	if err != nil {
+		return 0, err
+	}
+
+	*broadcast = requeue
+	if broadcast.Status != status {
+		broadcast.Status = status
+		broadcast.Completed = false
+		broadcast.CompletedAt = nil
+	}
+
+	return requeued, nil
+}
+
+// How long after a broadcast a message of its recipient counts as a reply
+const defaultBroadcastReplyWindow = 72 * time.Hour
+
+// broadcastReplyWindow is BROADCAST_REPLY_WINDOW_HOURS or 72 hours.
+func broadcastReplyWindow() time.Duration {
+	hours, err := internal.GetEnvInt("BROADCAST_REPLY_WINDOW_HOURS")
+	if err != nil || hours <= 0 {
+		return defaultBroadcastReplyWindow
+	}
+
+	return time.Duration(hours) * time.Hour
+}
+
+// attributeBroadcastReply records a message received in a private chat as
+// the reply to the last broadcast sent to the chat within the reply window.
+// It runs on the event workers, a message already attributed is left as it
+// is.
+func (s *Service) attributeBroadcastReply(m *entity.UserMessage) error {
+	if m.FromMe || m.EditOf != "" || m.TheirJID == nil {
+		return nil
+	}
+	chat := m.TheirJID.ToNonAD()
+	if chat.Server != types.DefaultUserServer && chat.Server != types.HiddenUserServer {
+		return nil
+	}
+
+	chatAlt := chat
+	if m.SenderAlt != nil {
+		chatAlt = *m.SenderAlt
+	}
+
+	at := m.Timestamp
+	recipient, err := s.Repo.SaveBroadcastReply(m.DeviceId, chat, chatAlt, m.ID, at, at.Add(-broadcastReplyWindow()))
+	if err != nil || recipient == nil {
+		return err
+	}
+
+	data := BroadcastEventData{
+		BroadcastId: recipient.BroadcastId,
+		Phone:       recipient.Phone,
+		MessageId:   m.ID,
+		Status:      "replied",
+	}
+	if broadcast, err := s.Repo.GetBroadcast(recipient.BroadcastId); err == nil {
+		data.CampaignName = broadcast.CampaignName
+	}
+	if err = s.EnqueueEvent(newEvent(m.DeviceId, EventBroadcastReplied, data)); err != nil {
+		log.Printf("EnqueueEvent (%s) Error: %s", EventBroadcastReplied, err.Error())
+	}
+
+	return nil
+}
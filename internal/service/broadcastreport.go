@@ -0,0 +1,164 @@
+package service
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+// Broadcast report formats
+const (
+	BroadcastReportCSV  = "csv"
+	BroadcastReportXLSX = "xlsx"
+)
+
+const (
+	broadcastReportSheet      = "Recipients"
+	broadcastReportTimeLayout = "2006-01-02 15:04:05"
+)
+
+var broadcastReportHeader = []string{
+	"Name", "Phone", "Status", "Sent At", "Message ID", "Delivered At", "Read At", "Replied At", "Reply Message ID",
+	"Attempts", "Error", "Message",
+}
+
+// BroadcastReportFileName returns the name of the report of a broadcast.
+func BroadcastReportFileName(broadcast *entity.Broadcast, format string) string {
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, broadcast.CampaignName)
+
+	return "Broadcast " + strconv.FormatInt(broadcast.Id, 10) + " " + name + "." + format
+}
+
+// WriteBroadcastReport writes every recipient of the broadcast to w as CSV or
+// an Excel workbook, times are written in loc.
+func (s *Service) WriteBroadcastReport(w io.Writer, broadcast *entity.Broadcast, format string, loc *time.Location) error {
+	switch format {
+	case BroadcastReportCSV:
+		return s.writeBroadcastCSV(w, broadcast, loc)
+	case BroadcastReportXLSX:
+		return s.writeBroadcastXLSX(w, broadcast, loc)
+	}
+
+	return errors.New("unknown report format \"" + format + "\"")
+}
+
+// reportCell keeps a text a spreadsheet would run as a formula, one starting
+// with =, +, -, @, a tab or a carriage return, as text by quoting it.
+func reportCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+
+	return s
+}
+
+func broadcastReportRow(r *entity.BroadcastRecipient, loc *time.Location) []string {
+	reportTime := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.In(loc).Format(broadcastReportTimeLayout)
+	}
+	reportString := func(s *string) string {
+		if s == nil {
+			return ""
+		}
+		return reportCell(*s)
+	}
+
+	return []string{
+		reportCell(r.Name),
+		reportCell(r.Phone),
+		r.SentStatus,
+		reportTime(r.SentAt),
+		reportString(r.MessageId),
+		reportTime(r.DeliveredAt),
+		reportTime(r.ReadAt),
+		reportTime(r.RepliedAt),
+		reportString(r.ReplyMessageId),
+		strconv.Itoa(r.Attempts),
+		reportString(r.Error),
+		reportString(r.Message),
+	}
+}
+
+func (s *Service) writeBroadcastCSV(w io.Writer, broadcast *entity.Broadcast, loc *time.Location) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(broadcastReportHeader); err != nil {
+		return err
+	}
+
+	err := s.Repo.EachBroadcastRecipient(broadcast.Id, func(r *entity.BroadcastRecipient) error {
+		return cw.Write(broadcastReportRow(r, loc))
+	})
+	if err != nil {
+		return err
+	}
+
+	cw.Flush()
+
+	return cw.Error()
+}
+
+func (s *Service) writeBroadcastXLSX(w io.Writer, broadcast *entity.Broadcast, loc *time.Location) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	if err := f.SetSheetName(f.GetSheetName(0), broadcastReportSheet); err != nil {
+		return err
+	}
+
+	sw, err := f.NewStreamWriter(broadcastReportSheet)
+	if err != nil {
+		return err
+	}
+
+	row := 1
+	setRow := func(values []string) error {
+		cells := make([]interface{}, len(values))
+		for i, v := range values {
+			cells[i] = v
+		}
+		cell, err := excelize.CoordinatesToCellName(1, row)
+		if err != nil {
+			return err
+		}
+		row++
+
+		return sw.SetRow(cell, cells)
+	}
+
+	if err = sw.SetColWidth(1, len(broadcastReportHeader), 20); err != nil {
+		return err
+	}
+	if err = setRow(broadcastReportHeader); err != nil {
+		return err
+	}
+
+	err = s.Repo.EachBroadcastRecipient(broadcast.Id, func(r *entity.BroadcastRecipient) error {
+		return setRow(broadcastReportRow(r, loc))
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = sw.Flush(); err != nil {
+		return err
+	}
+
+	_, err = f.WriteTo(w)
+
+	return err
+}
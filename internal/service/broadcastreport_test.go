@@ -0,0 +1,54 @@
+package service
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+func TestReportCell(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"text", "Budi", "Budi"},
+		{"empty", "", ""},
+		{"formula", "=HYPERLINK(\"x\")", "'=HYPERLINK(\"x\")"},
+		{"plus", "+6281100000001", "'+6281100000001"},
+		{"minus", "-1+1", "'-1+1"},
+		{"at", "@SUM(A1)", "'@SUM(A1)"},
+		{"tab", "\t=1", "'\t=1"},
+		{"carriage return", "\r=1", "'\r=1"},
+		{"formula later", "a=1", "a=1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := reportCell(tt.text); got != tt.want {
+				t.Errorf("reportCell() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBroadcastReportRow(t *testing.T) {
+	message := "=cmd|' /C calc'!A0"
+	sentAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	got := broadcastReportRow(&entity.BroadcastRecipient{
+		Name:       "@Budi",
+		Phone:      "6281100000001",
+		SentStatus: "sent",
+		SentAt:     &sentAt,
+		Attempts:   1,
+		Message:    &message,
+	}, time.UTC)
+
+	want := []string{"'@Budi", "6281100000001", "sent", "2024-01-02 03:04:05", "", "", "", "", "", "1", "", "'" + message}
+	if !slices.Equal(got, want) {
+		t.Errorf("broadcastReportRow() = %q, want %q", got, want)
+	}
+}
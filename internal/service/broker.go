@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/perigiweb/go-wa-api/internal"
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+const (
+	EventBroadcastSent      = "broadcast_sent"
+	EventBroadcastCompleted = "broadcast_completed"
+	EventBroadcastReplied   = "broadcast_replied"
+
+	defaultBrokerPrefix = "wa"
+)
+
+// EventPublisher publishes processed events to a message broker. Events are
+// encoded as entity.Event JSON and routed per device and event type.
+type EventPublisher interface {
+	Publish(ctx context.Context, evt *entity.Event) error
+	Close() error
+}
+
+// CommandConsumer receives send-message commands from a message broker until
+// ctx is done. Commands are acknowledged once handled, a command that fails
+// is logged and not retried.
+type CommandConsumer interface {
+	Consume(ctx context.Context, handler func(cmd *SendCommand) error) error
+}
+
+type SendCommand struct {
+	DeviceId     string              `json:"deviceId"`
+	Recipient    string              `json:"recipient"`
+	Message      string              `json:"message"`
+	UploadedFile entity.UploadedFile `json:"uploadedFile"`
+}
+
+type BroadcastEventData struct {
+	BroadcastId  int64  `json:"broadcastId"`
+	CampaignName string `json:"campaignName"`
+	Phone        string `json:"phone,omitempty"`
+	MessageId    string `json:"messageId,omitempty"`
+	Status       string `json:"status"`
+	Error        string `json:"error,omitempty"`
+}
+
+// decodeSendCommand decodes a command payload, deviceId is the device named
+// by the subject or routing key, if any. It is used when the payload doesn't
+// name the device, a payload naming another device is rejected.
+func decodeSendCommand(data []byte, deviceId string) (*SendCommand, error) {
+	var cmd SendCommand
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		return nil, err
+	}
+
+	if cmd.DeviceId == "" {
+		cmd.DeviceId = deviceId
+	}
+	if deviceId != "" && cmd.DeviceId != deviceId {
+		return nil, errors.New("deviceId " + cmd.DeviceId + " does not match the device " + deviceId + " of the subject")
+	}
+
+	if cmd.DeviceId == "" || cmd.Recipient == "" || cmd.Message == "" {
+		return nil, errors.New("deviceId, recipient and message are required")
+	}
+
+	return &cmd, nil
+}
+
+// ConnectBroker sets up the event publisher configured by EVENT_BROKER
+// (nats, redis or amqp). When EVENT_BROKER_COMMANDS is true it also starts
+// consuming send-message commands from the broker.
+func (s *Service) ConnectBroker() error {
+	broker, _ := internal.GetEnvString("EVENT_BROKER")
+	if broker == "" {
+		return nil
+	}
+
+	url, err := internal.GetEnvString("EVENT_BROKER_URL")
+	if err != nil {
+		return err
+	}
+
+	prefix, err := internal.GetEnvString("EVENT_BROKER_PREFIX")
+	if err != nil {
+		prefix = defaultBrokerPrefix
+	}
+
+	var consumer CommandConsumer
+
+	switch strings.ToLower(broker) {
+	case "nats":
+		b, err := newNatsBroker(url, prefix)
+		if err != nil {
+			return err
+		}
+		s.publisher, consumer = b, b
+	case "redis":
+		b, err := newRedisBroker(url, prefix)
+		if err != nil {
+			return err
+		}
+		s.publisher, consumer = b, b
+	case "amqp":
+		b, err := newAmqpBroker(url, prefix)
+		if err != nil {
+			return err
+		}
+		s.publisher, consumer = b, b
+	default:
+		return errors.New("unsupported event broker: " + broker)
+	}
+
+	log.Printf("Publishing events to %s broker with prefix %q", broker, prefix)
+
+	if commands, _ := internal.GetEnvBool("EVENT_BROKER_COMMANDS"); commands {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+
+			err := consumer.Consume(s.ctx, s.handleSendCommand)
+			if err != nil && s.ctx.Err() == nil {
+				log.Printf("Broker command consumer stopped: %s", err.Error())
+			}
+		}()
+	}
+
+	return nil
+}
+
+func (s *Service) handleSendCommand(cmd *SendCommand) error {
+	r, err := s.SendMessage(cmd.DeviceId, cmd.Recipient, cmd.Message, cmd.UploadedFile)
+	if err != nil {
+		log.Printf("Broker SendCommand to %s Error: %s", cmd.Recipient, err.Error())
+		return err
+	}
+
+	log.Printf("Broker SendCommand to %s: %s", cmd.Recipient, r.ID)
+
+	return nil
+}
+
+func (s *Service) closeBroker() {
+	if s.publisher == nil {
+		return
+	}
+
+	if err := s.publisher.Close(); err != nil {
+		log.Printf("Close broker Error: %s", err.Error())
+	}
+}
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+// How long the command consumer waits before connecting again
+const amqpReconnectDelay = 5 * time.Second
+
+// amqpBroker publishes events to the "<prefix>.events" topic exchange with
+// "<deviceId>.<type>" routing keys and consumes commands from the
+// "<prefix>.commands" queue bound to the "<prefix>.commands" exchange with
+// "<deviceId>.send" routing keys. A lost connection is dialed again by the
+// next publish or by the consumer.
+type amqpBroker struct {
+	url    string
+	prefix string
+	// Guards the connection and the publishing channel
+	mu     sync.Mutex
+	conn   *amqp.Connection
+	ch     *amqp.Channel
+	closed bool
+}
+
+func newAmqpBroker(url string, prefix string) (*amqpBroker, error) {
+	b := &amqpBroker{
+		url:    url,
+		prefix: prefix,
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.connect(); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// connect dials the broker and opens the publishing channel, b.mu is held.
+func (b *amqpBroker) connect() error {
+	conn, err := amqp.Dial(b.url)
+	if err != nil {
+		return err
+	}
+
+	ch, err := conn.Channel()
+	if err == nil {
+		err = ch.ExchangeDeclare(b.prefix+".events", amqp.ExchangeTopic, true, false, false, false, nil)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	b.conn, b.ch = conn, ch
+
+	return nil
+}
+
+// reconnect dials the broker again when the connection has been lost and
+// opens the publishing channel again when it has been closed, b.mu is held.
+func (b *amqpBroker) reconnect() error {
+	if b.closed {
+		return amqp.ErrClosed
+	}
+
+	if b.conn == nil || b.conn.IsClosed() {
+		log.Printf("AMQP connection lost, reconnecting")
+		return b.connect()
+	}
+
+	if b.ch.IsClosed() {
+		ch, err := b.conn.Channel()
+		if err != nil {
+			return err
+		}
+		b.ch = ch
+	}
+
+	return nil
+}
+
+func (b *amqpBroker) Publish(ctx context.Context, evt *entity.Event) error {
+	data, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+
+	// amqp.Channel is not safe for concurrent publishing
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err = b.reconnect(); err != nil {
+		return err
+	}
+
+	return b.ch.PublishWithContext(ctx, b.prefix+".events", evt.DeviceId+"."+evt.Type, false, false, amqp.Publishing{
+		ContentType:  "application/json",
+		DeliveryMode: amqp.Persistent,
+		MessageId:    evt.Id,
+		Type:         evt.Type,
+		Timestamp:    evt.Timestamp,
+		Body:         data,
+	})
+}
+
+// Consume consumes the commands until ctx is done, connecting again when the
+// connection is lost.
+func (b *amqpBroker) Consume(ctx context.Context, handler func(cmd *SendCommand) error) error {
+	for {
+		err := b.consume(ctx, handler)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err == nil {
+			err = amqp.ErrClosed
+		}
+
+		log.Printf("AMQP command consumer Error: %s, retrying in %s", err.Error(), amqpReconnectDelay)
+		if !sleepContext(ctx, amqpReconnectDelay) {
+			return nil
+		}
+	}
+}
+
+// consume consumes the commands until ctx is done or the channel is closed.
+func (b *amqpBroker) consume(ctx context.Context, handler func(cmd *SendCommand) error) error {
+	b.mu.Lock()
+	err := b.reconnect()
+	conn := b.conn
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	exchange := b.prefix + ".commands"
+	if err = ch.ExchangeDeclare(exchange, amqp.ExchangeTopic, true, false, false, false, nil); err != nil {
+		return err
+	}
+
+	q, err := ch.QueueDeclare(exchange, true, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = ch.QueueBind(q.Name, "*.send", exchange, false, nil); err != nil {
+		return err
+	}
+
+	deliveries, err := ch.ConsumeWithContext(ctx, q.Name, "", false, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	for d := range deliveries {
+		deviceId, _, _ := strings.Cut(d.RoutingKey, ".")
+		cmd, err := decodeSendCommand(d.Body, deviceId)
+		if err == nil {
+			_ = handler(cmd)
+		} else {
+			log.Printf("AMQP invalid command %s: %s", d.MessageId, err.Error())
+		}
+
+		_ = d.Ack(false)
+	}
+
+	return nil
+}
+
+func (b *amqpBroker) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	_ = b.ch.Close()
+
+	return b.conn.Close()
+}
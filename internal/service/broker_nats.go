@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+// natsBroker publishes events to "<prefix>.events.<deviceId>.<type>" and
+// consumes commands from "<prefix>.commands.<deviceId>.send".
+type natsBroker struct {
+	conn   *nats.Conn
+	prefix string
+}
+
+func newNatsBroker(url string, prefix string) (*natsBroker, error) {
+	conn, err := nats.Connect(url, nats.Name("go-wa-api"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, err
+	}
+
+	return &natsBroker{
+		conn:   conn,
+		prefix: prefix,
+	}, nil
+}
+
+func (b *natsBroker) Publish(ctx context.Context, evt *entity.Event) error {
+	data, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+
+	msg := nats.NewMsg(b.prefix + ".events." + evt.DeviceId + "." + evt.Type)
+	// Lets JetStream streams drop events published more than once
+	msg.Header.Set(nats.MsgIdHdr, evt.Id)
+	msg.Data = data
+
+	return b.conn.PublishMsg(msg)
+}
+
+func (b *natsBroker) Consume(ctx context.Context, handler func(cmd *SendCommand) error) error {
+	sub, err := b.conn.QueueSubscribe(b.prefix+".commands.*.send", "go-wa-api", func(m *nats.Msg) {
+		tokens := strings.Split(m.Subject, ".")
+		cmd, err := decodeSendCommand(m.Data, tokens[len(tokens)-2])
+		if err != nil {
+			log.Printf("NATS invalid command on %s: %s", m.Subject, err.Error())
+			return
+		}
+
+		_ = handler(cmd)
+	})
+	if err != nil {
+		return err
+	}
+
+	<-ctx.Done()
+
+	return sub.Unsubscribe()
+}
+
+func (b *natsBroker) Close() error {
+	return b.conn.Drain()
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// runNatsServer starts an embedded NATS server on port, a random one when
+// it is 0, until the test ends.
+func runNatsServer(t *testing.T, port int) *server.Server {
+	t.Helper()
+
+	if port == 0 {
+		port = server.RANDOM_PORT
+	}
+	s, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: port, NoLog: true, NoSigs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("NATS server not ready")
+	}
+	t.Cleanup(s.Shutdown)
+
+	return s
+}
+
+func newTestNatsBroker(t *testing.T, s *server.Server) *natsBroker {
+	t.Helper()
+
+	b, err := newNatsBroker(s.ClientURL(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+// natsPublishEvent publishes the test event and returns what a subscriber
+// of the events received.
+func natsPublishEvent(t *testing.T, b *natsBroker) *nats.Msg {
+	t.Helper()
+
+	sub, err := b.conn.SubscribeSync("test.events.>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+	if err = b.conn.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = b.Publish(context.Background(), testBrokerEvent()); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := sub.NextMsg(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestNatsBrokerPublish(t *testing.T) {
+	b := newTestNatsBroker(t, runNatsServer(t, 0))
+	t.Cleanup(func() { _ = b.Close() })
+
+	m := natsPublishEvent(t, b)
+	if m.Subject != "test.events.dev-1.message" {
+		t.Errorf("subject = %q, want test.events.dev-1.message", m.Subject)
+	}
+	if id := m.Header.Get(nats.MsgIdHdr); id != "evt-1" {
+		t.Errorf("%s = %q, want evt-1", nats.MsgIdHdr, id)
+	}
+	checkBrokerEvent(t, m.Data)
+}
+
+func TestNatsBrokerConsume(t *testing.T) {
+	s := runNatsServer(t, 0)
+	b := newTestNatsBroker(t, s)
+	cmds := consumeCommands(t, b)
+	waitFor(t, "the command subscription", func() bool { return b.conn.NumSubscriptions() > 0 })
+
+	for _, data := range []string{testInvalidCommand, testCommand} {
+		if err := b.conn.Publish("test.commands.dev-1.send", []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkCommand(t, receiveCommand(t, cmds, 5*time.Second))
+}
+
+func TestNatsBrokerReconnect(t *testing.T) {
+	s := runNatsServer(t, 0)
+	port := s.Addr().(*net.TCPAddr).Port
+	b := newTestNatsBroker(t, s)
+	t.Cleanup(func() { _ = b.Close() })
+
+	s.Shutdown()
+	waitFor(t, "the disconnection", func() bool { return !b.conn.IsConnected() })
+
+	runNatsServer(t, port)
+	waitFor(t, "the reconnection", func() bool { return b.conn.IsConnected() })
+
+	checkBrokerEvent(t, natsPublishEvent(t, b).Data)
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+const (
+	redisStreamMaxLen    = 10000
+	redisCommandGroup    = "go-wa-api"
+	redisCommandBlockFor = 5 * time.Second
+)
+
+// redisBroker appends events to the "<prefix>:events:<deviceId>" stream and
+// consumes commands from the "<prefix>:commands" stream with a consumer group.
+type redisBroker struct {
+	client *redis.Client
+	prefix string
+}
+
+func newRedisBroker(url string, prefix string) (*redisBroker, error) {
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		return nil, err
+	}
+
+	client := redis.NewClient(opts)
+	if err = client.Ping(context.Background()).Err(); err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+
+	return &redisBroker{
+		client: client,
+		prefix: prefix,
+	}, nil
+}
+
+func (b *redisBroker) Publish(ctx context.Context, evt *entity.Event) error {
+	data, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+
+	return b.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: b.prefix + ":events:" + evt.DeviceId,
+		MaxLen: redisStreamMaxLen,
+		Approx: true,
+		Values: map[string]any{
+			"id":      evt.Id,
+			"type":    evt.Type,
+			"payload": data,
+		},
+	}).Err()
+}
+
+func (b *redisBroker) Consume(ctx context.Context, handler func(cmd *SendCommand) error) error {
+	stream := b.prefix + ":commands"
+
+	err := b.client.XGroupCreateMkStream(ctx, stream, redisCommandGroup, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+
+	consumer, _ := os.Hostname()
+	consumer = consumer + "-" + redisCommandGroup
+
+	for ctx.Err() == nil {
+		streams, err := b.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    redisCommandGroup,
+			Consumer: consumer,
+			Streams:  []string{stream, ">"},
+			Count:    10,
+			Block:    redisCommandBlockFor,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			log.Printf("Redis XReadGroup Error: %s", err.Error())
+			time.Sleep(redisCommandBlockFor)
+			continue
+		}
+
+		for _, st := range streams {
+			for _, m := range st.Messages {
+				payload, _ := m.Values["payload"].(string)
+				cmd, err := decodeSendCommand([]byte(payload), "")
+				if err == nil {
+					_ = handler(cmd)
+				} else {
+					log.Printf("Redis invalid command %s: %s", m.ID, err.Error())
+				}
+
+				b.client.XAck(ctx, stream, redisCommandGroup, m.ID)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (b *redisBroker) Close() error {
+	return b.client.Close()
+}
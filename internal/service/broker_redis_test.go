@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedisBroker(t *testing.T) (*redisBroker, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	b, err := newRedisBroker("redis://"+mr.Addr(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b, mr
+}
+
+func TestRedisBrokerPublish(t *testing.T) {
+	b, _ := newTestRedisBroker(t)
+	t.Cleanup(func() { _ = b.Close() })
+
+	if err := b.Publish(context.Background(), testBrokerEvent()); err != nil {
+		t.Fatal(err)
+	}
+
+	messages, err := b.client.XRange(context.Background(), "test:events:dev-1", "-", "+").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 1 {
+		t.Fatalf("got %d events, want 1", len(messages))
+	}
+
+	values := messages[0].Values
+	if values["id"] != "evt-1" || values["type"] != "message" {
+		t.Errorf("id = %v, type = %v, want evt-1, message", values["id"], values["type"])
+	}
+	payload, _ := values["payload"].(string)
+	checkBrokerEvent(t, []byte(payload))
+}
+
+func TestRedisBrokerConsume(t *testing.T) {
+	b, mr := newTestRedisBroker(t)
+	cmds := consumeCommands(t, b)
+	waitFor(t, "the command group", func() bool { return mr.Exists("test:commands") })
+
+	// A command is read from the payload, the stream has no device
+	invalid := `{"recipient":"6281100000001","message":"hi"}`
+	for _, data := range []string{invalid, testCommand} {
+		err := b.client.XAdd(context.Background(), &redis.XAddArgs{
+			Stream: "test:commands",
+			Values: map[string]any{"payload": data},
+		}).Err()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkCommand(t, receiveCommand(t, cmds, 5*time.Second))
+
+	// Invalid commands are acknowledged too
+	waitFor(t, "the acknowledgements", func() bool {
+		pending, err := b.client.XPending(context.Background(), "test:commands", redisCommandGroup).Result()
+		return err == nil && pending.Count == 0
+	})
+}
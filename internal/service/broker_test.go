@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+func TestDecodeSendCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		deviceId string
+		want     string
+		wantErr  bool
+	}{
+		{"device of the subject", `{"recipient":"6281100000001","message":"hi"}`, "dev-1", "dev-1", false},
+		{"device of the payload", `{"deviceId":"dev-1","recipient":"6281100000001","message":"hi"}`, "", "dev-1", false},
+		{"same device", `{"deviceId":"dev-1","recipient":"6281100000001","message":"hi"}`, "dev-1", "dev-1", false},
+		{"other device", `{"deviceId":"dev-2","recipient":"6281100000001","message":"hi"}`, "dev-1", "", true},
+		{"no device", `{"recipient":"6281100000001","message":"hi"}`, "", "", true},
+		{"no recipient", `{"deviceId":"dev-1","message":"hi"}`, "", "", true},
+		{"no message", `{"deviceId":"dev-1","recipient":"6281100000001"}`, "", "", true},
+		{"invalid json", `{"deviceId":`, "dev-1", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd, err := decodeSendCommand([]byte(tt.data), tt.deviceId)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeSendCommand() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && cmd.DeviceId != tt.want {
+				t.Errorf("DeviceId = %q, want %q", cmd.DeviceId, tt.want)
+			}
+		})
+	}
+}
+
+// testBroker is a broker publishing events and consuming commands.
+type testBroker interface {
+	EventPublisher
+	CommandConsumer
+}
+
+func testBrokerEvent() *entity.Event {
+	return &entity.Event{
+		Id:        "evt-1",
+		Type:      "message",
+		DeviceId:  "dev-1",
+		Timestamp: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Data:      map[string]string{"text": "hi"},
+	}
+}
+
+// checkBrokerEvent checks the published data is the JSON of the event.
+func checkBrokerEvent(t *testing.T, data []byte) {
+	t.Helper()
+
+	var evt entity.Event
+	if err := json.Unmarshal(data, &evt); err != nil {
+		t.Fatal(err)
+	}
+	want := testBrokerEvent()
+	if evt.Id != want.Id || evt.Type != want.Type || evt.DeviceId != want.DeviceId || !evt.Timestamp.Equal(want.Timestamp) {
+		t.Errorf("published %+v, want %+v", evt, *want)
+	}
+}
+
+// consumeCommands consumes the commands of the broker until the test ends,
+// the broker is closed then. A consumer that stops early fails the test.
+func consumeCommands(t *testing.T, b testBroker) <-chan *SendCommand {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cmds := make(chan *SendCommand, 10)
+	done := make(chan error, 1)
+	go func() {
+		err := b.Consume(ctx, func(cmd *SendCommand) error {
+			cmds <- cmd
+			return nil
+		})
+		if ctx.Err() == nil {
+			t.Errorf("Consume() stopped: %v", err)
+		}
+		done <- err
+	}()
+
+	// As in ConnectBroker, an error once ctx is done is ignored
+	t.Cleanup(func() {
+		cancel()
+		_ = b.Close()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Error("Consume() did not return")
+		}
+	})
+
+	return cmds
+}
+
+// receiveCommand waits for the next command handled.
+func receiveCommand(t *testing.T, cmds <-chan *SendCommand, timeout time.Duration) *SendCommand {
+	t.Helper()
+
+	select {
+	case cmd := <-cmds:
+		return cmd
+	case <-time.After(timeout):
+		t.Fatal("no command received")
+	}
+
+	return nil
+}
+
+// checkCommand checks the command is the valid one the tests send.
+func checkCommand(t *testing.T, cmd *SendCommand) {
+	t.Helper()
+
+	if cmd.DeviceId != "dev-1" || cmd.Recipient != "6281100000001" || cmd.Message != "hi" {
+		t.Errorf("received %+v, want dev-1 to 6281100000001", *cmd)
+	}
+}
+
+// The commands the tests send, the first one is rejected as it names
+// another device.
+const (
+	testInvalidCommand = `{"deviceId":"dev-2","recipient":"6281100000001","message":"hi"}`
+	testCommand        = `{"deviceId":"dev-1","recipient":"6281100000001","message":"hi"}`
+)
+
+// waitFor waits until cond is true.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for " + what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"go.mau.fi/whatsmeow/types"
+)
+
+// NormalizeShortcode returns the shortcode without its leading slash, "/refund"
+// and "refund" are the same canned response.
+func NormalizeShortcode(shortcode string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(shortcode), "/"))
+}
+
+// ExpandCannedResponse returns the message of the canned response with the
+// variables filled for the recipient.
+func (s *Service) ExpandCannedResponse(deviceId string, shortcode string, recipient string) (string, error) {
+	canned, err := s.Repo.GetCannedResponseByShortcode(deviceId, NormalizeShortcode(shortcode))
+	if err == sql.ErrNoRows {
+		return "", errors.New("canned response not found: " + shortcode)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	jid, err := parseJID(recipient)
+	if err != nil {
+		return "", err
+	}
+
+	r := strings.NewReplacer(
+		"{name}", s.contactName(deviceId, jid),
+		"{phone}", jid.User,
+	)
+
+	return r.Replace(canned.Message), nil
+}
+
+// contactName returns the best known name of the contact, its phone number
+// when the device doesn't know it.
+func (s *Service) contactName(deviceId string, jid types.JID) string {
+	c := getWAClient(deviceId)
+	if c != nil {
+		if contact, err := c.Store.Contacts.GetContact(jid); err == nil {
+			if name := contactInfoName(contact); name != "" {
+				return name
+			}
+		}
+	}
+
+	return jid.User
+}
+
+// contactInfoName returns the name saved in the address book of the phone,
+// or the name the contact gave itself.
+func contactInfoName(contact types.ContactInfo) string {
+	for _, name := range []string{contact.FullName, contact.FirstName, contact.PushName, contact.BusinessName} {
+		if name != "" {
+			return name
+		}
+	}
+
+	return ""
+}
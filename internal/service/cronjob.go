@@ -2,7 +2,6 @@ package service
 
 import (
 	"log"
-	"math/rand"
 	"time"
 
 	"github.com/go-co-op/gocron/v2"
@@ -57,69 +56,50 @@ func (s *Service) CronJobs(c gocron.Scheduler) {
 	log.Printf("CheckPhone Next Run: %v; Error: %v", n, err)
 
 	/*
-	 * Cronjob for sending broadcast message
+	 * Cronjob for removing processed events from the outbox
 	 */
-	smnj, _ := c.NewJob(
-		gocron.DurationRandomJob(9*time.Minute, 11*time.Minute),
-		gocron.NewTask(func() {
-			log.Println("Send Message Job...")
+	_, err = c.NewJob(
+		gocron.DurationJob(6*time.Hour),
+		gocron.NewTask(s.cleanupOutbox),
+	)
+	if err != nil {
+		log.Printf("Outbox cleanup job Error: %s", err.Error())
+	}
 
-			broadcastToSend, err := s.Repo.GetBroadcastToSend()
-			if err == nil && broadcastToSend != nil {
-				log.Printf("Broadcast: %s, Recipient: %s (%s); Total Recipient: %d", broadcastToSend.Broadcast.CampaignName, broadcastToSend.Recipient.Name, broadcastToSend.Recipient.Phone, broadcastToSend.TotalRecipient)
-				if broadcastToSend.Recipient != nil {
-					/*
-					* Insert recipient to db
-					 */
-					err = s.Repo.InsertBroadcastRecipient(broadcastToSend.Recipient)
-					if err == nil {
-						/*
-						* Send Typing Presence and then Send Message after delay
-						 */
-						err = s.SendChatPresence(broadcastToSend.Broadcast.Device.Id, broadcastToSend.Recipient.Phone, "composing", "")
-						if err == nil {
-							delay := rand.Intn(5) + 5
-							timer := time.After(time.Duration(delay) * time.Second)
-							select {
-							case m := <-make(chan int):
-								log.Println("case make chan int...", m)
-							case <-timer:
-								log.Printf("timer ==> after %d seconds, send message...", delay)
-								sendResponse, err := s.SendBroadcastMessage(broadcastToSend)
-								log.Printf("SendResponse: %+v", sendResponse)
-								if err == nil {
-									err = s.Repo.UpdateSentStatus(broadcastToSend.Recipient.Id, "sent", sendResponse.ID, sendResponse.Timestamp)
-									log.Printf("Error UpdateSentStatus: %v", err)
-								} else {
-									log.Printf("Error SendBroadcastMessage: %+v", err.Error())
-								}
+	/*
+	 * Cronjob for sending the webhook deliveries a stopped process left pending
+	 */
+	_, err = c.NewJob(
+		gocron.DurationJob(5*time.Minute),
+		gocron.NewTask(s.ResendStaleWebhookDeliveries),
+		gocron.WithSingletonMode(gocron.LimitModeReschedule),
+	)
+	if err != nil {
+		log.Printf("Stale webhook deliveries job Error: %s", err.Error())
+	}
 
-								if broadcastToSend.TotalRecipient == 1 {
-									err = s.Repo.UpdateCompletedBroadcast(broadcastToSend.Broadcast.Id, true, time.Now())
-									if err != nil {
-										log.Printf("UpdateCompletedBroadcast Error: %s", err.Error())
-									}
-								}
-							}
-						} else {
-							log.Printf("SendChatPresence Error: %s", err.Error())
-						}
-					} else {
-						log.Printf("Error InsertBroadcastRecipient: %s", err.Error())
-					}
-				} else {
-					err = s.Repo.UpdateCompletedBroadcast(broadcastToSend.Broadcast.Id, true, time.Now())
-					if err != nil {
-						log.Printf("UpdateCompletedBroadcast Error: %s", err.Error())
-					}
-				}
-			} else {
-				log.Printf("Error: %T %s", err, err.Error())
-			}
-		}),
+	/*
+	 * Cronjob for giving the chats agents stopped answering back to the bot
+	 */
+	_, err = c.NewJob(
+		gocron.DurationJob(time.Minute),
+		gocron.NewTask(s.ReleaseIdleChats),
+	)
+	if err != nil {
+		log.Printf("Release idle chats job Error: %s", err.Error())
+	}
+
+	/*
+	 * Cronjob for deleting the data kept longer than the retention policies
+	 */
+	_, err = c.NewJob(
+		gocron.DurationJob(time.Hour),
+		gocron.NewTask(s.PurgeExpiredData),
+		gocron.WithSingletonMode(gocron.LimitModeReschedule),
 	)
-	smnr, err := smnj.NextRun()
-	log.Printf("Send Msg Next Run: %v, Error: %v", smnr, err)
+	if err != nil {
+		log.Printf("Retention purge job Error: %s", err.Error())
+	}
 
 	c.Start()
 }
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"go.mau.fi/whatsmeow/types"
+
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+const (
+	EventMessage      = "message"
+	EventReceipt      = "receipt"
+	EventConnected    = "connected"
+	EventDisconnected = "disconnected"
+	EventPairSuccess  = "pair_success"
+	EventLoggedOut    = "logged_out"
+	EventHandoff      = "handoff"
+	EventConversation = "conversation"
+)
+
+type ReceiptEventData struct {
+	Chat       types.JID         `json:"chat"`
+	Sender     types.JID         `json:"sender"`
+	SenderAlt  *types.JID        `json:"senderAlt,omitempty"`
+	MessageIds []types.MessageID `json:"messageIds"`
+	Type       string            `json:"type"`
+	Timestamp  time.Time         `json:"timestamp"`
+}
+
+// HandoffEventData is published when a chat moves between the bot and the
+// agents.
+type HandoffEventData struct {
+	Chat         types.JID `json:"chat"`
+	Mode         string    `json:"mode"`
+	PreviousMode string    `json:"previousMode"`
+	Reason       string    `json:"reason"`
+	Assignee     string    `json:"assignee,omitempty"`
+}
+
+// ConversationEventData is published when a conversation of the team inbox
+// is assigned, transferred, resolved or reopened.
+type ConversationEventData struct {
+	Chat             types.JID `json:"chat"`
+	Action           string    `json:"action"`
+	Assignee         string    `json:"assignee"`
+	PreviousAssignee string    `json:"previousAssignee,omitempty"`
+	Status           string    `json:"status"`
+}
+
+type DeviceEventData struct {
+	Jid    *types.JID `json:"jid"`
+	Reason string     `json:"reason,omitempty"`
+}
+
+func newEvent(deviceId string, eventType string, data any) *entity.Event {
+	return &entity.Event{
+		Id:        uuid.NewString(),
+		Type:      eventType,
+		DeviceId:  deviceId,
+		Timestamp: time.Now(),
+		Data:      data,
+	}
+}
+
+// publishEvent hands a processed event to every downstream consumer.
+func (s *Service) publishEvent(evt *entity.Event) error {
+	if err := s.dispatchWebhooks(evt); err != nil {
+		return err
+	}
+
+	if s.publisher != nil {
+		return s.publisher.Publish(s.ctx, evt)
+	}
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"log"
 
 	"go.mau.fi/whatsmeow"
@@ -14,14 +15,16 @@ import (
 type WAEventHandler struct {
 	client    *whatsmeow.Client
 	handlerId uint32
+	service   *Service
 	repo      *store.Repo
 	uDevice   *entity.Device
 }
 
-func registerWAEventHandler(client *whatsmeow.Client, repo *store.Repo, uDevice *entity.Device) {
+func registerWAEventHandler(s *Service, client *whatsmeow.Client, uDevice *entity.Device) {
 	var e = WAEventHandler{
 		client:  client,
-		repo:    repo,
+		service: s,
+		repo:    s.Repo,
 		uDevice: uDevice,
 	}
 
@@ -32,12 +35,28 @@ func (e *WAEventHandler) register() {
 	e.handlerId = e.client.AddEventHandler(e.handler)
 }
 
+// publish persists the event in the outbox, the store updates and the
+// downstream consumers are handled by the event workers.
+func (e *WAEventHandler) publish(eventType string, data any) {
+	err := e.service.EnqueueEvent(newEvent(e.uDevice.Id, eventType, data))
+	if err != nil {
+		log.Printf("EnqueueEvent (%s) Error: %s", eventType, err.Error())
+	}
+}
+
 func (e *WAEventHandler) saveMessage(evtMsg *events.Message) {
+	if m := e.userMessage(evtMsg); m != nil {
+		e.publish(EventMessage, m)
+	}
+}
+
+func (e *WAEventHandler) userMessage(evtMsg *events.Message) *entity.UserMessage {
 	if evtMsg.Info.Chat.String() != "status@broadcast" {
+		var editOf types.MessageID
+
 		waMsg := evtMsg.Message
 		if evtMsg.IsEdit {
-			editedKeyId := evtMsg.Message.GetProtocolMessage().GetKey().GetID()
-			e.repo.DeleteWAMessage(editedKeyId)
+			editOf = evtMsg.Message.GetProtocolMessage().GetKey().GetID()
 			waMsg = evtMsg.Message.GetProtocolMessage().GetEditedMessage()
 		}
 
@@ -45,6 +64,8 @@ func (e *WAEventHandler) saveMessage(evtMsg *events.Message) {
 			msg := &entity.WAMessage{
 				Message: waMsg,
 			}
+			sender := evtMsg.Info.Sender.ToNonAD()
+			senderAlt := e.alternativeJID(sender, evtMsg.Info.SenderAlt)
 			m := entity.UserMessage{
 				ID:          evtMsg.Info.ID,
 				TheirJID:    &evtMsg.Info.Chat,
@@ -55,12 +76,42 @@ func (e *WAEventHandler) saveMessage(evtMsg *events.Message) {
 				Type:        evtMsg.Info.Type,
 				PushName:    evtMsg.Info.PushName,
 				ReceiptType: "sent",
+				Sender:      &sender,
+				SenderAlt:   senderAlt,
+				EditOf:      editOf,
 			}
+			m.Normalize()
 
-			err := e.repo.InsertWAMessage(m)
-			log.Printf("SaveWAMessage Err: %+v", err)
+			return &m
 		}
 	}
+
+	return nil
+}
+
+// alternativeJID returns the phone number JID of a LID, or the LID of a phone
+// number JID. The address given by WhatsApp is used when it is known,
+// otherwise the mapping is looked up in the device store.
+func (e *WAEventHandler) alternativeJID(jid types.JID, alt types.JID) *types.JID {
+	if !alt.IsEmpty() {
+		alt = alt.ToNonAD()
+		return &alt
+	}
+
+	var err error
+	switch jid.Server {
+	case types.HiddenUserServer:
+		alt, err = e.client.Store.LIDs.GetPNForLID(context.Background(), jid)
+	case types.DefaultUserServer:
+		alt, err = e.client.Store.LIDs.GetLIDForPN(context.Background(), jid)
+	default:
+		return nil
+	}
+	if err != nil || alt.IsEmpty() {
+		return nil
+	}
+
+	return &alt
 }
 
 func (e *WAEventHandler) handler(evt interface{}) {
@@ -68,6 +119,9 @@ func (e *WAEventHandler) handler(evt interface{}) {
 
 	switch v := evt.(type) {
 	case *events.Connected, *events.PushNameSetting:
+		if _, ok := v.(*events.Connected); ok {
+			e.service.StartBroadcastSender(e.uDevice.Id)
+		}
 		if len(e.client.Store.PushName) == 0 {
 			return
 		}
@@ -86,6 +140,14 @@ func (e *WAEventHandler) handler(evt interface{}) {
 			return
 		}
 
+		if _, ok := v.(*events.Connected); ok {
+			e.publish(EventConnected, DeviceEventData{Jid: e.client.Store.ID})
+		}
+
+	case *events.Disconnected:
+		e.service.StopBroadcastSender(e.uDevice.Id)
+		e.publish(EventDisconnected, DeviceEventData{Jid: e.client.Store.ID})
+
 	case *events.PairSuccess:
 		log.Println("Pair Success")
 		err := e.repo.UpdateJID(v.ID, e.uDevice.Id)
@@ -94,36 +156,30 @@ func (e *WAEventHandler) handler(evt interface{}) {
 			return
 		}
 
+		e.publish(EventPairSuccess, DeviceEventData{Jid: &v.ID})
+
 	case *events.Message:
 		log.Printf("Received Message: %+v\n", v)
 		e.saveMessage(v)
+		go e.service.handleIncomingMessage(e.uDevice, v)
 
 	case *events.Receipt:
 		log.Printf("Received a receipt: %+v\n", v)
-		err := e.repo.UpdateWAMessageReceiptType(v.MessageIDs, v.Type)
-		log.Println("UpdateMessageReceipt ", err)
-		// May its a broadcast msg,
-		if v.Type == types.ReceiptTypeRead {
-			_ = e.repo.UpdateBroadcastMessageReceipt(v.MessageIDs, "read")
-		} else if v.Type == types.ReceiptTypeDelivered {
-			_ = e.repo.UpdateBroadcastMessageReceipt(v.MessageIDs, "delivered")
-		}
+		e.publish(EventReceipt, ReceiptEventData{
+			Chat:       v.Chat,
+			Sender:     v.Sender,
+			SenderAlt:  e.alternativeJID(v.Sender.ToNonAD(), v.SenderAlt),
+			MessageIds: v.MessageIDs,
+			Type:       receiptTypeName(v.Type),
+			Timestamp:  v.Timestamp,
+		})
 
 	case *events.OfflineSyncCompleted:
 		log.Printf("OfflineSyncCompleted!: %+v\n", v)
 
 	case *events.HistorySync:
-		log.Printf("HistorySync!: %+v\n", v)
-		for _, c := range v.Data.GetConversations() {
-			for _, m := range c.GetMessages() {
-				theirJID, _ := types.ParseJID(c.GetID())
-				mEvt, err := e.client.ParseWebMessage(theirJID, m.GetMessage())
-				if err == nil {
-					log.Printf("HistoryChange Message: %+v\n", mEvt)
-					e.saveMessage(mEvt)
-				}
-			}
-		}
+		log.Printf("HistorySync!: %s %d%%\n", v.Data.GetSyncType(), v.Data.GetProgress())
+		e.queueHistorySync(v)
 
 	case *events.PushName:
 		log.Printf("PushName: %+v\n", v)
@@ -139,6 +195,16 @@ func (e *WAEventHandler) handler(evt interface{}) {
 
 	case *events.LoggedOut:
 		log.Printf("LoggedOut!: %+v\n", v)
+		e.service.StopBroadcastSender(e.uDevice.Id)
+		e.publish(EventLoggedOut, DeviceEventData{Jid: e.uDevice.Jid, Reason: v.Reason.String()})
 		e.repo.DeleteDeviceById(e.uDevice.Id, e.uDevice.UserId)
 	}
 }
+
+func receiptTypeName(receiptType types.ReceiptType) string {
+	if receiptType == types.ReceiptTypeDelivered {
+		return "delivered"
+	}
+
+	return string(receiptType)
+}
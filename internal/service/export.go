@@ -0,0 +1,593 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+// Chat export formats
+const (
+	ChatExportText = "txt"
+	ChatExportJSON = "json"
+	ChatExportHTML = "html"
+	ChatExportZip  = "zip"
+)
+
+const (
+	// Timestamp of the lines of a chat exported by WhatsApp on Android
+	whatsAppExportTimeLayout = "02/01/2006, 15:04"
+	whatsAppExportHeader     = "Messages and calls are end-to-end encrypted. No one outside of this chat, not even WhatsApp, can read or listen to them."
+	whatsAppMediaOmitted     = "<Media omitted>"
+	whatsAppChatFileName     = "_chat.txt"
+)
+
+var errMediaPurged = errors.New("media has been deleted by the retention policy")
+
+// Extensions of the media files WhatsApp names itself, other types use the
+// registered extension of their mime type
+var mediaExtensions = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/webp": "webp",
+	"video/mp4":  "mp4",
+	"audio/ogg":  "opus",
+	"audio/mpeg": "mp3",
+	"audio/mp4":  "m4a",
+	"audio/aac":  "aac",
+}
+
+var chatExportTemplate = template.Must(template.New("chat").Parse(`
+{{- define "header" -}}
+<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{ .Name }}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Roboto, Helvetica, Arial, sans-serif; background: #efeae2; margin: 0; padding: 24px; color: #111b21; }
+h1 { font-size: 20px; margin: 0 0 4px; }
+.meta { color: #667781; font-size: 13px; margin-bottom: 24px; }
+.msg { max-width: 65%; margin: 6px 0; padding: 6px 9px; border-radius: 8px; background: #fff; clear: both; float: left; white-space: pre-wrap; word-wrap: break-word; }
+.msg.me { background: #d9fdd3; float: right; }
+.sender { font-size: 13px; font-weight: 600; color: #1f7aec; }
+.time { font-size: 11px; color: #667781; text-align: right; margin-top: 2px; }
+.media { font-size: 13px; color: #54656f; font-style: italic; }
+.msg img { display: block; max-width: 100%; border-radius: 6px; margin-bottom: 4px; }
+.clear { clear: both; }
+</style>
+</head>
+<body>
+<h1>{{ .Name }}</h1>
+<div class="meta">{{ .JID }} &middot; exported {{ .ExportedAt }}</div>
+{{ end -}}
+{{- define "message" -}}
+<div class="msg{{ if .FromMe }} me{{ end }}">
+{{- if .Sender }}<div class="sender">{{ .Sender }}</div>{{ end -}}
+{{- if .Thumbnail }}<img src="{{ .Thumbnail }}" alt="">{{ end -}}
+{{- if .Media }}<div class="media">{{ .Media }}</div>{{ end -}}
+{{- if .Text }}<div>{{ .Text }}</div>{{ end -}}
+<div class="time">{{ .Time }}</div>
+</div>
+{{ end -}}
+{{- define "footer" -}}
+<div class="clear"></div>
+</body>
+</html>
+{{ end -}}
+`))
+
+type chatExportHTMLMessage struct {
+	FromMe    bool
+	Sender    string
+	Thumbnail template.URL
+	Media     string
+	Text      string
+	Time      string
+}
+
+// ChatExport writes the messages of a chat.
+type ChatExport struct {
+	s        *Service
+	device   *entity.Device
+	client   *whatsmeow.Client
+	chat     types.JID
+	name     string
+	ownName  string
+	loc      *time.Location
+	names    map[types.JID]string
+	counters map[string]int
+}
+
+// NewChatExport prepares the export of a chat, times are written in loc.
+func (s *Service) NewChatExport(uDevice *entity.Device, chat types.JID, loc *time.Location) *ChatExport {
+	e := &ChatExport{
+		s:        s,
+		device:   uDevice,
+		client:   getWAClient(uDevice.Id),
+		chat:     chat,
+		ownName:  uDevice.Name,
+		loc:      loc,
+		names:    make(map[types.JID]string),
+		counters: make(map[string]int),
+	}
+
+	if e.client != nil && e.client.Store.PushName != "" {
+		e.ownName = e.client.Store.PushName
+	}
+
+	e.name = e.senderName(chat, "")
+	if chat.Server == types.GroupServer && e.client != nil {
+		if info, err := e.client.GetGroupInfo(chat); err == nil && info.Name != "" {
+			e.name = info.Name
+		}
+	}
+
+	return e
+}
+
+// Name returns the name of the chat: the name of the contact or the group.
+func (e *ChatExport) Name() string {
+	return e.name
+}
+
+// FileName returns the name WhatsApp gives to the export of the chat.
+func (e *ChatExport) FileName(format string) string {
+	name := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, e.name)
+
+	return "WhatsApp Chat with " + name + "." + format
+}
+
+// Write writes every message of the chat to w in the format: a WhatsApp
+// style transcript, JSON, a self-contained HTML page, or a zip archive of the
+// transcript with the media files.
+func (e *ChatExport) Write(w io.Writer, format string) error {
+	switch format {
+	case ChatExportText:
+		return e.writeText(w)
+	case ChatExportJSON:
+		return e.writeJSON(w)
+	case ChatExportHTML:
+		return e.writeHTML(w)
+	case ChatExportZip:
+		return e.writeZip(w)
+	}
+
+	return errors.New("unknown export format \"" + format + "\"")
+}
+
+func (e *ChatExport) senderName(jid types.JID, pushName string) string {
+	if name, ok := e.names[jid]; ok {
+		return name
+	}
+
+	name := ""
+	if e.client != nil {
+		if contact, err := e.client.Store.Contacts.GetContact(jid); err == nil {
+			name = contactInfoName(contact)
+		}
+	}
+	if name == "" {
+		name = pushName
+	}
+	if name == "" {
+		name = jid.User
+		if jid.Server == types.DefaultUserServer {
+			name = "+" + name
+		}
+	}
+
+	e.names[jid] = name
+
+	return name
+}
+
+func (e *ChatExport) messageSender(m *entity.UserMessage) string {
+	if m.FromMe {
+		return e.ownName
+	}
+
+	sender := e.chat
+	if m.Sender != nil && !m.Sender.IsEmpty() {
+		sender = *m.Sender
+	}
+
+	return e.senderName(sender, m.PushName)
+}
+
+// exported reports whether the message is part of an export, WhatsApp leaves
+// reactions and messages it can't show out.
+func exported(m *entity.UserMessage) bool {
+	switch m.Kind {
+	case "reaction":
+		return false
+	case "unknown":
+		return m.Text != ""
+	}
+
+	return true
+}
+
+// messageBody returns the text WhatsApp writes for a message in its export,
+// attached is the name of the file of a media message included in the
+// archive.
+func messageBody(m *entity.UserMessage, attached string) string {
+	msg := rawMessage(m)
+
+	switch m.Kind {
+	case "image", "video", "audio", "document", "sticker":
+		body := whatsAppMediaOmitted
+		if attached != "" {
+			body = attached + " (file attached)"
+		}
+		if m.Caption != "" {
+			body += "\n" + m.Caption
+		}
+		return body
+	case "location":
+		loc := msg.GetLocationMessage()
+		if loc == nil {
+			live := msg.GetLiveLocationMessage()
+			return fmt.Sprintf("live location shared: https://maps.google.com/?q=%v,%v", live.GetDegreesLatitude(), live.GetDegreesLongitude())
+		}
+		return fmt.Sprintf("location: https://maps.google.com/?q=%v,%v", loc.GetDegreesLatitude(), loc.GetDegreesLongitude())
+	case "contact":
+		return whatsAppMediaOmitted
+	case "poll":
+		poll := msg.GetPollCreationMessage()
+		body := "POLL:\n" + poll.GetName()
+		for _, option := range poll.GetOptions() {
+			body += "\nOPTION: " + option.GetOptionName()
+		}
+		return body
+	}
+
+	return m.Text
+}
+
+func (e *ChatExport) writeTextLine(w io.Writer, ts time.Time, sender string, body string) error {
+	line := ts.In(e.loc).Format(whatsAppExportTimeLayout) + " - "
+	if sender != "" {
+		line += sender + ": "
+	}
+
+	_, err := io.WriteString(w, line+body+"\n")
+
+	return err
+}
+
+// writeText writes the transcript the way WhatsApp exports a chat without
+// media.
+func (e *ChatExport) writeText(w io.Writer) error {
+	first := true
+
+	return e.s.Repo.EachWAMessage(e.device.Id, e.chat, func(m *entity.UserMessage) error {
+		if !exported(m) {
+			return nil
+		}
+
+		if first {
+			first = false
+			if err := e.writeTextLine(w, m.Timestamp, "", whatsAppExportHeader); err != nil {
+				return err
+			}
+		}
+
+		return e.writeTextLine(w, m.Timestamp, e.messageSender(m), messageBody(m, ""))
+	})
+}
+
+func (e *ChatExport) writeJSON(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, `{"chat":%q,"name":%s,"exportedAt":%q,"messages":[`,
+		e.chat.String(), jsonString(e.name), time.Now().In(e.loc).Format(time.RFC3339)); err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	first := true
+	err := e.s.Repo.EachWAMessage(e.device.Id, e.chat, func(m *entity.UserMessage) error {
+		if !first {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		first = false
+
+		return enc.Encode(m)
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "]}\n")
+
+	return err
+}
+
+func jsonString(s string) string {
+	b, _ := json.Marshal(s)
+
+	return string(b)
+}
+
+func (e *ChatExport) writeHTML(w io.Writer) error {
+	err := chatExportTemplate.ExecuteTemplate(w, "header", map[string]string{
+		"Name":       e.name,
+		"JID":        e.chat.String(),
+		"ExportedAt": time.Now().In(e.loc).Format(whatsAppExportTimeLayout),
+	})
+	if err != nil {
+		return err
+	}
+
+	isGroup := e.chat.Server == types.GroupServer
+	err = e.s.Repo.EachWAMessage(e.device.Id, e.chat, func(m *entity.UserMessage) error {
+		if !exported(m) {
+			return nil
+		}
+
+		hm := chatExportHTMLMessage{
+			FromMe: m.FromMe,
+			Text:   messageBody(m, ""),
+			Time:   m.Timestamp.In(e.loc).Format(whatsAppExportTimeLayout),
+		}
+		if isGroup && !m.FromMe {
+			hm.Sender = e.messageSender(m)
+		}
+		if m.Media != nil {
+			hm.Media = "[" + m.Kind + "]"
+			if m.Media.FileName != "" {
+				hm.Media += " " + m.Media.FileName
+			}
+			hm.Text = m.Caption
+		}
+		// The thumbnail goes with the media the retention policy deleted
+		if m.Media != nil && m.Media.Purged {
+			hm.Media += " (deleted)"
+		} else if thumbnail := messageThumbnail(rawMessage(m)); len(thumbnail) > 0 {
+			hm.Thumbnail = template.URL("data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(thumbnail))
+		}
+
+		return chatExportTemplate.ExecuteTemplate(w, "message", hm)
+	})
+	if err != nil {
+		return err
+	}
+
+	return chatExportTemplate.ExecuteTemplate(w, "footer", nil)
+}
+
+// chatExportLine is a line of a transcript, the body of a media message is
+// written once its file is attached.
+type chatExportLine struct {
+	ts     time.Time
+	sender string
+	body   string
+	media  *entity.UserMessage
+}
+
+// writeZip writes an archive like the one WhatsApp exports: the transcript in
+// _chat.txt with the media files next to it. Media which can't be downloaded
+// is omitted. The media is downloaded after the messages are read, a slow
+// download doesn't hold a connection to the database.
+func (e *ChatExport) writeZip(w io.Writer) error {
+	lines := make([]chatExportLine, 0)
+	err := e.s.Repo.EachWAMessage(e.device.Id, e.chat, func(m *entity.UserMessage) error {
+		if !exported(m) {
+			return nil
+		}
+
+		line := chatExportLine{ts: m.Timestamp, sender: e.messageSender(m)}
+		if m.Media != nil {
+			line.media = m
+		} else {
+			line.body = messageBody(m, "")
+		}
+		lines = append(lines, line)
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+
+	var chat bytes.Buffer
+	for i, line := range lines {
+		if i == 0 {
+			if err = e.writeTextLine(&chat, line.ts, "", whatsAppExportHeader); err != nil {
+				return err
+			}
+		}
+
+		if line.media != nil {
+			attached, err := e.attachMedia(zw, line.media)
+			if err != nil {
+				return err
+			}
+			line.body = messageBody(line.media, attached)
+		}
+
+		if err = e.writeTextLine(&chat, line.ts, line.sender, line.body); err != nil {
+			return err
+		}
+	}
+
+	f, err := zw.Create(whatsAppChatFileName)
+	if err != nil {
+		return err
+	}
+	if _, err = chat.WriteTo(f); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// attachMedia adds the file of a media message to the archive and returns its
+// name, an empty name when the message has no file or it is not available.
+func (e *ChatExport) attachMedia(zw *zip.Writer, m *entity.UserMessage) (string, error) {
+	if m.Media == nil {
+		return "", nil
+	}
+
+	data, err := e.s.loadMedia(e.client, m)
+	if err != nil {
+		log.Printf("Export media %s Error: %s", m.ID, err.Error())
+		return "", nil
+	}
+
+	name := e.mediaFileName(m)
+	f, err := zw.Create(name)
+	if err != nil {
+		return "", err
+	}
+	if _, err = f.Write(data); err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// mediaFileName names a media file the way WhatsApp does, IMG-20240131-WA0001.jpg,
+// documents keep their own name.
+func (e *ChatExport) mediaFileName(m *entity.UserMessage) string {
+	ext := mediaExtension(m.Media.Mime)
+
+	if m.Kind == "document" && m.Media.FileName != "" {
+		name := filepath.Base(m.Media.FileName)
+		e.counters["DOC-"+name]++
+		if n := e.counters["DOC-"+name]; n > 1 {
+			name = strings.TrimSuffix(name, filepath.Ext(name)) + fmt.Sprintf(" (%d)", n-1) + filepath.Ext(name)
+		}
+		return name
+	}
+
+	prefix := map[string]string{
+		"image":    "IMG",
+		"video":    "VID",
+		"audio":    "AUD",
+		"sticker":  "STK",
+		"document": "DOC",
+	}[m.Kind]
+	if m.Kind == "audio" && rawMessage(m).GetAudioMessage().GetPTT() {
+		prefix = "PTT"
+	}
+
+	prefix += "-" + m.Timestamp.In(e.loc).Format("20060102")
+	e.counters[prefix]++
+
+	return fmt.Sprintf("%s-WA%04d.%s", prefix, e.counters[prefix]-1, ext)
+}
+
+func mediaExtension(mimeType string) string {
+	mediaType, _, _ := mime.ParseMediaType(mimeType)
+
+	if ext, ok := mediaExtensions[mediaType]; ok {
+		return ext
+	}
+	if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+		return strings.TrimPrefix(exts[0], ".")
+	}
+
+	return "bin"
+}
+
+// messageThumbnail returns the JPEG thumbnail WhatsApp sends with a message.
+func messageThumbnail(msg *waE2E.Message) []byte {
+	switch {
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage().GetJPEGThumbnail()
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage().GetJPEGThumbnail()
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage().GetJPEGThumbnail()
+	case msg.GetLocationMessage() != nil:
+		return msg.GetLocationMessage().GetJPEGThumbnail()
+	}
+
+	return nil
+}
+
+func rawMessage(m *entity.UserMessage) *waE2E.Message {
+	if m.Message == nil {
+		return nil
+	}
+
+	return m.Message.Message
+}
+
+func downloadableMedia(msg *waE2E.Message) whatsmeow.DownloadableMessage {
+	switch {
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage()
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage()
+	case msg.GetAudioMessage() != nil:
+		return msg.GetAudioMessage()
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage()
+	case msg.GetStickerMessage() != nil:
+		return msg.GetStickerMessage()
+	}
+
+	return nil
+}
+
+// loadMedia returns the file of a media message from the cache, or downloads
+// it from WhatsApp and caches it.
+func (s *Service) loadMedia(client *whatsmeow.Client, m *entity.UserMessage) ([]byte, error) {
+	if m.Media != nil && m.Media.Purged {
+		return nil, errMediaPurged
+	}
+
+	path := cachedMediaPath(m.DeviceId, m.ID)
+	if data, err := os.ReadFile(path); err == nil {
+		return data, nil
+	}
+
+	dm := downloadableMedia(rawMessage(m))
+	if dm == nil {
+		return nil, errors.New("message has no media")
+	}
+	if client == nil {
+		return nil, errors.New("device is not connected")
+	}
+
+	data, err := client.Download(dm)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0o755); err == nil {
+		err = os.WriteFile(path, data, 0o644)
+	}
+	if err != nil {
+		log.Printf("Cache media %s Error: %s", m.ID, err.Error())
+	}
+
+	return data, nil
+}
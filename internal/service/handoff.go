@@ -0,0 +1,123 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"go.mau.fi/whatsmeow/types"
+
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+const (
+	ChatModeBot    = "bot"
+	ChatModeHuman  = "human"
+	ChatModePaused = "paused"
+)
+
+// Reasons of a chat mode change, sent with the handoff event
+const (
+	HandoffReasonKeyword = "keyword"
+	HandoffReasonIdle    = "idle"
+	HandoffReasonManual  = "manual"
+)
+
+// SetChatMode moves the chat to the mode and notifies the change through the
+// event pipeline. A flow running in the chat is ended when the bot hands it
+// over.
+func (s *Service) SetChatMode(deviceId string, chat types.JID, mode string, reason string) (*entity.UserChat, error) {
+	if mode != ChatModeBot && mode != ChatModeHuman && mode != ChatModePaused {
+		return nil, errors.New("invalid chat mode: " + mode)
+	}
+
+	current, err := s.Repo.GetUserChat(deviceId, chat)
+	if err != nil {
+		return nil, err
+	}
+
+	userChat, err := s.Repo.SetUserChatMode(deviceId, chat, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	if mode != ChatModeBot {
+		if err = s.Repo.DeleteBotSession(deviceId, chat); err != nil {
+			log.Printf("DeleteBotSession %s Error: %s", chat, err.Error())
+		}
+	}
+
+	if current.Mode != mode {
+		s.publishHandoff(userChat, current.Mode, reason)
+	}
+
+	return userChat, nil
+}
+
+func (s *Service) publishHandoff(userChat *entity.UserChat, previousMode string, reason string) {
+	err := s.EnqueueEvent(newEvent(userChat.DeviceId, EventHandoff, HandoffEventData{
+		Chat:         userChat.TheirJID,
+		Mode:         userChat.Mode,
+		PreviousMode: previousMode,
+		Reason:       reason,
+		Assignee:     userChat.Assignee,
+	}))
+	if err != nil {
+		log.Printf("EnqueueEvent (%s) Error: %s", EventHandoff, err.Error())
+	}
+}
+
+// handleHandoff switches the chat to the agents when the contact asks for
+// one. It reports whether automated replies are allowed for the message.
+func (s *Service) handleHandoff(deviceId string, chat types.JID, text string) (bool, error) {
+	userChat, err := s.Repo.GetUserChat(deviceId, chat)
+	if err != nil {
+		return false, err
+	}
+	if userChat.Mode != ChatModeBot {
+		return false, nil
+	}
+
+	settings, err := s.Repo.GetHandoffSettings(deviceId)
+	if err != nil {
+		return false, err
+	}
+	if !strings.EqualFold(strings.TrimSpace(text), strings.TrimSpace(settings.Keyword)) {
+		return true, nil
+	}
+
+	if _, err = s.SetChatMode(deviceId, chat, ChatModeHuman, HandoffReasonKeyword); err != nil {
+		return false, err
+	}
+
+	if settings.HandoffMessage != "" {
+		if _, err = s.sendWAMessage(deviceId, chat, settings.HandoffMessage, entity.UploadedFile{}); err != nil {
+			log.Printf("Handoff message to %s Error: %s", chat, err.Error())
+		}
+	}
+
+	return false, nil
+}
+
+// TouchChat records activity of an agent in the chat, it keeps the chat from
+// going back to the bot.
+func (s *Service) TouchChat(deviceId string, recipient string) error {
+	chat, err := parseJID(recipient)
+	if err != nil {
+		return err
+	}
+
+	return s.Repo.TouchUserChat(deviceId, chat)
+}
+
+// ReleaseIdleChats gives the chats agents stopped answering back to the bot.
+func (s *Service) ReleaseIdleChats() {
+	chats, err := s.Repo.ReleaseIdleUserChats()
+	if err != nil {
+		log.Printf("ReleaseIdleUserChats Error: %s", err.Error())
+	}
+
+	for _, userChat := range chats {
+		s.publishHandoff(userChat, ChatModeHuman, HandoffReasonIdle)
+	}
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"log"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+)
+
+// Chunks waiting for the history sync worker, the event handler blocks when
+// the queue is full
+const historySyncQueueSize = 32
+
+type historySyncJob struct {
+	handler *WAEventHandler
+	evt     *events.HistorySync
+}
+
+// StartHistorySyncWorker imports the history chunks sent by WhatsApp off the
+// event goroutine of the devices. Chunks are imported one at a time in the
+// order they arrive.
+func (s *Service) StartHistorySyncWorker() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		for {
+			select {
+			case <-s.ctx.Done():
+				if n := len(s.historySyncs); n > 0 {
+					log.Printf("HistorySync: %d queued chunks dropped on shutdown", n)
+				}
+				return
+			case job := <-s.historySyncs:
+				job.handler.importHistorySync(job.evt)
+			}
+		}
+	}()
+}
+
+func (e *WAEventHandler) queueHistorySync(evt *events.HistorySync) {
+	select {
+	case e.service.historySyncs <- historySyncJob{handler: e, evt: evt}:
+	case <-e.service.ctx.Done():
+		log.Printf("HistorySync %s (%s): chunk dropped on shutdown, %d%% synced",
+			e.uDevice.Id, evt.Data.GetSyncType(), evt.Data.GetProgress())
+	}
+}
+
+func (e *WAEventHandler) importHistorySync(evt *events.HistorySync) {
+	conversations := evt.Data.GetConversations()
+	messages := 0
+
+	for _, c := range conversations {
+		theirJID, err := types.ParseJID(c.GetID())
+		if err != nil {
+			continue
+		}
+
+		for _, m := range c.GetMessages() {
+			mEvt, err := e.client.ParseWebMessage(theirJID, m.GetMessage())
+			if err != nil {
+				continue
+			}
+			if userMessage := e.userMessage(mEvt); userMessage != nil {
+				e.publish(EventMessage, userMessage)
+				messages++
+			}
+		}
+	}
+
+	hs, err := e.repo.AddHistorySyncProgress(e.uDevice.Id, int(evt.Data.GetProgress()), len(conversations), messages)
+	if err != nil {
+		log.Printf("AddHistorySyncProgress Error: %s", err.Error())
+		return
+	}
+
+	log.Printf("HistorySync %s (%s): %d conversations, %d messages, %d%%",
+		e.uDevice.Id, evt.Data.GetSyncType(), hs.Conversations, hs.Messages, hs.Progress)
+}
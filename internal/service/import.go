@@ -0,0 +1,496 @@
+package service
+
+import (
+	"archive/zip"
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/perigiweb/go-wa-api/internal"
+	"github.com/perigiweb/go-wa-api/internal/store"
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+// Prefix of the IDs given to imported messages, exports don't keep the IDs
+const importedMessageIdPrefix = "IMPORT"
+
+// How large an uploaded export and each of its media files may be by default
+const defaultChatImportMaxSizeMB = 100
+
+var (
+	// A line starting a message of a chat export, the date and time format
+	// depend on the locale of the phone:
+	//
+	//	31/12/2023, 21:41 - Name: text
+	//	12/31/23, 9:41 PM - Name: text
+	//	[31.12.23, 21:41:05] Name: text
+	exportLineRegex = regexp.MustCompile(`^\x{200e}?\[?(\d{1,4})[./-](\d{1,2})[./-](\d{1,4}),?\s+(\d{1,2})[:.](\d{2})(?:[:.](\d{2}))?(?:[\s\x{202f}\x{a0}]*([AaPp])\.?\s?[Mm]\.?)?\]?\s*(?:-\s)?(.*)$`)
+	// Android: IMG-20240101-WA0001.jpg (file attached)
+	exportAttachedRegex = regexp.MustCompile(`^\x{200e}?(.+\.\w+) \(file attached\)$`)
+	// iOS: <attached: 00000012-PHOTO-2024-01-01-10-00-00.jpg>
+	exportAttachedIOSRegex = regexp.MustCompile(`^\x{200e}?<attached: (.+)>$`)
+	exportOmittedRegex     = regexp.MustCompile(`^\x{200e}?(?:<Media omitted>|(?:image|video|audio|sticker|GIF|document) omitted)$`)
+	exportPhoneRegex       = regexp.MustCompile(`^\+[\d\s()-]+$`)
+)
+
+// ChatImportResult counts what an import did with the messages of an export.
+type ChatImportResult struct {
+	Messages   int `json:"messages"`
+	Imported   int `json:"imported"`
+	Duplicates int `json:"duplicates"`
+	Media      int `json:"media"`
+}
+
+type exportDateOrder int
+
+const (
+	exportDayMonthYear exportDateOrder = iota
+	exportMonthDayYear
+	exportYearMonthDay
+)
+
+type exportLine struct {
+	date     [3]string
+	hour     int
+	minute   int
+	second   int
+	seconds  bool
+	meridiem string
+	sender   string
+	body     string
+}
+
+// parseChatExport splits a chat export into its messages, the lines without
+// timestamp continue the message before them. System lines, which have no
+// sender, are left out.
+func parseChatExport(r io.Reader) ([]*exportLine, error) {
+	lines := make([]*exportLine, 0)
+	var last *exportLine
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		text := strings.TrimRight(scanner.Text(), "\r")
+
+		match := exportLineRegex.FindStringSubmatch(text)
+		if match == nil {
+			if last != nil {
+				last.body += "\n" + text
+			}
+			continue
+		}
+
+		last = nil
+		sender, body, ok := strings.Cut(match[8], ": ")
+		if !ok {
+			continue
+		}
+
+		line := &exportLine{
+			date:     [3]string{match[1], match[2], match[3]},
+			meridiem: strings.ToUpper(match[7]),
+			sender:   strings.Trim(sender, "\u200e\u202a\u202c"),
+			body:     strings.TrimLeft(body, "\u200e"),
+			seconds:  match[6] != "",
+		}
+		line.hour, _ = strconv.Atoi(match[4])
+		line.minute, _ = strconv.Atoi(match[5])
+		line.second, _ = strconv.Atoi(match[6])
+
+		lines = append(lines, line)
+		last = line
+	}
+
+	return lines, scanner.Err()
+}
+
+// exportDateOrderOf guesses the order of the dates of an export: a day is
+// found by being over 12, the US order is assumed with 12 hour times.
+func exportDateOrderOf(lines []*exportLine) exportDateOrder {
+	meridiem := false
+
+	for _, line := range lines {
+		if len(line.date[0]) == 4 {
+			return exportYearMonthDay
+		}
+		if n, _ := strconv.Atoi(line.date[0]); n > 12 {
+			return exportDayMonthYear
+		}
+		if n, _ := strconv.Atoi(line.date[1]); n > 12 {
+			return exportMonthDayYear
+		}
+		meridiem = meridiem || line.meridiem != ""
+	}
+
+	if meridiem {
+		return exportMonthDayYear
+	}
+
+	return exportDayMonthYear
+}
+
+func (line *exportLine) time(order exportDateOrder, loc *time.Location) (time.Time, error) {
+	var y, m, d string
+	switch order {
+	case exportYearMonthDay:
+		y, m, d = line.date[0], line.date[1], line.date[2]
+	case exportMonthDayYear:
+		m, d, y = line.date[0], line.date[1], line.date[2]
+	default:
+		d, m, y = line.date[0], line.date[1], line.date[2]
+	}
+
+	year, _ := strconv.Atoi(y)
+	month, _ := strconv.Atoi(m)
+	day, _ := strconv.Atoi(d)
+	if len(y) <= 2 {
+		year += 2000
+	}
+
+	hour := line.hour
+	switch {
+	case line.meridiem == "P" && hour < 12:
+		hour += 12
+	case line.meridiem == "A" && hour == 12:
+		hour = 0
+	}
+
+	if month < 1 || month > 12 || day < 1 || day > 31 || hour > 23 || line.minute > 59 || line.second > 59 {
+		return time.Time{}, errors.New("invalid date " + strings.Join(line.date[:], "/"))
+	}
+
+	return time.Date(year, time.Month(month), day, hour, line.minute, line.second, 0, loc), nil
+}
+
+// precision returns how long the time of the line may be off, exports keep
+// the minute and sometimes the second a message was sent.
+func (line *exportLine) precision() time.Duration {
+	if line.seconds {
+		return time.Second
+	}
+
+	return time.Minute
+}
+
+// attachment returns the name of the file attached to the message and the
+// rest of its body, the caption.
+func (line *exportLine) attachment() (string, string) {
+	first, rest, _ := strings.Cut(line.body, "\n")
+
+	if match := exportAttachedRegex.FindStringSubmatch(first); match != nil {
+		return match[1], rest
+	}
+	if match := exportAttachedIOSRegex.FindStringSubmatch(first); match != nil {
+		return match[1], rest
+	}
+
+	return "", line.body
+}
+
+// ChatImportMaxSize is how many bytes an uploaded export and each of its
+// media files may have, IMPORT_MAX_SIZE_MB or 100 MB.
+func ChatImportMaxSize() int64 {
+	size, err := internal.GetEnvInt("IMPORT_MAX_SIZE_MB")
+	if err != nil || size <= 0 {
+		size = defaultChatImportMaxSizeMB
+	}
+
+	return int64(size) << 20
+}
+
+// chatImport holds what is needed while the messages of an export are
+// imported.
+type chatImport struct {
+	s       *Service
+	tx      *store.WAMessageImport
+	device  *entity.Device
+	chat    types.JID
+	me      string
+	files   map[string]*zip.File
+	senders map[string]*types.JID
+	seen    map[string]int
+	similar map[string]int
+	media   []importedMedia
+}
+
+// importedMedia is a media file of the export to cache once its message is
+// stored.
+type importedMedia struct {
+	messageId types.MessageID
+	file      *zip.File
+}
+
+// ImportChat stores the messages of a WhatsApp chat export in the chat, the
+// export is a .txt transcript or a .zip archive with the media files. me is
+// the name of the account in the export, the name of the device by default,
+// and loc the time zone of the phone it was exported from. Messages already
+// stored are skipped, importing the same export again is a no-op. The
+// messages are stored in one transaction, none are when one fails.
+func (s *Service) ImportChat(uDevice *entity.Device, chat types.JID, r io.ReaderAt, size int64, me string, loc *time.Location) (*ChatImportResult, error) {
+	imp := &chatImport{
+		s:       s,
+		device:  uDevice,
+		chat:    chat,
+		me:      me,
+		files:   make(map[string]*zip.File),
+		senders: make(map[string]*types.JID),
+		seen:    make(map[string]int),
+		similar: make(map[string]int),
+	}
+
+	if imp.me == "" {
+		imp.me = uDevice.Name
+		if c := getWAClient(uDevice.Id); c != nil && c.Store.PushName != "" {
+			imp.me = c.Store.PushName
+		}
+	}
+
+	var transcript io.Reader = io.NewSectionReader(r, 0, size)
+	if zr, err := zip.NewReader(r, size); err == nil {
+		var chatFile *zip.File
+		for _, f := range zr.File {
+			if strings.EqualFold(path.Ext(f.Name), ".txt") && (chatFile == nil || path.Base(f.Name) == whatsAppChatFileName) {
+				chatFile = f
+			}
+			imp.files[path.Base(f.Name)] = f
+		}
+		if chatFile == nil {
+			return nil, errors.New("archive has no chat transcript")
+		}
+
+		rc, err := chatFile.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		transcript = rc
+	}
+
+	lines, err := parseChatExport(transcript)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, errors.New("no messages found, the file is not a WhatsApp chat export")
+	}
+
+	imp.loadSenders()
+
+	if imp.tx, err = s.Repo.BeginWAMessageImport(); err != nil {
+		return nil, err
+	}
+	defer imp.tx.Rollback()
+
+	result := &ChatImportResult{Messages: len(lines)}
+	order := exportDateOrderOf(lines)
+	for _, line := range lines {
+		ts, err := line.time(order, loc)
+		if err != nil {
+			return nil, err
+		}
+
+		inserted, err := imp.importLine(line, ts)
+		if err != nil {
+			return nil, err
+		}
+		if inserted {
+			result.Imported++
+		} else {
+			result.Duplicates++
+		}
+	}
+
+	if err = imp.tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	for _, media := range imp.media {
+		if err = cacheImportedMedia(uDevice.Id, media.messageId, media.file); err != nil {
+			log.Printf("Import media %s Error: %s", media.file.Name, err.Error())
+			continue
+		}
+		result.Media++
+	}
+
+	return result, nil
+}
+
+// loadSenders maps the names of the contacts to their JID, group exports
+// only name the participants.
+func (imp *chatImport) loadSenders() {
+	contacts, err := imp.s.GetAllWhatsAppContacts(imp.device.Id)
+	if err != nil {
+		return
+	}
+
+	for jid, contact := range contacts {
+		if name := contactInfoName(contact); name != "" {
+			j := jid
+			imp.senders[name] = &j
+		}
+	}
+}
+
+func (imp *chatImport) senderJID(name string) *types.JID {
+	if imp.chat.Server != types.GroupServer {
+		return &imp.chat
+	}
+	if exportPhoneRegex.MatchString(name) {
+		jid := types.NewJID(strings.Map(func(r rune) rune {
+			if r >= '0' && r <= '9' {
+				return r
+			}
+			return -1
+		}, name), types.DefaultUserServer)
+		return &jid
+	}
+
+	return imp.senders[name]
+}
+
+// importLine stores a message of the export and reports whether it is new,
+// its media file is cached after the import.
+func (imp *chatImport) importLine(line *exportLine, ts time.Time) (bool, error) {
+	fromMe := line.sender == imp.me
+	fileName, caption := line.attachment()
+
+	// The same message can be sent twice in a minute, the occurrence keeps
+	// their IDs apart and the same on every import
+	key := strings.Join([]string{imp.chat.String(), ts.UTC().Format(time.RFC3339), line.sender, line.body}, "\x00")
+	imp.seen[key]++
+	sum := sha1.Sum([]byte(key + "\x00" + strconv.Itoa(imp.seen[key])))
+
+	m := entity.UserMessage{
+		ID:          importedMessageIdPrefix + strings.ToUpper(hex.EncodeToString(sum[:]))[:24],
+		DeviceId:    imp.device.Id,
+		TheirJID:    &imp.chat,
+		FromMe:      fromMe,
+		Timestamp:   ts,
+		Type:        "text",
+		ReceiptType: "read",
+		Imported:    true,
+	}
+	if !fromMe {
+		m.PushName = line.sender
+		m.Sender = imp.senderJID(line.sender)
+	} else if c := getWAClient(imp.device.Id); c != nil && c.Store.ID != nil {
+		sender := c.Store.ID.ToNonAD()
+		m.Sender = &sender
+	}
+
+	// Media left out of the export matches a stored media message
+	text, media := strings.TrimSpace(caption), fileName != ""
+	if exportOmittedRegex.MatchString(line.body) {
+		text, media = "", true
+	}
+
+	// A message repeated in the export is stored once for each time it is
+	// not already: the first one matches the stored messages only when there
+	// is one, the second when there are two, and so on
+	similar := strings.Join([]string{strconv.FormatBool(fromMe), ts.UTC().Format(time.RFC3339), line.precision().String(), text, strconv.FormatBool(media)}, "\x00")
+	imp.similar[similar]++
+	count, err := imp.tx.SimilarWAMessageCount(imp.device.Id, imp.chat, fromMe, ts, ts.Add(line.precision()), text, media)
+	if err != nil || count >= imp.similar[similar] {
+		return false, err
+	}
+
+	var file *zip.File
+	if fileName != "" {
+		file = imp.files[fileName]
+		m.Type = "media"
+		m.Message = &entity.WAMessage{Message: importedMediaMessage(fileName, caption, file)}
+	} else {
+		m.Message = &entity.WAMessage{Message: &waE2E.Message{Conversation: proto.String(line.body)}}
+	}
+
+	inserted, err := imp.tx.InsertWAMessage(m)
+	if err == nil && inserted && file != nil {
+		imp.media = append(imp.media, importedMedia{messageId: m.ID, file: file})
+	}
+
+	return inserted, err
+}
+
+// importedMediaMessage rebuilds the message of a file attached to an export,
+// its kind follows the type of the file.
+func importedMediaMessage(fileName string, caption string, file *zip.File) *waE2E.Message {
+	mimeType := mime.TypeByExtension(strings.ToLower(filepath.Ext(fileName)))
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+
+	var size *uint64
+	if file != nil {
+		size = proto.Uint64(file.UncompressedSize64)
+	}
+	var captionPtr *string
+	if caption != "" {
+		captionPtr = proto.String(caption)
+	}
+
+	upper := strings.ToUpper(fileName)
+	switch {
+	case strings.HasPrefix(upper, "STK-") || strings.Contains(upper, "-STICKER-"):
+		return &waE2E.Message{StickerMessage: &waE2E.StickerMessage{Mimetype: proto.String(mimeType), FileLength: size}}
+	case strings.HasPrefix(mimeType, "image/"):
+		return &waE2E.Message{ImageMessage: &waE2E.ImageMessage{Mimetype: proto.String(mimeType), Caption: captionPtr, FileLength: size}}
+	case strings.HasPrefix(mimeType, "video/"):
+		return &waE2E.Message{VideoMessage: &waE2E.VideoMessage{Mimetype: proto.String(mimeType), Caption: captionPtr, FileLength: size}}
+	case strings.HasPrefix(mimeType, "audio/"), strings.HasPrefix(upper, "PTT-"):
+		return &waE2E.Message{AudioMessage: &waE2E.AudioMessage{Mimetype: proto.String(mimeType), FileLength: size, PTT: proto.Bool(strings.HasPrefix(upper, "PTT-"))}}
+	}
+
+	return &waE2E.Message{DocumentMessage: &waE2E.DocumentMessage{
+		Mimetype:   proto.String(mimeType),
+		FileName:   proto.String(fileName),
+		Caption:    captionPtr,
+		FileLength: size,
+	}}
+}
+
+func cacheImportedMedia(deviceId string, messageId types.MessageID, file *zip.File) error {
+	rc, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	dst := cachedMediaPath(deviceId, messageId)
+	if err = os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	// The size in the archive may lie, the file is read up to the limit
+	maxSize := ChatImportMaxSize()
+	n, err := io.Copy(f, io.LimitReader(rc, maxSize+1))
+	if err == nil && n > maxSize {
+		err = errors.New("media file is larger than " + strconv.FormatInt(maxSize>>20, 10) + " MB")
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(dst)
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,203 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseChatExport(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		senders []string
+		bodies  []string
+	}{
+		{
+			"android",
+			"31/12/2023, 21:41 - Ana: Hello\n31/12/2023, 21:42 - Budi: Hi there",
+			[]string{"Ana", "Budi"},
+			[]string{"Hello", "Hi there"},
+		},
+		{
+			"android 12 hour",
+			"12/31/23, 9:41 PM - Ana: Hello",
+			[]string{"Ana"},
+			[]string{"Hello"},
+		},
+		{
+			"ios",
+			"\u200e[31.12.23, 21:41:05] Ana: Hello\r\n[31.12.23, 21:41:09] Budi: \u200eHi",
+			[]string{"Ana", "Budi"},
+			[]string{"Hello", "Hi"},
+		},
+		{
+			"continued lines",
+			"31/12/2023, 21:41 - Ana: first\nsecond\n\nthird",
+			[]string{"Ana"},
+			[]string{"first\nsecond\n\nthird"},
+		},
+		{
+			"system lines",
+			"31/12/2023, 21:40 - Messages are end-to-end encrypted.\nnot a message\n31/12/2023, 21:41 - Ana: Hello",
+			[]string{"Ana"},
+			[]string{"Hello"},
+		},
+		{
+			"line after a system line",
+			"31/12/2023, 21:41 - Ana: Hello\n31/12/2023, 21:42 - Ana left\nstray",
+			[]string{"Ana"},
+			[]string{"Hello"},
+		},
+		{
+			"colon in body",
+			"31/12/2023, 21:41 - Ana: time: 10:00",
+			[]string{"Ana"},
+			[]string{"time: 10:00"},
+		},
+		{
+			"phone sender",
+			"31/12/2023, 21:41 - \u202a+62 811-0000-001\u202c: Hello",
+			[]string{"+62 811-0000-001"},
+			[]string{"Hello"},
+		},
+		{"not an export", "hello\nworld", nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines, err := parseChatExport(strings.NewReader(tt.text))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(lines) != len(tt.senders) {
+				t.Fatalf("got %d messages, want %d", len(lines), len(tt.senders))
+			}
+			for i, line := range lines {
+				if line.sender != tt.senders[i] || line.body != tt.bodies[i] {
+					t.Errorf("message %d = %q: %q, want %q: %q", i, line.sender, line.body, tt.senders[i], tt.bodies[i])
+				}
+			}
+		})
+	}
+}
+
+func TestChatExportTime(t *testing.T) {
+	loc := time.FixedZone("WIB", 7*60*60)
+
+	tests := []struct {
+		name      string
+		text      string
+		want      []time.Time
+		precision time.Duration
+		wantErr   bool
+	}{
+		{
+			"day first",
+			"31/12/2023, 21:41 - Ana: a",
+			[]time.Time{time.Date(2023, 12, 31, 21, 41, 0, 0, loc)},
+			time.Minute,
+			false,
+		},
+		{
+			"day first from a later line",
+			"01/02/2024, 08:00 - Ana: a\n13/02/2024, 08:00 - Ana: b",
+			[]time.Time{time.Date(2024, 2, 1, 8, 0, 0, 0, loc), time.Date(2024, 2, 13, 8, 0, 0, 0, loc)},
+			time.Minute,
+			false,
+		},
+		{
+			"month first",
+			"02/13/2024, 08:00 - Ana: a",
+			[]time.Time{time.Date(2024, 2, 13, 8, 0, 0, 0, loc)},
+			time.Minute,
+			false,
+		},
+		{
+			"month first with 12 hour times",
+			"1/2/24, 9:41 PM - Ana: a",
+			[]time.Time{time.Date(2024, 1, 2, 21, 41, 0, 0, loc)},
+			time.Minute,
+			false,
+		},
+		{
+			"midnight",
+			"1/2/24, 12:05 a.m. - Ana: a",
+			[]time.Time{time.Date(2024, 1, 2, 0, 5, 0, 0, loc)},
+			time.Minute,
+			false,
+		},
+		{
+			"noon",
+			"1/2/24, 12:05 PM - Ana: a",
+			[]time.Time{time.Date(2024, 1, 2, 12, 5, 0, 0, loc)},
+			time.Minute,
+			false,
+		},
+		{
+			"year first",
+			"2024-01-02, 09:41 - Ana: a",
+			[]time.Time{time.Date(2024, 1, 2, 9, 41, 0, 0, loc)},
+			time.Minute,
+			false,
+		},
+		{
+			"seconds",
+			"[31.12.23, 21:41:05] Ana: a",
+			[]time.Time{time.Date(2023, 12, 31, 21, 41, 5, 0, loc)},
+			time.Second,
+			false,
+		},
+		{"invalid month", "2024-13-02, 09:41 - Ana: a", nil, 0, true},
+		{"invalid hour", "31/12/2023, 25:41 - Ana: a", nil, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines, err := parseChatExport(strings.NewReader(tt.text))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			order := exportDateOrderOf(lines)
+			for i, line := range lines {
+				got, err := line.time(order, loc)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("time() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if err != nil {
+					return
+				}
+				if !got.Equal(tt.want[i]) {
+					t.Errorf("time() = %s, want %s", got, tt.want[i])
+				}
+				if line.precision() != tt.precision {
+					t.Errorf("precision() = %s, want %s", line.precision(), tt.precision)
+				}
+			}
+		})
+	}
+}
+
+func TestChatExportAttachment(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		file    string
+		caption string
+	}{
+		{"text", "Hello", "", "Hello"},
+		{"android", "IMG-20240101-WA0001.jpg (file attached)\nnice", "IMG-20240101-WA0001.jpg", "nice"},
+		{"ios", "\u200e<attached: 00000012-PHOTO-2024-01-01-10-00-00.jpg>", "00000012-PHOTO-2024-01-01-10-00-00.jpg", ""},
+		{"omitted", "<Media omitted>", "", "<Media omitted>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, caption := (&exportLine{body: tt.body}).attachment()
+			if file != tt.file || caption != tt.caption {
+				t.Errorf("attachment() = %q, %q, want %q, %q", file, caption, tt.file, tt.caption)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+package service
+
+import (
+	"errors"
+	"log"
+
+	"go.mau.fi/whatsmeow/types"
+
+	"github.com/perigiweb/go-wa-api/internal/store"
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+const (
+	ConversationOpen     = "open"
+	ConversationPending  = "pending"
+	ConversationResolved = "resolved"
+)
+
+// Actions of the conversation event
+const (
+	ConversationActionAssigned    = "assigned"
+	ConversationActionTransferred = "transferred"
+	ConversationActionResolved    = "resolved"
+	ConversationActionReopened    = "reopened"
+	ConversationActionUpdated     = "updated"
+)
+
+func (s *Service) publishConversation(userChat *entity.UserChat, action string, previousAssignee string) {
+	err := s.EnqueueEvent(newEvent(userChat.DeviceId, EventConversation, ConversationEventData{
+		Chat:             userChat.TheirJID,
+		Action:           action,
+		Assignee:         userChat.Assignee,
+		PreviousAssignee: previousAssignee,
+		Status:           userChat.Status,
+	}))
+	if err != nil {
+		log.Printf("EnqueueEvent (%s) Error: %s", EventConversation, err.Error())
+	}
+}
+
+// AssignChat gives the conversation to the assignee, an empty assignee
+// unassigns it.
+func (s *Service) AssignChat(deviceId string, chat types.JID, assignee string) (*entity.UserChat, error) {
+	if assignee != "" {
+		if err := s.checkAssignee(deviceId, assignee); err != nil {
+			return nil, err
+		}
+	}
+
+	current, err := s.Repo.GetUserChat(deviceId, chat)
+	if err != nil {
+		return nil, err
+	}
+
+	userChat, err := s.Repo.AssignUserChat(deviceId, chat, assignee)
+	if err != nil {
+		return nil, err
+	}
+
+	s.publishConversation(userChat, ConversationActionAssigned, current.Assignee)
+
+	return userChat, nil
+}
+
+// checkAssignee makes sure a conversation is only given to an agent of the
+// user of the device.
+func (s *Service) checkAssignee(deviceId string, assignee string) error {
+	ok, err := s.Repo.IsDeviceAgent(deviceId, assignee)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New(assignee + " is not an agent of this account")
+	}
+
+	return nil
+}
+
+// TransferChat hands an assigned conversation over to another assignee.
+func (s *Service) TransferChat(deviceId string, chat types.JID, assignee string) (*entity.UserChat, error) {
+	current, err := s.Repo.GetUserChat(deviceId, chat)
+	if err != nil {
+		return nil, err
+	}
+	if current.Assignee == "" {
+		return nil, errors.New("conversation is not assigned, assign it instead")
+	}
+	if current.Assignee == assignee {
+		return nil, errors.New("conversation is already assigned to " + assignee)
+	}
+	if err := s.checkAssignee(deviceId, assignee); err != nil {
+		return nil, err
+	}
+
+	userChat, err := s.Repo.AssignUserChat(deviceId, chat, assignee)
+	if err != nil {
+		return nil, err
+	}
+
+	s.publishConversation(userChat, ConversationActionTransferred, current.Assignee)
+
+	return userChat, nil
+}
+
+// SetChatStatus moves the conversation to the status, resolving or reopening
+// it is notified through the event pipeline.
+func (s *Service) SetChatStatus(deviceId string, chat types.JID, status string) (*entity.UserChat, error) {
+	if status != ConversationOpen && status != ConversationPending && status != ConversationResolved {
+		return nil, errors.New("invalid conversation status: " + status)
+	}
+
+	current, err := s.Repo.GetUserChat(deviceId, chat)
+	if err != nil {
+		return nil, err
+	}
+
+	userChat, err := s.Repo.SetUserChatStatus(deviceId, chat, status)
+	if err != nil {
+		return nil, err
+	}
+
+	action := ConversationActionUpdated
+	if status == ConversationResolved {
+		action = ConversationActionResolved
+	} else if current.Status == ConversationResolved {
+		action = ConversationActionReopened
+	}
+	s.publishConversation(userChat, action, "")
+
+	return userChat, nil
+}
+
+// reopenChat opens a resolved conversation again when the contact writes to
+// it.
+func (s *Service) reopenChat(deviceId string, chat types.JID) {
+	userChat, err := s.Repo.ReopenResolvedUserChat(deviceId, chat)
+	if err != nil {
+		log.Printf("ReopenResolvedUserChat %s Error: %s", chat, err.Error())
+		return
+	}
+
+	if userChat != nil {
+		s.publishConversation(userChat, ConversationActionReopened, "")
+	}
+}
+
+func (s *Service) UpdateChatDetails(deviceId string, chat types.JID, priority string, labels []string) (*entity.UserChat, error) {
+	userChat, err := s.Repo.UpdateUserChatDetails(deviceId, chat, priority, labels)
+	if err != nil {
+		return nil, err
+	}
+
+	s.publishConversation(userChat, ConversationActionUpdated, "")
+
+	return userChat, nil
+}
+
+// GetChats returns a page of the chat list of the device with the contacts
+// resolved from the WhatsApp store, when the device is connected.
+func (s *Service) GetChats(deviceId string, assignee string, status string, cur store.Cursor) ([]*store.Chat, bool, error) {
+	chats, hasMore, err := s.Repo.GetWAChats(deviceId, assignee, status, cur)
+	if err != nil {
+		return chats, hasMore, err
+	}
+
+	contacts, _ := s.GetAllWhatsAppContacts(deviceId)
+	for _, chat := range chats {
+		jid := *chat.Message.TheirJID
+		chat.From = contacts[jid]
+		chat.Name = contactInfoName(chat.From)
+		if chat.Name == "" && !chat.Message.FromMe {
+			chat.Name = chat.Message.PushName
+		}
+		if chat.Name == "" {
+			chat.Name = jid.User
+		}
+	}
+
+	return chats, hasMore, nil
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	"go.mau.fi/whatsmeow/types"
+
+	"github.com/perigiweb/go-wa-api/internal"
+)
+
+const defaultMediaCacheDir = "media"
+
+// mediaCacheDir is where the downloaded files of media messages are cached,
+// MEDIA_CACHE_DIR or ./media.
+func mediaCacheDir() string {
+	dir, err := internal.GetEnvString("MEDIA_CACHE_DIR")
+	if err != nil || dir == "" {
+		return defaultMediaCacheDir
+	}
+
+	return dir
+}
+
+// cachedMediaPath returns the path of the cached file of a media message, one
+// directory per device.
+func cachedMediaPath(deviceId string, messageId types.MessageID) string {
+	return filepath.Join(mediaCacheDir(), filepath.Base(deviceId), filepath.Base(messageId))
+}
+
+// removeCachedMedia deletes the cached files of the messages, messages
+// without cached file are skipped. It returns the IDs of the messages which
+// have no cached file anymore.
+func removeCachedMedia(deviceId string, messageIds []types.MessageID) []types.MessageID {
+	removed := make([]types.MessageID, 0, len(messageIds))
+	for _, id := range messageIds {
+		err := os.Remove(cachedMediaPath(deviceId, id))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Remove cached media %s Error: %s", id, err.Error())
+			continue
+		}
+		removed = append(removed, id)
+	}
+
+	return removed
+}
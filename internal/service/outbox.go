@@ -0,0 +1,238 @@
+package service
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+
+	"github.com/perigiweb/go-wa-api/internal"
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+const (
+	outboxBatchSize    = 20
+	outboxPollInterval = 2 * time.Second
+	outboxLockDuration = time.Minute
+	outboxMaxAttempts  = 10
+	outboxRetention    = 7 * 24 * time.Hour
+)
+
+// EnqueueEvent persists the event in the outbox. The event is processed and
+// published by the event workers, so it survives a crash of the process.
+func (s *Service) EnqueueEvent(evt *entity.Event) error {
+	payload, err := json.Marshal(evt.Data)
+	if err != nil {
+		return err
+	}
+
+	err = s.Repo.InsertOutboxEvent(&entity.OutboxEvent{
+		EventId:   evt.Id,
+		DeviceId:  evt.DeviceId,
+		EventType: evt.Type,
+		Payload:   payload,
+	})
+	if err != nil {
+		return err
+	}
+
+	select {
+	case s.outboxNotify <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+func (s *Service) StartEventWorkers() {
+	workers, err := internal.GetEnvInt("EVENT_WORKERS")
+	if err != nil || workers < 1 {
+		workers = 2
+	}
+
+	// Each worker processes the events of its share of the devices one at a
+	// time, so the events of a chat are processed in order
+	log.Printf("Starting %d event workers", workers)
+	for i := 0; i < workers; i++ {
+		s.wg.Add(1)
+		go s.outboxWorker(i, workers)
+	}
+}
+
+func (s *Service) outboxWorker(shard int, shards int) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Keep claiming until the outbox is drained before waiting again
+		for s.ctx.Err() == nil {
+			if s.processOutboxBatch(shard, shards) < outboxBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-s.outboxNotify:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Service) processOutboxBatch(shard int, shards int) int {
+	events, err := s.Repo.ClaimOutboxEvents(outboxBatchSize, outboxLockDuration, shard, shards)
+	if err != nil {
+		log.Printf("ClaimOutboxEvents Error: %s", err.Error())
+		return 0
+	}
+
+	for _, oe := range events {
+		err = s.processOutboxEvent(oe)
+		if err == nil {
+			err = s.Repo.CompleteOutboxEvent(oe.Id)
+			if err != nil {
+				log.Printf("CompleteOutboxEvent (%d) Error: %s", oe.Id, err.Error())
+			}
+			continue
+		}
+
+		log.Printf("Outbox event %d (%s) attempt %d failed: %s", oe.Id, oe.EventType, oe.Attempts, err.Error())
+
+		var retryAt *time.Time
+		if oe.Attempts < outboxMaxAttempts {
+			t := time.Now().Add(time.Duration(1<<min(oe.Attempts, 10)) * time.Second)
+			retryAt = &t
+		}
+
+		if failErr := s.Repo.FailOutboxEvent(oe.Id, err.Error(), retryAt); failErr != nil {
+			log.Printf("FailOutboxEvent (%d) Error: %s", oe.Id, failErr.Error())
+		}
+	}
+
+	return len(events)
+}
+
+// processOutboxEvent applies the event to the store and then feeds it to the
+// downstream consumers. Every step is idempotent because an event can be
+// processed more than once when a worker dies before completing it.
+func (s *Service) processOutboxEvent(oe *entity.OutboxEvent) error {
+	evt := &entity.Event{
+		Id:        oe.EventId,
+		Type:      oe.EventType,
+		DeviceId:  oe.DeviceId,
+		Timestamp: oe.CreatedAt,
+	}
+
+	switch oe.EventType {
+	case EventMessage:
+		var m entity.UserMessage
+		if err := json.Unmarshal(oe.Payload, &m); err != nil {
+			return err
+		}
+
+		if m.EditOf != "" {
+			if err := s.Repo.DeleteWAMessage(oe.DeviceId, m.EditOf); err != nil {
+				return err
+			}
+		}
+
+		if err := s.Repo.InsertWAMessage(m); err != nil {
+			return err
+		}
+
+		if err := s.attributeBroadcastReply(&m); err != nil {
+			return err
+		}
+
+		evt.Data = m
+
+	case EventReceipt:
+		var receipt ReceiptEventData
+		if err := json.Unmarshal(oe.Payload, &receipt); err != nil {
+			return err
+		}
+
+		if len(receipt.MessageIds) > 0 {
+			if err := s.Repo.UpdateWAMessageReceiptType(oe.DeviceId, receipt.MessageIds, types.ReceiptType(receipt.Type)); err != nil {
+				return err
+			}
+
+			// May its a broadcast msg,
+			if receipt.Type == string(types.ReceiptTypeRead) || receipt.Type == "delivered" {
+				if err := s.Repo.UpdateBroadcastMessageReceipt(receipt.MessageIds, receipt.Type, receipt.Timestamp); err != nil {
+					return err
+				}
+			}
+		}
+
+		// Who of the participants received our message in a group
+		if receipt.Chat.Server == types.GroupServer && len(receipt.MessageIds) > 0 {
+			if err := s.saveGroupReceipts(oe.DeviceId, receipt); err != nil {
+				return err
+			}
+		}
+
+		// Read on another device of the account
+		if receipt.Type == string(types.ReceiptTypeReadSelf) {
+			if err := s.Repo.MarkUserChatRead(oe.DeviceId, receipt.Chat, receipt.Timestamp); err != nil {
+				return err
+			}
+		}
+
+		evt.Data = receipt
+
+	default:
+		evt.Data = oe.Payload
+	}
+
+	return s.publishEvent(evt)
+}
+
+// saveGroupReceipts records the delivered, read and played receipts of a
+// group participant, a read message has been delivered too.
+func (s *Service) saveGroupReceipts(deviceId string, receipt ReceiptEventData) error {
+	ts := receipt.Timestamp
+	r := &entity.MessageReceipt{
+		DeviceId:       deviceId,
+		TheirJID:       receipt.Chat,
+		Participant:    receipt.Sender.ToNonAD(),
+		ParticipantAlt: receipt.SenderAlt,
+	}
+
+	// The same participant may come with its LID or its phone number, the
+	// receipts are kept by the phone number when it is known.
+	if r.Participant.Server == types.HiddenUserServer && r.ParticipantAlt != nil && r.ParticipantAlt.Server == types.DefaultUserServer {
+		lid := r.Participant
+		r.Participant = *r.ParticipantAlt
+		r.ParticipantAlt = &lid
+	}
+
+	switch receipt.Type {
+	case string(types.ReceiptTypePlayed):
+		r.PlayedAt = &ts
+		fallthrough
+	case string(types.ReceiptTypeRead):
+		r.ReadAt = &ts
+		fallthrough
+	case "delivered":
+		r.DeliveredAt = &ts
+	default:
+		return nil
+	}
+
+	return s.Repo.SaveMessageReceipts(r, receipt.MessageIds)
+}
+
+func (s *Service) cleanupOutbox() {
+	deleted, err := s.Repo.DeleteProcessedOutboxEvents(time.Now().Add(-outboxRetention))
+	if err != nil {
+		log.Printf("DeleteProcessedOutboxEvents Error: %s", err.Error())
+		return
+	}
+
+	log.Printf("Outbox cleanup: %d events deleted", deleted)
+}
@@ -0,0 +1,136 @@
+package service
+
+import (
+	"log"
+
+	"github.com/perigiweb/go-wa-api/internal/store"
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+// Rows deleted per query, purges run in batches to keep transactions and
+// locks short
+const retentionBatchSize = 500
+
+// PurgeExpiredData deletes the messages, cached media files and broadcast
+// recipients kept longer than the retention policy of their device, and
+// records what has been purged. The receipts, webhook deliveries and events of
+// the messages are deleted with them.
+func (s *Service) PurgeExpiredData() {
+	policies, err := s.Repo.GetRetentionPolicies()
+	if err != nil {
+		log.Printf("GetRetentionPolicies Error: %s", err.Error())
+		return
+	}
+
+	for _, policy := range policies {
+		if s.ctx.Err() != nil {
+			return
+		}
+
+		purge, err := s.purgeDevice(policy)
+		if err != nil {
+			log.Printf("Purge device %s Error: %s", policy.DeviceId, err.Error())
+		}
+		if purge.Messages == 0 && purge.Media == 0 && purge.BroadcastRecipients == 0 {
+			continue
+		}
+
+		if err = s.Repo.InsertRetentionPurge(purge); err != nil {
+			log.Printf("InsertRetentionPurge Error: %s", err.Error())
+		}
+		log.Printf("Purged device %s: %d messages, %d media, %d broadcast recipients",
+			policy.DeviceId, purge.Messages, purge.Media, purge.BroadcastRecipients)
+	}
+}
+
+// purgeDevice purges the expired data of a device, the counts of what has
+// been deleted are returned even when it fails halfway.
+func (s *Service) purgeDevice(policy *entity.RetentionPolicy) (*entity.RetentionPurge, error) {
+	purge := &entity.RetentionPurge{
+		UserId:   policy.UserId,
+		DeviceId: policy.DeviceId,
+	}
+
+	if before := store.RetentionCutoff(policy.MessageDays); !before.IsZero() {
+		for s.ctx.Err() == nil {
+			ids, media, err := s.Repo.PurgeExpiredMessages(policy.DeviceId, before, retentionBatchSize)
+			purge.Messages += len(ids)
+			purge.Media += media
+			removeCachedMedia(policy.DeviceId, ids)
+			if err != nil {
+				return purge, err
+			}
+			if len(ids) < retentionBatchSize {
+				break
+			}
+		}
+
+		events := 0
+		for s.ctx.Err() == nil {
+			deleted, err := s.Repo.PurgeExpiredEvents(policy.DeviceId, before, retentionBatchSize)
+			events += deleted
+			if err != nil {
+				return purge, err
+			}
+			if deleted < retentionBatchSize {
+				break
+			}
+		}
+		if events > 0 {
+			log.Printf("Purged device %s: %d webhook deliveries and events", policy.DeviceId, events)
+		}
+	}
+
+	// The media is flagged as purged once its file is deleted, a file which
+	// could not be deleted is tried again by the next purge
+	if before := store.RetentionCutoff(policy.MediaDays); !before.IsZero() {
+		for s.ctx.Err() == nil {
+			ids, err := s.Repo.GetExpiredMedia(policy.DeviceId, before, retentionBatchSize)
+			if err != nil {
+				return purge, err
+			}
+
+			removed := removeCachedMedia(policy.DeviceId, ids)
+			if len(removed) > 0 {
+				if err = s.Repo.MarkMediaPurged(policy.DeviceId, removed); err != nil {
+					return purge, err
+				}
+			}
+			purge.Media += len(removed)
+			if len(ids) < retentionBatchSize || len(removed) < len(ids) {
+				break
+			}
+		}
+	}
+
+	if before := store.RetentionCutoff(policy.BroadcastDays); !before.IsZero() && policy.DeviceJid != nil {
+		for s.ctx.Err() == nil {
+			deleted, err := s.Repo.PurgeExpiredBroadcastRecipients(*policy.DeviceJid, before, retentionBatchSize)
+			purge.BroadcastRecipients += deleted
+			if err != nil {
+				return purge, err
+			}
+			if deleted < retentionBatchSize {
+				break
+			}
+		}
+	}
+
+	return purge, nil
+}
+
+// PreviewRetention returns the retention policy of the device and how much of
+// its data the next purge would delete.
+func (s *Service) PreviewRetention(deviceId string) (*entity.RetentionPolicy, *entity.RetentionPurge, error) {
+	policy, err := s.Repo.GetRetentionPolicy(deviceId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	purge, err := s.Repo.CountExpiredData(policy)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return policy, purge, nil
+}
@@ -0,0 +1,382 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+
+	"github.com/perigiweb/go-wa-api/internal"
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+// How long a sender without a due broadcast waits before it looks again
+const broadcastSenderIdle = 30 * time.Second
+
+const (
+	// How many times a message is tried before its recipient fails
+	defaultBroadcastMaxAttempts = 3
+	// How long before the first retry, it doubles with each attempt
+	defaultBroadcastRetryBackoff = time.Minute
+	maxBroadcastRetryBackoff     = time.Hour
+)
+
+// broadcastSender sends the broadcasts of a device, one message at a time.
+type broadcastSender struct {
+	deviceId string
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+var (
+	broadcastSenders   = make(map[string]*broadcastSender)
+	broadcastSendersMu sync.Mutex
+)
+
+// StartBroadcastSender starts the sender of the device unless it is running,
+// a sender being stopped finishes its message before the new one starts.
+func (s *Service) StartBroadcastSender(deviceId string) {
+	broadcastSendersMu.Lock()
+	defer broadcastSendersMu.Unlock()
+
+	prev := broadcastSenders[deviceId]
+	if prev != nil && prev.ctx.Err() == nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	sender := &broadcastSender{
+		deviceId: deviceId,
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	broadcastSenders[deviceId] = sender
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer close(sender.done)
+		defer func() {
+			broadcastSendersMu.Lock()
+			if broadcastSenders[deviceId] == sender {
+				delete(broadcastSenders, deviceId)
+			}
+			broadcastSendersMu.Unlock()
+			cancel()
+		}()
+
+		if prev != nil {
+			<-prev.done
+		}
+		s.runBroadcastSender(sender)
+	}()
+}
+
+// StopBroadcastSender stops the sender of the device, the message it is
+// sending is sent first.
+func (s *Service) StopBroadcastSender(deviceId string) {
+	broadcastSendersMu.Lock()
+	defer broadcastSendersMu.Unlock()
+
+	if sender := broadcastSenders[deviceId]; sender != nil {
+		sender.cancel()
+	}
+}
+
+func (s *Service) runBroadcastSender(sender *broadcastSender) {
+	log.Printf("Broadcast sender %s started", sender.deviceId)
+	defer log.Printf("Broadcast sender %s stopped", sender.deviceId)
+
+	for {
+		settings, err := s.Repo.GetSenderSettings(sender.deviceId)
+		if err != nil {
+			log.Printf("GetSenderSettings %s Error: %s", sender.deviceId, err.Error())
+			if !sleepContext(sender.ctx, broadcastSenderIdle) {
+				return
+			}
+			continue
+		}
+
+		c := getWAClient(sender.deviceId)
+		if c == nil || !c.IsConnected() || c.Store.ID == nil {
+			return
+		}
+		jid := c.Store.ID.ToNonAD()
+
+		// The rates count what the device sent before a reconnect or restart too
+		sent, err := s.Repo.GetRecentBroadcastSends(jid, time.Now().Add(-time.Hour))
+		if err != nil {
+			log.Printf("GetRecentBroadcastSends %s Error: %s", sender.deviceId, err.Error())
+			if !sleepContext(sender.ctx, broadcastSenderIdle) {
+				return
+			}
+			continue
+		}
+		if !sleepContext(sender.ctx, nextSendDelay(settings, sent, time.Now())) {
+			return
+		}
+
+		c = getWAClient(sender.deviceId)
+		if c == nil || !c.IsConnected() || c.Store.ID == nil {
+			return
+		}
+
+		ok, err := s.sendNextBroadcastMessage(sender.ctx, c.Store.ID.ToNonAD(), settings)
+		if err != nil {
+			log.Printf("Broadcast sender %s Error: %s", sender.deviceId, err.Error())
+		}
+		if !ok && !sleepContext(sender.ctx, broadcastSenderIdle) {
+			return
+		}
+	}
+}
+
+// nextSendDelay returns how long to wait before the next message: until it
+// fits in the rates per minute and per hour, plus the jitter. sent are the
+// times of the messages sent in the last hour, oldest first.
+func nextSendDelay(settings *entity.SenderSettings, sent []time.Time, now time.Time) time.Duration {
+	var delay time.Duration
+
+	if settings.PerHour > 0 && len(sent) >= settings.PerHour {
+		delay = max(delay, sent[len(sent)-settings.PerHour].Add(time.Hour).Sub(now))
+	}
+	if settings.PerMinute > 0 && len(sent) >= settings.PerMinute {
+		delay = max(delay, sent[len(sent)-settings.PerMinute].Add(time.Minute).Sub(now))
+	}
+
+	return delay + randomSeconds(settings.JitterMin, settings.JitterMax)
+}
+
+// randomSeconds returns a random duration of from to to seconds.
+func randomSeconds(from int, to int) time.Duration {
+	if to <= from {
+		return time.Duration(from) * time.Second
+	}
+
+	return time.Duration(from)*time.Second + time.Duration(rand.Int63n(int64(to-from)*int64(time.Second)))
+}
+
+// broadcastMaxAttempts is BROADCAST_MAX_ATTEMPTS or 3.
+func broadcastMaxAttempts() int {
+	attempts, err := internal.GetEnvInt("BROADCAST_MAX_ATTEMPTS")
+	if err != nil || attempts <= 0 {
+		return defaultBroadcastMaxAttempts
+	}
+
+	return attempts
+}
+
+// broadcastRetryDelay returns how long to wait before trying a message again
+// after the given number of attempts, BROADCAST_RETRY_BACKOFF_SECONDS or a
+// minute doubled with each attempt, at most an hour.
+func broadcastRetryDelay(attempts int) time.Duration {
+	backoff := defaultBroadcastRetryBackoff
+	if seconds, err := internal.GetEnvInt("BROADCAST_RETRY_BACKOFF_SECONDS"); err == nil && seconds > 0 {
+		backoff = time.Duration(seconds) * time.Second
+	}
+
+	for i := 1; i < attempts && backoff < maxBroadcastRetryBackoff; i++ {
+		backoff *= 2
+	}
+
+	return min(backoff, maxBroadcastRetryBackoff)
+}
+
+// isTransientSendError tells whether a message may be sent when tried again:
+// the device was not connected or WhatsApp did not answer in time.
+func isTransientSendError(err error) bool {
+	var (
+		disconnected *whatsmeow.DisconnectedError
+		netErr       net.Error
+	)
+
+	switch {
+	case errors.Is(err, whatsmeow.ErrNotConnected),
+		errors.Is(err, whatsmeow.ErrIQTimedOut),
+		errors.Is(err, whatsmeow.ErrMessageTimedOut),
+		errors.Is(err, errWAClientNotFound),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &disconnected):
+		return true
+	case errors.As(err, &netErr):
+		return netErr.Timeout()
+	}
+
+	return false
+}
+
+// sleepContext waits for d, it reports false when ctx is done first.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// sendNextBroadcastMessage sends the message of a due broadcast of the device
+// to its next recipient, after showing the device typing. It reports whether
+// a message has been tried, the rates only count the sent ones. A sender
+// stopped while typing sends nothing.
+func (s *Service) sendNextBroadcastMessage(ctx context.Context, jid types.JID, settings *entity.SenderSettings) (bool, error) {
+	broadcastToSend, err := s.Repo.GetBroadcastToSend(jid)
+	if err != nil || broadcastToSend == nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			err = nil
+		}
+		return false, err
+	}
+
+	broadcast := &broadcastToSend.Broadcast
+	if broadcast.Device == nil {
+		return false, s.SetBroadcastStatus(broadcast, BroadcastFailed, 0)
+	}
+	if broadcast.Status == BroadcastScheduled {
+		if err = s.SetBroadcastStatus(broadcast, BroadcastRunning, 0); err != nil {
+			return false, err
+		}
+	} else if broadcast.SnapshotAt == nil {
+		// Running since before the recipients were listed at launch
+		if _, err = s.Repo.SnapshotBroadcastRecipients(broadcast); err != nil {
+			return false, err
+		}
+	}
+	if broadcastToSend.Recipient == nil && broadcastToSend.TotalRecipient == 0 {
+		// The recipients may have just been listed
+		if err = s.Repo.GetNextBroadcastRecipient(broadcastToSend); err != nil {
+			return false, err
+		}
+	}
+
+	recipient := broadcastToSend.Recipient
+	if recipient == nil {
+		// The queued recipients wait to be tried again
+		if broadcastToSend.TotalRecipient == 0 {
+			s.completeBroadcast(broadcast)
+		}
+		return false, nil
+	}
+	log.Printf("Broadcast: %s, Recipient: %s (%s); Total Recipient: %d", broadcast.CampaignName, recipient.Name, recipient.Phone, broadcastToSend.TotalRecipient)
+
+	err = s.SendChatPresence(broadcast.Device.Id, recipient.Phone, types.ChatPresenceComposing, "")
+	if err != nil {
+		return false, err
+	}
+	if !sleepContext(ctx, randomSeconds(settings.TypingMin, settings.TypingMax)) {
+		_ = s.SendChatPresence(broadcast.Device.Id, recipient.Phone, types.ChatPresencePaused, "")
+		return false, nil
+	}
+
+	// The broadcast may have been paused or cancelled while typing
+	status, err := s.Repo.GetBroadcastStatus(broadcast.Id)
+	if err != nil || status != BroadcastRunning {
+		_ = s.SendChatPresence(broadcast.Device.Id, recipient.Phone, types.ChatPresencePaused, "")
+		return false, err
+	}
+
+	// The message is rendered once, a retry sends the same text
+	if recipient.Message == nil {
+		message, renderErr := renderBroadcastMessage(broadcast, recipient, time.Now())
+		if renderErr != nil {
+			log.Printf("Error renderBroadcastMessage: %s", renderErr.Error())
+			if err = s.Repo.UpdateSentFailed(recipient.Id, renderErr.Error()); err != nil {
+				log.Printf("Error UpdateSentFailed: %v", err)
+			}
+			s.enqueueBroadcastEvent(broadcast.Device, EventBroadcastSent, BroadcastEventData{
+				BroadcastId:  broadcast.Id,
+				CampaignName: broadcast.CampaignName,
+				Phone:        recipient.Phone,
+				Status:       "failed",
+				Error:        renderErr.Error(),
+			})
+			if broadcastToSend.TotalRecipient == 1 {
+				s.completeBroadcast(broadcast)
+			}
+			return true, nil
+		}
+
+		recipient.Message = &message
+		if err = s.Repo.UpdateRecipientMessage(recipient.Id, message); err != nil {
+			return false, err
+		}
+	}
+
+	sendResponse, err := s.SendBroadcastMessage(broadcastToSend)
+	eventData := BroadcastEventData{
+		BroadcastId:  broadcast.Id,
+		CampaignName: broadcast.CampaignName,
+		Phone:        recipient.Phone,
+	}
+	if err == nil {
+		// The chat is kept for the replies, the message may be purged first
+		chat, _ := parseJID(recipient.Phone)
+		if err := s.Repo.UpdateSentStatus(recipient.Id, "sent", sendResponse.ID, sendResponse.Timestamp, chat.ToNonAD()); err != nil {
+			log.Printf("Error UpdateSentStatus: %v", err)
+		}
+		eventData.Status = "sent"
+		eventData.MessageId = sendResponse.ID
+	} else if attempts := recipient.Attempts + 1; attempts < broadcastMaxAttempts() && isTransientSendError(err) {
+		retryAt := time.Now().Add(broadcastRetryDelay(attempts))
+		log.Printf("Error SendBroadcastMessage: %s, retry at %s", err.Error(), retryAt.Format(time.RFC3339))
+		if err := s.Repo.UpdateSentRetry(recipient.Id, err.Error(), retryAt); err != nil {
+			log.Printf("Error UpdateSentRetry: %v", err)
+		}
+		return true, nil
+	} else {
+		log.Printf("Error SendBroadcastMessage: %+v", err.Error())
+		if err := s.Repo.UpdateSentFailed(recipient.Id, err.Error()); err != nil {
+			log.Printf("Error UpdateSentFailed: %v", err)
+		}
+		eventData.Status = "failed"
+		eventData.Error = err.Error()
+	}
+	s.enqueueBroadcastEvent(broadcast.Device, EventBroadcastSent, eventData)
+
+	if broadcastToSend.TotalRecipient == 1 {
+		s.completeBroadcast(broadcast)
+	}
+
+	return true, nil
+}
+
+func (s *Service) completeBroadcast(broadcast *entity.Broadcast) {
+	err := s.SetBroadcastStatus(broadcast, BroadcastCompleted, 0)
+	if err != nil {
+		log.Printf("Complete broadcast %d Error: %s", broadcast.Id, err.Error())
+		return
+	}
+
+	s.enqueueBroadcastEvent(broadcast.Device, EventBroadcastCompleted, BroadcastEventData{
+		BroadcastId:  broadcast.Id,
+		CampaignName: broadcast.CampaignName,
+		Status:       "completed",
+	})
+}
+
+func (s *Service) enqueueBroadcastEvent(device *entity.Device, eventType string, data BroadcastEventData) {
+	if device == nil || device.Id == "" {
+		return
+	}
+
+	err := s.EnqueueEvent(newEvent(device.Id, eventType, data))
+	if err != nil {
+		log.Printf("EnqueueEvent (%s) Error: %s", eventType, err.Error())
+	}
+}
@@ -1,7 +1,9 @@
 package service
 
 import (
+	"context"
 	"log"
+	"sync"
 	"time"
 
 	"go.mau.fi/whatsmeow/store/sqlstore"
@@ -15,12 +17,25 @@ import (
 type Service struct {
 	Repo        *store.Repo
 	waDataStore *sqlstore.Container
+
+	ctx          context.Context
+	cancel       context.CancelFunc
+	wg           sync.WaitGroup
+	outboxNotify chan struct{}
+	historySyncs chan historySyncJob
+	publisher    EventPublisher
 }
 
 func NewService(repo *store.Repo, waDataStore *sqlstore.Container) *Service {
+	ctx, cancel := context.WithCancel(context.Background())
+
 	return &Service{
-		Repo:        repo,
-		waDataStore: waDataStore,
+		Repo:         repo,
+		waDataStore:  waDataStore,
+		ctx:          ctx,
+		cancel:       cancel,
+		outboxNotify: make(chan struct{}, 1),
+		historySyncs: make(chan historySyncJob, historySyncQueueSize),
 	}
 }
 
@@ -33,6 +48,13 @@ func (s *Service) StartUp() {
 	}
 }
 
+// Shutdown stops the background workers and waits for them to finish.
+func (s *Service) Shutdown() {
+	s.cancel()
+	s.wg.Wait()
+	s.closeBroker()
+}
+
 func (s *Service) SignIn(
 	usrEmail string,
 	usrPassword string,
@@ -0,0 +1,270 @@
+package service
+
+import (
+	"hash/fnv"
+	"math/big"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// How many variants a broadcast preview lists by default and at most
+const (
+	defaultPreviewVariants = 10
+	maxPreviewVariants     = 50
+)
+
+// spintax is a text with groups of options, one of them is picked each time
+// it is spun:
+//
+//	{Hi|Hello|Halo} {{name}}, {thanks|{many|a lot of} thanks}!
+//
+// A group needs two options at least, braces without any are kept as they
+// are, as are the {{variables}} of a template and the \{{ written for a {{.
+// Three braces open a group starting with a variable, {{{name}}|friend}.
+type spintax []spinPart
+
+// spinPart is a text or, when it has options, a group.
+type spinPart struct {
+	text    string
+	options []spintax
+}
+
+func parseSpintax(text string) spintax {
+	return parseSpinText(text, 0, len(text), matchSpinBraces(text))
+}
+
+// matchSpinBraces finds in one pass where each { of the text is closed: at its
+// }, at the last } of a {{variable}}, or -1 when it is not. A {{ never closed
+// keeps the rest of the text as it is.
+func matchSpinBraces(text string) []int {
+	match := make([]int, len(text))
+	open := make([]int, 0)
+	for i := 0; i < len(text); i++ {
+		switch {
+		case strings.HasPrefix(text[i:], templateEscapedOpen):
+			i += len(templateEscapedOpen) - 1
+		case strings.HasPrefix(text[i:], "{{{"):
+			// A group, its variable is read next
+			match[i] = -1
+			open = append(open, i)
+		case strings.HasPrefix(text[i:], "{{"):
+			end := strings.Index(text[i:], "}}")
+			if end < 0 {
+				match[i] = len(text) - 1
+				i = len(text)
+				continue
+			}
+			match[i] = i + end + 1
+			i += end + 1
+		case text[i] == '{':
+			match[i] = -1
+			open = append(open, i)
+		case text[i] == '}' && len(open) > 0:
+			match[open[len(open)-1]] = i
+			open = open[:len(open)-1]
+		}
+	}
+
+	return match
+}
+
+// parseSpinText parses the text from i up to end.
+func parseSpinText(text string, i int, end int, match []int) spintax {
+	var (
+		seq spintax
+		b   strings.Builder
+	)
+	flush := func() {
+		if b.Len() > 0 {
+			seq = append(seq, spinPart{text: b.String()})
+			b.Reset()
+		}
+	}
+
+	for i < end {
+		switch {
+		case strings.HasPrefix(text[i:end], templateEscapedOpen):
+			b.WriteString(templateEscapedOpen)
+			i += len(templateEscapedOpen)
+		case strings.HasPrefix(text[i:], "{{") && !strings.HasPrefix(text[i:], "{{{"):
+			b.WriteString(text[i : match[i]+1])
+			i = match[i] + 1
+		case text[i] == '{' && match[i] >= 0:
+			if options := parseSpinGroup(text, i+1, match[i], match); options != nil {
+				flush()
+				seq = append(seq, spinPart{options: options})
+			} else {
+				b.WriteString(text[i : match[i]+1])
+			}
+			i = match[i] + 1
+		default:
+			b.WriteByte(text[i])
+			i++
+		}
+	}
+	flush()
+
+	return seq
+}
+
+// parseSpinGroup parses the options of a group from after its { up to its }.
+// It returns no options when the group has a single one.
+func parseSpinGroup(text string, i int, end int, match []int) []spintax {
+	options := make([]spintax, 0)
+	start := i
+	for i < end {
+		switch {
+		case strings.HasPrefix(text[i:end], templateEscapedOpen):
+			i += len(templateEscapedOpen)
+		case text[i] == '{' && match[i] >= 0:
+			i = match[i] + 1
+		case text[i] == '|':
+			options = append(options, parseSpinText(text, start, i, match))
+			i++
+			start = i
+		default:
+			i++
+		}
+	}
+	if len(options) == 0 {
+		return nil
+	}
+
+	return append(options, parseSpinText(text, start, end, match))
+}
+
+// Spin returns a variant of the text, picking the options with rng.
+func (seq spintax) Spin(rng *rand.Rand) string {
+	var b strings.Builder
+	seq.spin(&b, rng)
+
+	return b.String()
+}
+
+func (seq spintax) spin(b *strings.Builder, rng *rand.Rand) {
+	for _, part := range seq {
+		if part.options == nil {
+			b.WriteString(part.text)
+			continue
+		}
+		part.options[rng.Intn(len(part.options))].spin(b, rng)
+	}
+}
+
+// Combinations counts the variants of the text, the same variant may come
+// from different options.
+func (seq spintax) Combinations() *big.Int {
+	total := big.NewInt(1)
+	for _, part := range seq {
+		if part.options == nil {
+			continue
+		}
+		options := new(big.Int)
+		for _, option := range part.options {
+			options.Add(options, option.Combinations())
+		}
+		total.Mul(total, options)
+	}
+
+	return total
+}
+
+// variants lists every variant of the text, it is only meant for a text with
+// few combinations.
+func (seq spintax) variants() []string {
+	variants := []string{""}
+	for _, part := range seq {
+		choices := []string{part.text}
+		if part.options != nil {
+			choices = make([]string, 0)
+			for _, option := range part.options {
+				choices = append(choices, option.variants()...)
+			}
+		}
+
+		next := make([]string, 0, len(variants)*len(choices))
+		for _, v := range variants {
+			for _, choice := range choices {
+				next = append(next, v+choice)
+			}
+		}
+		variants = next
+	}
+
+	return variants
+}
+
+// texts lists the texts of the spintax and of its options, a {{variable}} is
+// never split between them.
+func (seq spintax) texts() []string {
+	texts := make([]string, 0)
+	for _, part := range seq {
+		if part.options == nil {
+			texts = append(texts, part.text)
+			continue
+		}
+		for _, option := range part.options {
+			texts = append(texts, option.texts()...)
+		}
+	}
+
+	return texts
+}
+
+// spinSeed seeds the variant a recipient of a broadcast gets, it is the same
+// every time the message is tried.
+func spinSeed(broadcastId int64, phone string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(strconv.FormatInt(broadcastId, 10) + ":" + phone))
+
+	return int64(h.Sum64())
+}
+
+// BroadcastPreview lists variants of a broadcast message and how many there
+// are.
+type BroadcastPreview struct {
+	Combinations *big.Int `json:"combinations"`
+	Variants     []string `json:"variants"`
+}
+
+// PreviewBroadcastMessage returns up to count different variants of the
+// message, its variables are left as they are.
+func (s *Service) PreviewBroadcastMessage(message string, count int) (*BroadcastPreview, error) {
+	if _, err := ParseBroadcastTemplate(message); err != nil {
+		return nil, err
+	}
+	if count <= 0 {
+		count = defaultPreviewVariants
+	}
+	count = min(count, maxPreviewVariants)
+
+	seq := parseSpintax(message)
+	preview := &BroadcastPreview{
+		Combinations: seq.Combinations(),
+		Variants:     make([]string, 0, count),
+	}
+
+	seen := make(map[string]bool)
+	add := func(variant string) {
+		if !seen[variant] && len(preview.Variants) < count {
+			seen[variant] = true
+			preview.Variants = append(preview.Variants, variant)
+		}
+	}
+
+	if preview.Combinations.Cmp(big.NewInt(maxPreviewVariants)) <= 0 {
+		for _, variant := range seq.variants() {
+			add(variant)
+		}
+		return preview, nil
+	}
+
+	// Random variants, a few tries each as some may come up twice
+	rng := rand.New(rand.NewSource(rand.Int63()))
+	for i := 0; i < count*10 && len(preview.Variants) < count; i++ {
+		add(seq.Spin(rng))
+	}
+
+	return preview, nil
+}
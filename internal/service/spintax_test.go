@@ -0,0 +1,152 @@
+package service
+
+import (
+	"math/rand"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+func TestParseSpintax(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		variants []string
+	}{
+		{"plain text", "Hello there", []string{"Hello there"}},
+		{"group", "{Hi|Hello} there", []string{"Hi there", "Hello there"}},
+		{"groups", "{a|b}{c|d}", []string{"ac", "ad", "bc", "bd"}},
+		{"nested group", "{a|{b|c}}d", []string{"ad", "bd", "cd"}},
+		{"empty option", "a{|b}", []string{"a", "ab"}},
+		{"unclosed group", "{a|b", []string{"{a|b"}},
+		{"unclosed outer group", "{a|{b|c}", []string{"{a|b", "{a|c"}},
+		{"stray close", "a}b|c", []string{"a}b|c"}},
+		{"single option", "{a} b", []string{"{a} b"}},
+		{"nested single option", "{a|{b}}", []string{"a", "{b}"}},
+		{"variable", `{{name|"there"}}`, []string{`{{name|"there"}}`}},
+		{"variable in option", `{Hi {{name|"there"}}|Hello}!`, []string{`Hi {{name|"there"}}!`, "Hello!"}},
+		{"variable first in group", "Hi {{{name}}|friend}", []string{"Hi {{name}}", "Hi friend"}},
+		{"variable last in group", "Hi {friend|{{name}}}", []string{"Hi friend", "Hi {{name}}"}},
+		{"variable alone in group", "{{{name}}}", []string{"{{{name}}}"}},
+		{"unclosed group before variable", "{{{name}}|a", []string{"{{{name}}|a"}},
+		{"unclosed variable", "{a|b} {{name", []string{"a {{name", "b {{name"}},
+		{"unclosed variable in group", "{a|{{name}", []string{"{a|{{name}"}},
+		{"escaped braces", `{a|b}\{{c|d}`, []string{`a\{{c|d}`, `b\{{c|d}`}},
+		{"escaped braces in option", `{a|\{{b}c`, []string{"ac", `\{{bc`}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seq := parseSpintax(tt.text)
+			if got := seq.variants(); !slices.Equal(got, tt.variants) {
+				t.Errorf("variants() = %q, want %q", got, tt.variants)
+			}
+			if got := seq.Combinations().Int64(); got != int64(len(tt.variants)) {
+				t.Errorf("Combinations() = %d, want %d", got, len(tt.variants))
+			}
+		})
+	}
+}
+
+func TestParseSpintaxUnclosed(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"unclosed groups", strings.Repeat("{a|", 20000)},
+		{"unclosed nested groups", strings.Repeat("{", 20000) + "a|b"},
+		{"unclosed variables", strings.Repeat("{{a|", 20000)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			done := make(chan string)
+			go func() {
+				done <- parseSpintax(tt.text).Spin(rand.New(rand.NewSource(1)))
+			}()
+
+			select {
+			case got := <-done:
+				if got != tt.text {
+					t.Errorf("Spin() changed an unclosed text")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("parseSpintax() did not return")
+			}
+		})
+	}
+}
+
+func TestSpinSeed(t *testing.T) {
+	broadcast := &entity.Broadcast{Id: 7, Message: "{a|b|c|d|e|f|g|h}{1|2|3|4|5|6|7|8}"}
+
+	variants := make(map[string]bool)
+	for _, phone := range []string{"6281100000001", "6281100000002", "6281100000003", "6281100000004"} {
+		recipient := &entity.BroadcastRecipient{Phone: phone}
+
+		first, err := renderBroadcastMessage(broadcast, recipient, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range 5 {
+			if got, _ := renderBroadcastMessage(broadcast, recipient, time.Now()); got != first {
+				t.Fatalf("recipient %s got %q then %q", phone, first, got)
+			}
+		}
+		variants[first] = true
+	}
+
+	if len(variants) < 2 {
+		t.Errorf("every recipient got the same variant")
+	}
+}
+
+func TestValidateBroadcastMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		fields  []string
+		wantErr bool
+	}{
+		{"plain text", "Hello there", nil, false},
+		{"variable in option", "{Hi|Hello} {{first_name}}", nil, false},
+		{"field in option", "{Hi|Hello {{fields.city}}}", []string{"city"}, false},
+		{"unknown field in option", "{Hi|Hello {{fields.city}}}", nil, true},
+		{"unknown variable in nested option", "{Hi|{Hey|Hello {{nickname}}}}", nil, true},
+		{"unclosed variable in option", "{Hi {{name|Hello}", nil, true},
+		{"variable first in option", "{{{first_name}}|friend}", nil, false},
+		{"unknown variable first in option", "{{{nickname}}|friend}", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateBroadcastMessage(tt.message, tt.fields)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateBroadcastMessage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPreviewBroadcastMessage(t *testing.T) {
+	s := &Service{}
+
+	preview, err := s.PreviewBroadcastMessage("{a|b}{c|d}", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(preview.Variants) != 3 || preview.Combinations.Int64() != 4 {
+		t.Errorf("got %d of %s variants, want 3 of 4", len(preview.Variants), preview.Combinations)
+	}
+
+	preview, err = s.PreviewBroadcastMessage(strings.Repeat("{a|b}", 100), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(preview.Variants) != maxPreviewVariants {
+		t.Errorf("got %d variants, want %d", len(preview.Variants), maxPreviewVariants)
+	}
+}
@@ -0,0 +1,273 @@
+package service
+
+import (
+	"errors"
+	"math/big"
+	"math/rand"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+// Prefix of the variables taken from the custom fields of a contact
+const templateFieldPrefix = "fields."
+
+// The default layouts of the date helpers, a layout in quotes replaces it:
+//
+//	{{date "02/01/2006"}}
+var templateDateLayouts = map[string]string{
+	"date":    "2 January 2006",
+	"time":    "15:04",
+	"weekday": "Monday",
+}
+
+// The variables every recipient has
+var templateRecipientVars = []string{"name", "first_name", "phone"}
+
+// A {{ written as \{{ is kept as text
+const templateEscapedOpen = `\{{`
+
+// name, an optional quoted layout and an optional quoted fallback:
+//
+//	{{name|"Customer"}}
+var templateVarRegex = regexp.MustCompile(`^\s*([A-Za-z_]\w*(?:\.\w+)?)\s*("(?:[^"\\]|\\.)*")?\s*(?:\|\s*("(?:[^"\\]|\\.)*"))?\s*$`)
+
+type templatePart struct {
+	text     string
+	name     string
+	layout   string
+	fallback string
+}
+
+// BroadcastTemplate is a broadcast message with variables, rendered for each
+// recipient.
+type BroadcastTemplate struct {
+	parts []templatePart
+}
+
+// TemplateVars are the values the variables of a template are rendered with.
+type TemplateVars struct {
+	Name   string
+	Phone  string
+	Fields map[string]string
+	Now    time.Time
+}
+
+// ParseBroadcastTemplate parses the variables of a message, a text without
+// any is a template too.
+func ParseBroadcastTemplate(text string) (*BroadcastTemplate, error) {
+	var (
+		t = &BroadcastTemplate{}
+		b strings.Builder
+	)
+	flush := func() {
+		if b.Len() > 0 {
+			t.parts = append(t.parts, templatePart{text: b.String()})
+			b.Reset()
+		}
+	}
+
+	for i := 0; i < len(text); {
+		switch {
+		case strings.HasPrefix(text[i:], templateEscapedOpen):
+			b.WriteString("{{")
+			i += len(templateEscapedOpen)
+		case strings.HasPrefix(text[i:], "{{{"):
+			// The brace of a group of options before a variable
+			b.WriteByte('{')
+			i++
+		case strings.HasPrefix(text[i:], "{{"):
+			end := strings.Index(text[i+2:], "}}")
+			if end < 0 {
+				return nil, errors.New("template has an unclosed {{ at " + strconv.Itoa(i))
+			}
+
+			part, err := parseTemplateTag(text[i+2 : i+2+end])
+			if err != nil {
+				return nil, err
+			}
+			flush()
+			t.parts = append(t.parts, part)
+			i += end + 4
+		default:
+			b.WriteByte(text[i])
+			i++
+		}
+	}
+	flush()
+
+	return t, nil
+}
+
+func parseTemplateTag(tag string) (templatePart, error) {
+	var (
+		part templatePart
+		err  error
+	)
+
+	m := templateVarRegex.FindStringSubmatch(tag)
+	if m == nil {
+		return part, errors.New("invalid template variable {{" + tag + "}}")
+	}
+
+	part.name = m[1]
+	if m[2] != "" {
+		if _, ok := templateDateLayouts[part.name]; !ok {
+			return part, errors.New("template variable " + part.name + " has no format")
+		}
+		if part.layout, err = strconv.Unquote(m[2]); err != nil {
+			return part, errors.New("invalid format of template variable " + part.name)
+		}
+	}
+	if m[3] != "" {
+		if part.fallback, err = strconv.Unquote(m[3]); err != nil {
+			return part, errors.New("invalid fallback of template variable " + part.name)
+		}
+	}
+
+	return part, nil
+}
+
+// Variables returns the names of the variables used in the template, each
+// once.
+func (t *BroadcastTemplate) Variables() []string {
+	names := make([]string, 0)
+	for _, part := range t.parts {
+		if part.name != "" && !slices.Contains(names, part.name) {
+			names = append(names, part.name)
+		}
+	}
+
+	return names
+}
+
+// Validate rejects the variables a recipient has no value for, fields are
+// the custom contact fields the recipients may have.
+func (t *BroadcastTemplate) Validate(fields []string) error {
+	for _, name := range t.Variables() {
+		if _, ok := templateDateLayouts[name]; ok || slices.Contains(templateRecipientVars, name) {
+			continue
+		}
+		if field, ok := strings.CutPrefix(name, templateFieldPrefix); ok && slices.Contains(fields, field) {
+			continue
+		}
+
+		return errors.New("unknown template variable " + name)
+	}
+
+	return nil
+}
+
+// Render returns the text of the template with the values of vars, the
+// fallback of a variable replaces an empty value. A custom field the
+// recipient does not have and which has no fallback is an error.
+func (t *BroadcastTemplate) Render(vars TemplateVars) (string, error) {
+	var b strings.Builder
+
+	for _, part := range t.parts {
+		if part.name == "" {
+			b.WriteString(part.text)
+			continue
+		}
+
+		value, ok := vars.value(part.name, part.layout)
+		if !ok && part.fallback == "" {
+			return "", errors.New("recipient has no value for template variable " + part.name)
+		}
+		if strings.TrimSpace(value) == "" {
+			value = part.fallback
+		}
+		b.WriteString(value)
+	}
+
+	return b.String(), nil
+}
+
+func (vars TemplateVars) value(name string, layout string) (string, bool) {
+	if defaultLayout, ok := templateDateLayouts[name]; ok {
+		if layout == "" {
+			layout = defaultLayout
+		}
+		return vars.Now.Format(layout), true
+	}
+
+	switch name {
+	case "name":
+		return vars.Name, true
+	case "first_name":
+		first, _, _ := strings.Cut(strings.TrimSpace(vars.Name), " ")
+		return first, true
+	case "phone":
+		phone, _, _ := strings.Cut(vars.Phone, "@")
+		return phone, true
+	}
+
+	if field, ok := strings.CutPrefix(name, templateFieldPrefix); ok {
+		value, ok := vars.Fields[field]
+		return value, ok
+	}
+
+	return "", false
+}
+
+// ValidateBroadcastMessage rejects a broadcast message using variables its
+// recipients have no value for, only contacts have custom fields and a field
+// needs a value for every recipient. The texts of its spintax are checked
+// too, and every variant when there are few.
+func (s *Service) ValidateBroadcastMessage(broadcast *entity.Broadcast) error {
+	var err error
+
+	fields := make([]string, 0)
+	if broadcast.ContactType == "c" {
+		if fields, err = s.Repo.GetBroadcastContactFieldKeys(broadcast); err != nil {
+			return err
+		}
+	}
+
+	return validateBroadcastMessage(broadcast.Message, fields)
+}
+
+func validateBroadcastMessage(message string, fields []string) error {
+	seq := parseSpintax(message)
+	texts := append([]string{message}, seq.texts()...)
+	if seq.Combinations().Cmp(big.NewInt(maxPreviewVariants)) <= 0 {
+		texts = append(texts, seq.variants()...)
+	}
+
+	for _, text := range texts {
+		t, err := ParseBroadcastTemplate(text)
+		if err != nil {
+			return err
+		}
+		if err = t.Validate(fields); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// renderBroadcastMessage returns the message of the broadcast for the
+// recipient: the variant of its spintax for the recipient, rendered with the
+// time in the time zone of the device. A variant that is not a valid template
+// fails the recipient.
+func renderBroadcastMessage(broadcast *entity.Broadcast, recipient *entity.BroadcastRecipient, now time.Time) (string, error) {
+	rng := rand.New(rand.NewSource(spinSeed(broadcast.Id, recipient.Phone)))
+	message := parseSpintax(broadcast.Message).Spin(rng)
+
+	t, err := ParseBroadcastTemplate(message)
+	if err != nil {
+		return "", err
+	}
+
+	return t.Render(TemplateVars{
+		Name:   recipient.Name,
+		Phone:  recipient.Phone,
+		Fields: recipient.Fields,
+		Now:    now.In(broadcast.Device.Location()),
+	})
+}
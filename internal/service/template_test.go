@@ -0,0 +1,172 @@
+package service
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+func TestParseBroadcastTemplate(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		variables []string
+		wantErr   bool
+	}{
+		{"plain text", "Hello there", []string{}, false},
+		{"variable", "Hi {{name}}", []string{"name"}, false},
+		{"spaces", "Hi {{ first_name }}", []string{"first_name"}, false},
+		{"field", "From {{fields.city}}", []string{"fields.city"}, false},
+		{"fallback", `Hi {{name|"Customer"}}`, []string{"name"}, false},
+		{"date layout", `On {{date "02/01/2006"}}`, []string{"date"}, false},
+		{"date layout and fallback", `On {{date "02/01/2006"|"today"}}`, []string{"date"}, false},
+		{"variables once", "{{name}} {{phone}} {{name}}", []string{"name", "phone"}, false},
+		{"escaped braces", `Use \{{name}} for the name`, []string{}, false},
+		{"brace before variable", "Hi {{{name}}|friend}", []string{"name"}, false},
+		{"unclosed", "Hi {{name", nil, true},
+		{"unclosed after variable", "Hi {{name}} {{", nil, true},
+		{"invalid variable", "Hi {{first name}}", nil, true},
+		{"layout of a text variable", `Hi {{name "x"}}`, nil, true},
+		{"unquoted fallback", "Hi {{name|Customer}}", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := ParseBroadcastTemplate(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseBroadcastTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := tmpl.Variables(); !slices.Equal(got, tt.variables) {
+				t.Errorf("Variables() = %q, want %q", got, tt.variables)
+			}
+		})
+	}
+}
+
+func TestBroadcastTemplateValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		fields  []string
+		wantErr bool
+	}{
+		{"recipient variables", "{{name}} {{first_name}} {{phone}}", nil, false},
+		{"date helpers", "{{date}} {{time}} {{weekday}}", nil, false},
+		{"known field", "{{fields.city}}", []string{"city"}, false},
+		{"unknown field", "{{fields.city}}", []string{"country"}, true},
+		{"unknown variable", "{{nickname}}", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := ParseBroadcastTemplate(tt.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = tmpl.Validate(tt.fields); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBroadcastTemplateRender(t *testing.T) {
+	now := time.Date(2026, time.March, 9, 14, 5, 0, 0, time.UTC)
+	vars := TemplateVars{
+		Name:   "Budi Santoso",
+		Phone:  "6281100000001@s.whatsapp.net",
+		Fields: map[string]string{"city": "Bandung", "note": " "},
+		Now:    now,
+	}
+
+	tests := []struct {
+		name    string
+		text    string
+		want    string
+		wantErr bool
+	}{
+		{"plain text", "Hello there", "Hello there", false},
+		{"name", "Hi {{name}}", "Hi Budi Santoso", false},
+		{"first name", "Hi {{first_name}}", "Hi Budi", false},
+		{"phone", "{{phone}}", "6281100000001", false},
+		{"field", "From {{fields.city}}", "From Bandung", false},
+		{"empty field fallback", `{{fields.note|"-"}}`, "-", false},
+		{"missing field fallback", `{{fields.country|"Indonesia"}}`, "Indonesia", false},
+		{"missing field", "{{fields.country}}", "", true},
+		{"date", "{{date}}", "9 March 2026", false},
+		{"date layout", `{{date "02/01/2006"}}`, "09/03/2026", false},
+		{"time", "{{time}}", "14:05", false},
+		{"weekday", "{{weekday}}", "Monday", false},
+		{"escaped braces", `Use \{{name}} for the name`, "Use {{name}} for the name", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := ParseBroadcastTemplate(tt.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := tmpl.Render(vars)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Render() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderBroadcastMessage(t *testing.T) {
+	now := time.Date(2026, time.March, 9, 20, 30, 0, 0, time.UTC)
+	recipient := &entity.BroadcastRecipient{Name: "Budi", Phone: "6281100000001"}
+
+	tests := []struct {
+		name      string
+		broadcast *entity.Broadcast
+		want      string
+		wantErr   bool
+	}{
+		{
+			"device time zone",
+			&entity.Broadcast{Message: "{{time}}", Device: &entity.Device{Timezone: "Asia/Jakarta"}},
+			"03:30",
+			false,
+		},
+		{
+			"unknown device time zone",
+			&entity.Broadcast{Message: "{{name}}", Device: &entity.Device{Timezone: "Nowhere/City"}},
+			"Budi",
+			false,
+		},
+		{
+			"invalid variant",
+			&entity.Broadcast{Message: "{Hi {{name|Hello}", Device: &entity.Device{}},
+			"",
+			true,
+		},
+		{
+			"missing field",
+			&entity.Broadcast{Message: "{{fields.city}}", Device: &entity.Device{}},
+			"",
+			true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderBroadcastMessage(tt.broadcast, recipient, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("renderBroadcastMessage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("renderBroadcastMessage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
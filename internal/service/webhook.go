@@ -0,0 +1,230 @@
+package service
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+const (
+	webhookTimeout     = 10 * time.Second
+	webhookMaxAttempts = 5
+	webhookBaseBackoff = 2 * time.Second
+
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	WebhookEventHeader     = "X-Webhook-Event"
+	WebhookDeliveryHeader  = "X-Webhook-Delivery"
+)
+
+var webhookClient = &http.Client{Timeout: webhookTimeout}
+
+// A delivery still pending this long after it was recorded has been left by a
+// process that stopped, sending one takes a couple of minutes at most
+const (
+	webhookStaleAfter = 10 * time.Minute
+	webhookStaleBatch = 100
+)
+
+// The deliveries being sent by this process
+var webhookInFlight sync.Map
+
+func webhookSubscribed(webhook *entity.Webhook, eventType string) bool {
+	return len(webhook.Events) == 0 || slices.Contains(webhook.Events, "*") || slices.Contains(webhook.Events, eventType)
+}
+
+func signWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// dispatchWebhooks records a delivery for every webhook subscribed to the
+// event and sends them in the background.
+func (s *Service) dispatchWebhooks(evt *entity.Event) error {
+	webhooks, err := s.Repo.GetActiveWebhooks(evt.DeviceId)
+	if err != nil || len(webhooks) == 0 {
+		return err
+	}
+
+	payload, err := json.Marshal(evt)
+	if err != nil {
+		return err
+	}
+
+	for _, webhook := range webhooks {
+		if !webhookSubscribed(webhook, evt.Type) {
+			continue
+		}
+
+		delivery := &entity.WebhookDelivery{
+			WebhookId: webhook.Id,
+			EventId:   evt.Id,
+			EventType: evt.Type,
+			Payload:   payload,
+			Status:    "pending",
+		}
+		err = s.Repo.InsertWebhookDelivery(delivery)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		s.startWebhookDelivery(webhook, delivery)
+	}
+
+	return nil
+}
+
+// ResendStaleWebhookDeliveries sends the deliveries a stopped process left
+// pending.
+func (s *Service) ResendStaleWebhookDeliveries() {
+	deliveries, err := s.Repo.GetStaleWebhookDeliveries(time.Now().Add(-webhookStaleAfter), webhookStaleBatch)
+	if err != nil {
+		log.Printf("GetStaleWebhookDeliveries Error: %s", err.Error())
+		return
+	}
+
+	for _, delivery := range deliveries {
+		if _, ok := webhookInFlight.Load(delivery.Id); ok {
+			continue
+		}
+
+		webhook, err := s.Repo.GetActiveWebhook(delivery.WebhookId)
+		if err != nil {
+			delivery.Status = "failed"
+			delivery.Error = "webhook is inactive or has been deleted"
+			if err = s.Repo.UpdateWebhookDelivery(delivery); err != nil {
+				log.Printf("UpdateWebhookDelivery Error: %s", err.Error())
+			}
+			continue
+		}
+
+		log.Printf("Webhook %d delivery %d was left pending, sending it again", webhook.Id, delivery.Id)
+		s.startWebhookDelivery(webhook, delivery)
+	}
+}
+
+// ReplayWebhookDelivery resets the attempt counter of a delivery and sends its
+// stored payload again in the background, delivery is left as it is reset. A
+// pending delivery can be replayed once it is not being sent anymore.
+func (s *Service) ReplayWebhookDelivery(webhook *entity.Webhook, delivery *entity.WebhookDelivery) error {
+	if _, ok := webhookInFlight.Load(delivery.Id); ok {
+		return errors.New("delivery is still in progress")
+	}
+	if delivery.Status == "pending" && time.Since(delivery.CreatedAt) < webhookStaleAfter {
+		return errors.New("delivery is still in progress")
+	}
+
+	delivery.Status = "pending"
+	delivery.Attempts = 0
+	delivery.Error = ""
+	delivery.DeliveredAt = nil
+
+	err := s.Repo.UpdateWebhookDelivery(delivery)
+	if err != nil {
+		return err
+	}
+
+	sending := *delivery
+	s.startWebhookDelivery(webhook, &sending)
+
+	return nil
+}
+
+// startWebhookDelivery sends the delivery in the background, the service
+// waits for it when it stops.
+func (s *Service) startWebhookDelivery(webhook *entity.Webhook, delivery *entity.WebhookDelivery) {
+	webhookInFlight.Store(delivery.Id, true)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.deliverWebhook(webhook, delivery)
+	}()
+}
+
+// deliverWebhook posts the delivery until it succeeds or runs out of
+// attempts. One interrupted by a shutdown is left pending and sent again as a
+// stale delivery.
+func (s *Service) deliverWebhook(webhook *entity.Webhook, delivery *entity.WebhookDelivery) {
+	defer webhookInFlight.Delete(delivery.Id)
+
+	backoff := webhookBaseBackoff
+
+	for delivery.Attempts < webhookMaxAttempts {
+		delivery.Attempts++
+
+		statusCode, err := postWebhook(webhook, delivery)
+		delivery.StatusCode = statusCode
+		if err == nil {
+			now := time.Now()
+			delivery.Status = "success"
+			delivery.Error = ""
+			delivery.DeliveredAt = &now
+		} else {
+			delivery.Error = err.Error()
+			if delivery.Attempts >= webhookMaxAttempts {
+				delivery.Status = "failed"
+			}
+		}
+
+		if updateErr := s.Repo.UpdateWebhookDelivery(delivery); updateErr != nil {
+			log.Printf("UpdateWebhookDelivery Error: %s", updateErr.Error())
+		}
+
+		if err == nil {
+			return
+		}
+
+		log.Printf("Webhook %d delivery %d attempt %d failed: %s", webhook.Id, delivery.Id, delivery.Attempts, err.Error())
+		if delivery.Attempts < webhookMaxAttempts {
+			if !sleepContext(s.ctx, backoff) {
+				return
+			}
+			backoff *= 2
+		}
+	}
+}
+
+func postWebhook(webhook *entity.Webhook, delivery *entity.WebhookDelivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, webhook.Url, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "go-wa-api-webhook")
+	req.Header.Set(WebhookEventHeader, delivery.EventType)
+	req.Header.Set(WebhookDeliveryHeader, strconv.FormatInt(delivery.Id, 10))
+	req.Header.Set(WebhookSignatureHeader, signWebhookPayload(webhook.Secret, delivery.Payload))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, errors.New("unexpected response status: " + resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
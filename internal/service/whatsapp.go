@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	qrCode "github.com/skip2/go-qrcode"
@@ -26,6 +27,13 @@ import (
 
 var whatsAppClients = make(map[string]*whatsmeow.Client)
 
+var errWAClientNotFound = errors.New("whatsapp client not found or not logged in")
+
+// The device props of whatsmeow are global and sent while a new device is
+// paired, the history sync settings of a device are only set while its client
+// connects for pairing
+var pairingMu sync.Mutex
+
 func (s *Service) WhatsAppCreateClient(uDevice *entity.Device) error {
 	log.Println("WhatsApp create client")
 
@@ -39,14 +47,10 @@ func (s *Service) WhatsAppCreateClient(uDevice *entity.Device) error {
 		if uDevice.Jid == nil {
 			waDevice = s.waDataStore.NewDevice()
 
+			pairingMu.Lock()
 			wastore.DeviceProps.Os = proto.String(whatsAppGetUserOS())
 			wastore.DeviceProps.PlatformType = whatsAppGetUserAgent("chrome").Enum()
-			wastore.DeviceProps.RequireFullSync = proto.Bool(false)
-			wastore.DeviceProps.HistorySyncConfig = &waCompanionReg.DeviceProps_HistorySyncConfig{
-				FullSyncDaysLimit:   proto.Uint32(1),
-				FullSyncSizeMbLimit: proto.Uint32(10),
-				StorageQuotaMb:      proto.Uint32(10),
-			}
+			pairingMu.Unlock()
 		} else {
 			waDevice, err = s.waDataStore.GetDevice(*uDevice.Jid)
 			if err != nil {
@@ -58,7 +62,7 @@ func (s *Service) WhatsAppCreateClient(uDevice *entity.Device) error {
 		whatsAppClients[uDevice.Id].EnableAutoReconnect = true
 		whatsAppClients[uDevice.Id].AutoTrustIdentity = true
 
-		registerWAEventHandler(whatsAppClients[uDevice.Id], s.Repo, uDevice)
+		registerWAEventHandler(s, whatsAppClients[uDevice.Id], uDevice)
 	}
 
 	return nil
@@ -90,7 +94,7 @@ func (s *Service) WhatsAppLogin(uDevice *entity.Device) (string, int, error) {
 
 		// Connect WebSocket while Initialize QR Code Data to be Sent
 		if !whatsAppClients[uDevice.Id].IsConnected() {
-			err = whatsAppClients[uDevice.Id].Connect()
+			err = s.whatsAppConnectPairing(uDevice, whatsAppClients[uDevice.Id])
 			if err != nil {
 				return "", 0, err
 			}
@@ -111,6 +115,34 @@ func (s *Service) WhatsAppLogin(uDevice *entity.Device) (string, int, error) {
 	}
 }
 
+// whatsAppConnectPairing connects the client of a device to be paired with the
+// history sync settings of the device, the device props are restored once the
+// registration has been sent.
+func (s *Service) whatsAppConnectPairing(uDevice *entity.Device, client *whatsmeow.Client) error {
+	hs, err := s.Repo.GetHistorySync(uDevice.Id)
+	if err != nil {
+		return err
+	}
+
+	pairingMu.Lock()
+	defer pairingMu.Unlock()
+
+	requireFullSync, historySyncConfig := wastore.DeviceProps.RequireFullSync, wastore.DeviceProps.HistorySyncConfig
+	defer func() {
+		wastore.DeviceProps.RequireFullSync = requireFullSync
+		wastore.DeviceProps.HistorySyncConfig = historySyncConfig
+	}()
+
+	wastore.DeviceProps.RequireFullSync = proto.Bool(hs.FullSync)
+	wastore.DeviceProps.HistorySyncConfig = &waCompanionReg.DeviceProps_HistorySyncConfig{
+		FullSyncDaysLimit:   proto.Uint32(uint32(hs.Days)),
+		FullSyncSizeMbLimit: proto.Uint32(uint32(hs.SizeMb)),
+		StorageQuotaMb:      proto.Uint32(uint32(hs.SizeMb)),
+	}
+
+	return client.Connect()
+}
+
 func (s *Service) WhatsAppReconnect(uDevice *entity.Device) error {
 	var err error
 
@@ -181,21 +213,28 @@ func (s *Service) CheckPhone(deviceId string, phones []string) ([]types.IsOnWhat
 }
 
 func (s *Service) SendMessage(deviceId string, recipient string, message string, file entity.UploadedFile) (r whatsmeow.SendResponse, err error) {
+	var to types.JID
+
+	to, err = parseJID(recipient)
+	log.Printf("Sent To: %s", to.String())
+	if err != nil {
+		return
+	}
+
+	return s.sendWAMessage(deviceId, to, message, file)
+}
+
+// sendWAMessage sends the message to an already parsed JID, e.g. the chat of a
+// received message which may be a group.
+func (s *Service) sendWAMessage(deviceId string, to types.JID, message string, file entity.UploadedFile) (r whatsmeow.SendResponse, err error) {
 	var (
-		to      types.JID
 		waMsg   *waE2E.Message
 		msgType string
 	)
 
 	c := getWAClient(deviceId)
 	if c == nil {
-		err = errors.New("whatsapp client not found or not logged in")
-		return
-	}
-
-	to, err = parseJID(recipient)
-	log.Printf("Sent To: %s", to.String())
-	if err != nil {
+		err = errWAClientNotFound
 		return
 	}
 
@@ -258,6 +297,14 @@ func (s *Service) SendMessage(deviceId string, recipient string, message string,
 			Timestamp: r.Timestamp,
 			Type:      msgType,
 		}
+		if c.Store.ID != nil {
+			sender := c.Store.ID.ToNonAD()
+			m.Sender = &sender
+		}
+		if !c.Store.LID.IsEmpty() {
+			senderAlt := c.Store.LID.ToNonAD()
+			m.SenderAlt = &senderAlt
+		}
 
 		s.Repo.InsertWAMessage(m)
 	}
@@ -278,10 +325,15 @@ func (s *Service) SendBroadcastMessage(broadcastToSend *entity.BroadcastToSend)
 
 		time.Sleep(time.Duration(x) * time.Second)
 	*/
+	message := broadcastToSend.Broadcast.Message
+	if broadcastToSend.Recipient.Message != nil {
+		message = *broadcastToSend.Recipient.Message
+	}
+
 	response, err := s.SendMessage(
 		broadcastToSend.Broadcast.Device.Id,
 		broadcastToSend.Recipient.Phone,
-		broadcastToSend.Broadcast.Message,
+		message,
 		*broadcastToSend.Broadcast.Media,
 	)
 
@@ -294,7 +346,12 @@ func (s *Service) MarkAsRead(deviceId string, chat types.JID, messageIds []types
 		return errors.New("whatsapp client not found or not logged in")
 	}
 
-	return c.MarkRead(messageIds, time.Now(), chat, *c.Store.ID)
+	err := c.MarkRead(messageIds, time.Now(), chat, *c.Store.ID)
+	if err != nil {
+		return err
+	}
+
+	return s.Repo.MarkUserChatRead(deviceId, chat, time.Now())
 }
 
 func (s *Service) SendChatPresence(deviceId string, phone string, state types.ChatPresence, media types.ChatPresenceMedia) error {
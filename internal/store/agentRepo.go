@@ -0,0 +1,89 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+
+	"go.mau.fi/util/dbutil"
+
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+const agentTable = "user_agents"
+
+const (
+	agentColumns = "id, user_id, name, created_at"
+
+	getAgentsQuery       = "SELECT " + agentColumns + " FROM " + agentTable + " WHERE user_id=$1 ORDER BY name ASC"
+	getAgentByNameQuery  = "SELECT " + agentColumns + " FROM " + agentTable + " WHERE user_id=$1 AND name=$2"
+	insertAgentQuery     = `INSERT INTO ` + agentTable + ` (user_id, name) VALUES ($1, $2) RETURNING id, created_at`
+	deleteAgentQuery     = `DELETE FROM ` + agentTable + ` WHERE id=$1 AND user_id=$2`
+	deviceAgentNameQuery = `SELECT EXISTS(SELECT 1 FROM ` + agentTable + ` a
+		JOIN ` + userDeviceTableName + ` d ON d.user_id=a.user_id
+		WHERE d.id=$1 AND a.name=$2)`
+)
+
+func (r *Repo) ScanAgent(row dbutil.Scannable) (*entity.Agent, error) {
+	var agent entity.Agent
+
+	err := row.Scan(
+		&agent.Id,
+		&agent.UserId,
+		&agent.Name,
+		&agent.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &agent, nil
+}
+
+func (r *Repo) GetAgents(userId int) ([]*entity.Agent, error) {
+	agents := make([]*entity.Agent, 0)
+
+	rows, err := r.db.Query(getAgentsQuery, userId)
+	if err != nil {
+		return agents, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		agent, err := r.ScanAgent(rows)
+		if err != nil {
+			return agents, err
+		}
+		agents = append(agents, agent)
+	}
+
+	return agents, rows.Err()
+}
+
+// GetAgentByName returns the agent of the user with the name, nil when there
+// is none.
+func (r *Repo) GetAgentByName(userId int, name string) (*entity.Agent, error) {
+	agent, err := r.ScanAgent(r.db.QueryRow(getAgentByNameQuery, userId, name))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	return agent, err
+}
+
+func (r *Repo) InsertAgent(agent *entity.Agent) error {
+	return r.db.QueryRow(insertAgentQuery, agent.UserId, agent.Name).Scan(&agent.Id, &agent.CreatedAt)
+}
+
+func (r *Repo) DeleteAgent(agentId int64, userId int) error {
+	_, err := r.db.Exec(deleteAgentQuery, agentId, userId)
+
+	return err
+}
+
+// IsDeviceAgent tells if the name is an agent of the user of the device.
+func (r *Repo) IsDeviceAgent(deviceId string, name string) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow(deviceAgentNameQuery, deviceId, name).Scan(&exists)
+
+	return exists, err
+}
@@ -0,0 +1,221 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+	"go.mau.fi/whatsmeow/types"
+
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+const (
+	autoReplyTable    = "user_auto_replies"
+	autoReplyLogTable = "user_auto_reply_logs"
+)
+
+const (
+	autoReplyColumns = `id, device_id, name, match_type, keyword, reply_message, reply_media,
+		cooldown_seconds, active_from, active_to, priority, include_groups, active, created_at`
+
+	getAutoRepliesQuery       = "SELECT " + autoReplyColumns + " FROM " + autoReplyTable + " WHERE device_id=$1 ORDER BY priority DESC, id ASC"
+	getActiveAutoRepliesQuery = "SELECT " + autoReplyColumns + " FROM " + autoReplyTable + " WHERE device_id=$1 AND active=true ORDER BY priority DESC, id ASC"
+	getAutoReplyByIdQuery     = "SELECT " + autoReplyColumns + " FROM " + autoReplyTable + " WHERE id=$1 AND device_id=$2"
+	insertAutoReplyQuery      = `INSERT INTO ` + autoReplyTable + ` (
+			device_id, name, match_type, keyword, reply_message, reply_media,
+			cooldown_seconds, active_from, active_to, priority, include_groups, active
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id, created_at`
+	updateAutoReplyQuery = `UPDATE ` + autoReplyTable + ` SET
+			name=$1, match_type=$2, keyword=$3, reply_message=$4, reply_media=$5,
+			cooldown_seconds=$6, active_from=$7, active_to=$8, priority=$9, include_groups=$10, active=$11
+		WHERE id=$12 AND device_id=$13`
+	deleteAutoReplyQuery      = `DELETE FROM ` + autoReplyTable + ` WHERE id=$1 AND device_id=$2`
+	insertAutoReplyLogQuery   = `INSERT INTO ` + autoReplyLogTable + ` (rule_id, device_id, their_jid, message_id, reply_message_id) VALUES ($1, $2, $3, $4, $5)`
+	getLastAutoReplyQuery     = `SELECT created_at FROM ` + autoReplyLogTable + ` WHERE rule_id=$1 AND their_jid=$2 ORDER BY created_at DESC LIMIT 1`
+	getAutoReplyLogsQuery     = `SELECT id, rule_id, device_id, their_jid, message_id, reply_message_id, created_at FROM ` + autoReplyLogTable + ` WHERE rule_id=$1 ORDER BY id DESC LIMIT $2 OFFSET $3`
+	getAutoReplyLogCountQuery = `SELECT COUNT(*) FROM ` + autoReplyLogTable + ` WHERE rule_id=$1`
+)
+
+func (r *Repo) ScanAutoReplyRule(row dbutil.Scannable) (*entity.AutoReplyRule, error) {
+	var (
+		rule                 entity.AutoReplyRule
+		media                entity.UploadedFile
+		activeFrom, activeTo sql.NullString
+		active               bool
+	)
+
+	err := row.Scan(
+		&rule.Id,
+		&rule.DeviceId,
+		&rule.Name,
+		&rule.MatchType,
+		&rule.Keyword,
+		&rule.ReplyMessage,
+		&media,
+		&rule.CooldownSeconds,
+		&activeFrom,
+		&activeTo,
+		&rule.Priority,
+		&rule.IncludeGroups,
+		&active,
+		&rule.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if media.Data != "" {
+		rule.ReplyMedia = &media
+	}
+	rule.ActiveFrom = activeFrom.String
+	rule.ActiveTo = activeTo.String
+	rule.Active = &active
+
+	return &rule, nil
+}
+
+func (r *Repo) getAutoReplyRules(q string, deviceId string) ([]*entity.AutoReplyRule, error) {
+	rules := make([]*entity.AutoReplyRule, 0)
+
+	rows, err := r.db.Query(q, deviceId)
+	if err != nil {
+		return rules, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		rule, scanErr := r.ScanAutoReplyRule(rows)
+		if scanErr == nil {
+			rules = append(rules, rule)
+		}
+	}
+
+	return rules, nil
+}
+
+func (r *Repo) GetAutoReplyRules(deviceId string) ([]*entity.AutoReplyRule, error) {
+	return r.getAutoReplyRules(getAutoRepliesQuery, deviceId)
+}
+
+// GetActiveAutoReplyRules returns the active rules ordered by priority,
+// highest first.
+func (r *Repo) GetActiveAutoReplyRules(deviceId string) ([]*entity.AutoReplyRule, error) {
+	return r.getAutoReplyRules(getActiveAutoRepliesQuery, deviceId)
+}
+
+func (r *Repo) GetAutoReplyRule(ruleId int64, deviceId string) (*entity.AutoReplyRule, error) {
+	return r.ScanAutoReplyRule(r.db.QueryRow(getAutoReplyByIdQuery, ruleId, deviceId))
+}
+
+// SaveAutoReplyRule inserts or updates the rule, a rule without Active is
+// active.
+func (r *Repo) SaveAutoReplyRule(rule *entity.AutoReplyRule) error {
+	var (
+		err   error
+		media entity.UploadedFile
+	)
+
+	if rule.ReplyMedia != nil {
+		media = *rule.ReplyMedia
+	}
+	if rule.Active == nil {
+		active := true
+		rule.Active = &active
+	}
+
+	if rule.Id != 0 {
+		_, err = r.db.Exec(
+			updateAutoReplyQuery,
+			rule.Name,
+			rule.MatchType,
+			rule.Keyword,
+			rule.ReplyMessage,
+			media,
+			rule.CooldownSeconds,
+			rule.ActiveFrom,
+			rule.ActiveTo,
+			rule.Priority,
+			rule.IncludeGroups,
+			*rule.Active,
+			rule.Id,
+			rule.DeviceId,
+		)
+	} else {
+		err = r.db.QueryRow(
+			insertAutoReplyQuery,
+			rule.DeviceId,
+			rule.Name,
+			rule.MatchType,
+			rule.Keyword,
+			rule.ReplyMessage,
+			media,
+			rule.CooldownSeconds,
+			rule.ActiveFrom,
+			rule.ActiveTo,
+			rule.Priority,
+			rule.IncludeGroups,
+			*rule.Active,
+		).Scan(&rule.Id, &rule.CreatedAt)
+	}
+
+	return err
+}
+
+func (r *Repo) DeleteAutoReplyRule(ruleId int64, deviceId string) error {
+	_, err := r.db.Exec(deleteAutoReplyQuery, ruleId, deviceId)
+
+	return err
+}
+
+func (r *Repo) InsertAutoReplyLog(l *entity.AutoReplyLog) error {
+	_, err := r.db.Exec(insertAutoReplyLogQuery, l.RuleId, l.DeviceId, l.TheirJID, l.MessageId, l.ReplyMessageId)
+
+	return err
+}
+
+// GetLastAutoReplyAt returns the last time the rule replied to the chat, or
+// the zero time when it never did.
+func (r *Repo) GetLastAutoReplyAt(ruleId int64, theirJID types.JID) (time.Time, error) {
+	var lastAt time.Time
+
+	err := r.db.QueryRow(getLastAutoReplyQuery, ruleId, theirJID).Scan(&lastAt)
+	if err == sql.ErrNoRows {
+		return lastAt, nil
+	}
+
+	return lastAt, err
+}
+
+func (r *Repo) GetAutoReplyLogs(ruleId int64, limit int, offset int) ([]entity.AutoReplyLog, int, error) {
+	logs := make([]entity.AutoReplyLog, 0)
+	total := 0
+
+	err := r.db.QueryRow(getAutoReplyLogCountQuery, ruleId).Scan(&total)
+	if err != nil || total == 0 {
+		return logs, total, err
+	}
+
+	rows, err := r.db.Query(getAutoReplyLogsQuery, ruleId, limit, offset)
+	if err != nil {
+		return logs, total, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var l entity.AutoReplyLog
+		if err := rows.Scan(
+			&l.Id,
+			&l.RuleId,
+			&l.DeviceId,
+			&l.TheirJID,
+			&l.MessageId,
+			&l.ReplyMessageId,
+			&l.CreatedAt,
+		); err == nil {
+			logs = append(logs, l)
+		}
+	}
+
+	return logs, total, nil
+}
@@ -0,0 +1,216 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+	"go.mau.fi/whatsmeow/types"
+
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+const (
+	botFlowTable    = "user_bot_flows"
+	botSessionTable = "user_bot_sessions"
+)
+
+const (
+	botFlowColumns    = "id, device_id, name, definition, active, created_at, updated_at"
+	botSessionColumns = "device_id, their_jid, flow_id, state, variables, started_at, updated_at, expires_at"
+
+	getBotFlowsQuery       = "SELECT " + botFlowColumns + " FROM " + botFlowTable + " WHERE device_id=$1 ORDER BY id ASC"
+	getActiveBotFlowsQuery = "SELECT " + botFlowColumns + " FROM " + botFlowTable + " WHERE device_id=$1 AND active=true ORDER BY id ASC"
+	getBotFlowByIdQuery    = "SELECT " + botFlowColumns + " FROM " + botFlowTable + " WHERE id=$1 AND device_id=$2"
+	insertBotFlowQuery     = `INSERT INTO ` + botFlowTable + ` (device_id, name, definition, active) VALUES ($1, $2, $3, $4) RETURNING id, created_at, updated_at`
+	updateBotFlowQuery     = `UPDATE ` + botFlowTable + ` SET name=$1, definition=$2, active=$3, updated_at=now() WHERE id=$4 AND device_id=$5 RETURNING created_at, updated_at`
+	deleteBotFlowQuery     = `DELETE FROM ` + botFlowTable + ` WHERE id=$1 AND device_id=$2`
+
+	getBotSessionQuery  = "SELECT " + botSessionColumns + " FROM " + botSessionTable + " WHERE device_id=$1 AND their_jid=$2"
+	getBotSessionsQuery = "SELECT " + botSessionColumns + " FROM " + botSessionTable + ` WHERE device_id=$1
+		AND (expires_at IS NULL OR expires_at > now()) ORDER BY updated_at DESC LIMIT $2 OFFSET $3`
+	getBotSessionCountQuery = "SELECT COUNT(*) FROM " + botSessionTable + " WHERE device_id=$1 AND (expires_at IS NULL OR expires_at > now())"
+	saveBotSessionQuery     = `INSERT INTO ` + botSessionTable + ` (
+			device_id, their_jid, flow_id, state, variables, started_at, updated_at, expires_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (device_id, their_jid) DO UPDATE SET
+			flow_id=EXCLUDED.flow_id, state=EXCLUDED.state, variables=EXCLUDED.variables,
+			started_at=EXCLUDED.started_at, updated_at=EXCLUDED.updated_at, expires_at=EXCLUDED.expires_at`
+	deleteBotSessionQuery = `DELETE FROM ` + botSessionTable + ` WHERE device_id=$1 AND their_jid=$2`
+)
+
+func (r *Repo) ScanBotFlow(row dbutil.Scannable) (*entity.BotFlow, error) {
+	var flow entity.BotFlow
+
+	flow.Definition = &entity.BotFlowDefinition{}
+	err := row.Scan(
+		&flow.Id,
+		&flow.DeviceId,
+		&flow.Name,
+		flow.Definition,
+		&flow.Active,
+		&flow.CreatedAt,
+		&flow.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &flow, nil
+}
+
+func (r *Repo) getBotFlows(q string, deviceId string) ([]*entity.BotFlow, error) {
+	flows := make([]*entity.BotFlow, 0)
+
+	rows, err := r.db.Query(q, deviceId)
+	if err != nil {
+		return flows, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		flow, scanErr := r.ScanBotFlow(rows)
+		if scanErr == nil {
+			flows = append(flows, flow)
+		}
+	}
+
+	return flows, nil
+}
+
+func (r *Repo) GetBotFlows(deviceId string) ([]*entity.BotFlow, error) {
+	return r.getBotFlows(getBotFlowsQuery, deviceId)
+}
+
+func (r *Repo) GetActiveBotFlows(deviceId string) ([]*entity.BotFlow, error) {
+	return r.getBotFlows(getActiveBotFlowsQuery, deviceId)
+}
+
+func (r *Repo) GetBotFlow(flowId int64, deviceId string) (*entity.BotFlow, error) {
+	return r.ScanBotFlow(r.db.QueryRow(getBotFlowByIdQuery, flowId, deviceId))
+}
+
+func (r *Repo) SaveBotFlow(flow *entity.BotFlow) error {
+	if flow.Id != 0 {
+		return r.db.QueryRow(
+			updateBotFlowQuery,
+			flow.Name,
+			flow.Definition,
+			flow.Active,
+			flow.Id,
+			flow.DeviceId,
+		).Scan(&flow.CreatedAt, &flow.UpdatedAt)
+	}
+
+	return r.db.QueryRow(
+		insertBotFlowQuery,
+		flow.DeviceId,
+		flow.Name,
+		flow.Definition,
+		flow.Active,
+	).Scan(&flow.Id, &flow.CreatedAt, &flow.UpdatedAt)
+}
+
+func (r *Repo) DeleteBotFlow(flowId int64, deviceId string) error {
+	_, err := r.db.Exec(deleteBotFlowQuery, flowId, deviceId)
+
+	return err
+}
+
+func (r *Repo) ScanBotSession(row dbutil.Scannable) (*entity.BotSession, error) {
+	var (
+		session   entity.BotSession
+		variables []uint8
+		expiresAt sql.NullTime
+	)
+
+	err := row.Scan(
+		&session.DeviceId,
+		&session.TheirJID,
+		&session.FlowId,
+		&session.State,
+		&variables,
+		&session.StartedAt,
+		&session.UpdatedAt,
+		&expiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	session.Variables = make(map[string]string)
+	_ = json.Unmarshal(variables, &session.Variables)
+	if expiresAt.Valid {
+		session.ExpiresAt = &expiresAt.Time
+	}
+
+	return &session, nil
+}
+
+// GetBotSession returns the session of the chat, or nil when there is none.
+func (r *Repo) GetBotSession(deviceId string, theirJID types.JID) (*entity.BotSession, error) {
+	session, err := r.ScanBotSession(r.db.QueryRow(getBotSessionQuery, deviceId, theirJID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return session, err
+}
+
+func (r *Repo) GetActiveBotSessions(deviceId string, limit int, offset int) ([]*entity.BotSession, int, error) {
+	sessions := make([]*entity.BotSession, 0)
+	total := 0
+
+	err := r.db.QueryRow(getBotSessionCountQuery, deviceId).Scan(&total)
+	if err != nil || total == 0 {
+		return sessions, total, err
+	}
+
+	rows, err := r.db.Query(getBotSessionsQuery, deviceId, limit, offset)
+	if err != nil {
+		return sessions, total, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		session, scanErr := r.ScanBotSession(rows)
+		if scanErr == nil {
+			sessions = append(sessions, session)
+		}
+	}
+
+	return sessions, total, nil
+}
+
+func (r *Repo) SaveBotSession(session *entity.BotSession) error {
+	session.UpdatedAt = time.Now()
+	if session.StartedAt.IsZero() {
+		session.StartedAt = session.UpdatedAt
+	}
+
+	variables, err := json.Marshal(session.Variables)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.Exec(
+		saveBotSessionQuery,
+		session.DeviceId,
+		session.TheirJID,
+		session.FlowId,
+		session.State,
+		variables,
+		session.StartedAt,
+		session.UpdatedAt,
+		session.ExpiresAt,
+	)
+
+	return err
+}
+
+func (r *Repo) DeleteBotSession(deviceId string, theirJID types.JID) error {
+	_, err := r.db.Exec(deleteBotSessionQuery, deviceId, theirJID)
+
+	return err
+}
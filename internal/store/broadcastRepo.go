@@ -3,7 +3,9 @@ package store
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -15,19 +17,28 @@ import (
 )
 
 const (
-	broadcastTable          = "user_broadcasts"
-	broadcastRecipientTable = "user_broadcast_recipients"
+	broadcastTable              = "user_broadcasts"
+	broadcastRecipientTable     = "user_broadcast_recipients"
+	broadcastStatusHistoryTable = "user_broadcast_status_history"
 )
 
 const (
-	getBroadcastsQuery     = `SELECT * FROM ` + broadcastTable + ` WHERE user_id=$1 AND jid=$2 ORDER BY id DESC LIMIT $3 OFFSET $4`
-	getCountBroadcastQuery = "SELECT COUNT(*) FROM " + broadcastTable + " WHERE user_id=$1 AND jid=$2"
-	getBroadcastByIdQuery  = "SELECT * FROM " + broadcastTable + " WHERE id=$1"
-	insertBroadcastQuery   = `INSERT INTO ` + broadcastTable + ` (
-			user_id, jid, message, media, contact_type, contact_filter, filter_value, phones, campaign_name, sent_started_at
-		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10
-		) RETURNING id`
+	broadcastColumns = `id, user_id, message, media, contact_type, contact_filter, filter_value, phones, jid,
+		completed, created_at, completed_at, updated_at, campaign_name, sent_started_at, status, recipients_snapshot_at`
+
+	getBroadcastsQuery      = `SELECT ` + broadcastColumns + ` FROM ` + broadcastTable + ` WHERE user_id=$1 AND jid=$2 ORDER BY id DESC LIMIT $3 OFFSET $4`
+	getCountBroadcastQuery  = "SELECT COUNT(*) FROM " + broadcastTable + " WHERE user_id=$1 AND jid=$2"
+	getBroadcastByIdQuery   = "SELECT " + broadcastColumns + " FROM " + broadcastTable + " WHERE id=$1"
+	getBroadcastStatusQuery = "SELECT status FROM " + broadcastTable + " WHERE id=$1"
+	insertBroadcastQuery    = `WITH b AS (
+			INSERT INTO ` + broadcastTable + ` (
+				user_id, jid, message, media, contact_type, contact_filter, filter_value, phones, campaign_name, sent_started_at, status
+			) VALUES (
+				$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
+			) RETURNING id, status
+		), h AS (
+			INSERT INTO ` + broadcastStatusHistoryTable + ` (broadcast_id, to_status, user_id) SELECT id, status, $1 FROM b
+		) SELECT id FROM b`
 	updateBroadcastQuery = `UPDATE ` + broadcastTable + ` SET
 			message=$1,
 			media=$2,
@@ -38,18 +49,102 @@ const (
 			campaign_name=$7,
 			updated_at=$8,
 			sent_started_at=$9
-		WHERE id=$10`
-	updateCompletedBroadcastQuery   = `UPDATE ` + broadcastTable + ` SET completed=$1, completed_at=$2, updated_at=$3 WHERE id=$4`
+		WHERE id=$10 AND status IN ('draft', 'scheduled', 'paused')`
+	updateBroadcastMessageQuery = `UPDATE ` + broadcastTable + ` SET message=$1, media=$2, updated_at=now()
+		WHERE id=$3 AND status IN ('draft', 'scheduled', 'paused')`
+	// The status only changes from the one it was read with, completed is
+	// kept for the rows of older versions
+	updateBroadcastStatusQuery = `WITH b AS (
+			UPDATE ` + broadcastTable + ` SET
+				status=$1,
+				completed=$1 IN ('completed', 'cancelled', 'failed'),
+				completed_at=CASE WHEN $1 IN ('completed', 'cancelled', 'failed') THEN now() ELSE NULL END,
+				updated_at=now()
+			WHERE id=$2 AND status=$3 RETURNING id
+		) INSERT INTO ` + broadcastStatusHistoryTable + ` (broadcast_id, from_status, to_status, user_id) SELECT id, $3, $1, $4 FROM b`
+	broadcastStatusChangeColumns    = "id, broadcast_id, from_status, to_status, user_id, created_at"
+	getBroadcastStatusHistoryQuery  = "SELECT " + broadcastStatusChangeColumns + " FROM " + broadcastStatusHistoryTable + " WHERE broadcast_id=$1 ORDER BY created_at ASC, id ASC"
 	deleteBroadcastQuery            = `DELETE FROM ` + broadcastTable + ` WHERE id=$1`
-	getBroadcastRecipientsQuery     = "SELECT * FROM " + broadcastRecipientTable + " WHERE broadcast_id=$1 ORDER BY id DESC LIMIT $2 OFFSET $3"
+	broadcastRecipientColumns       = "id, broadcast_id, phone, name, sent_status, sent_at, message_id, replied_at, reply_message_id, delivered_at, read_at, error, attempts, next_attempt_at, fields, message"
+	getBroadcastRecipientsQuery     = "SELECT " + broadcastRecipientColumns + " FROM " + broadcastRecipientTable + " WHERE broadcast_id=$1 ORDER BY id DESC LIMIT $2 OFFSET $3"
 	getBroadcastRecipientCountQuery = "SELECT COUNT(*) FROM " + broadcastRecipientTable + " WHERE broadcast_id=$1"
 	insertBrodcastRecipientQuery    = `INSERT INTO ` + broadcastRecipientTable + ` (
-			broadcast_id, name, phone
-		) VALUES ($1, $2, $3) RETURNING id`
-	updateSentStatusQuery = `UPDATE ` + broadcastRecipientTable + ` SET sent_status=$1, sent_at=$2, message_id=$3
+			broadcast_id, name, phone, sent_status
+		) VALUES ($1, LEFT($2, 64), $3, 'queued') ON CONFLICT (broadcast_id, phone) DO NOTHING`
+	// The audience of the contact types, $1 is the broadcast and $2 the user
+	// or the device, the filter is appended
+	snapshotUserContactsQuery = `INSERT INTO ` + broadcastRecipientTable + ` (broadcast_id, name, phone, sent_status, fields)
+		SELECT $1, LEFT(COALESCE(NULLIF(verified_name, ''), name, ''), 64), phone, 'queued', fields FROM ` + userContactTableName + `
+		WHERE in_wa=1 AND user_id=$2`
+	snapshotWhatsAppContactsQuery = `INSERT INTO ` + broadcastRecipientTable + ` (broadcast_id, name, phone, sent_status)
+		SELECT $1, LEFT(COALESCE(full_name, ''), 64), their_jid, 'queued' FROM whatsmeow_contacts
+		WHERE our_jid=$2`
+	snapshotConflictClause       = ` ON CONFLICT (broadcast_id, phone) DO NOTHING`
+	updateBroadcastSnapshotQuery = `UPDATE ` + broadcastTable + ` SET recipients_snapshot_at=now() WHERE id=$1 AND recipients_snapshot_at IS NULL RETURNING recipients_snapshot_at`
+	getNextQueuedRecipientQuery  = "SELECT " + broadcastRecipientColumns + " FROM " + broadcastRecipientTable + " WHERE broadcast_id=$1 AND sent_status='queued' AND (next_attempt_at IS NULL OR next_attempt_at <= now()) ORDER BY id ASC LIMIT 1"
+	getQueuedRecipientCountQuery = "SELECT COUNT(*) FROM " + broadcastRecipientTable + " WHERE broadcast_id=$1 AND sent_status='queued'"
+	updateSentStatusQuery        = `UPDATE ` + broadcastRecipientTable + ` SET sent_status=$1, sent_at=$2, message_id=$3, jid=$5,
+		error=NULL, attempts=attempts+1, next_attempt_at=NULL
 	  WHERE id=$4`
-	updateRecieptQuery       = `UPDATE ` + broadcastRecipientTable + ` SET sent_status=$1 WHERE message_id IN`
-	getRandomBroadcastToSent = `SELECT * FROM ` + broadcastTable + ` WHERE sent_started_at <= now() AND completed=false ORDER BY RANDOM() LIMIT 1`
+	updateFailedStatusQuery = `UPDATE ` + broadcastRecipientTable + ` SET sent_status='failed',
+		error=$2, attempts=attempts+1, next_attempt_at=NULL
+	  WHERE id=$1`
+	updateRecipientMessageQuery = `UPDATE ` + broadcastRecipientTable + ` SET message=$2 WHERE id=$1`
+	updateRetryStatusQuery      = `UPDATE ` + broadcastRecipientTable + ` SET sent_status='queued',
+		error=$2, attempts=attempts+1, next_attempt_at=$3
+	  WHERE id=$1`
+	requeueFailedRecipientsQuery = `UPDATE ` + broadcastRecipientTable + ` SET sent_status='queued',
+		error=NULL, attempts=0, next_attempt_at=NULL, message=NULL
+	  WHERE broadcast_id=$1 AND sent_status='failed'`
+	// A read message stays read when its delivery receipt comes late
+	updateRecieptQuery = `UPDATE ` + broadcastRecipientTable + ` SET
+			sent_status=CASE WHEN sent_status='read' THEN sent_status ELSE $1 END,
+			delivered_at=COALESCE(delivered_at, $2),
+			read_at=CASE WHEN $1='read' THEN COALESCE(read_at, $2) ELSE read_at END
+		WHERE message_id IN`
+	// Times of the messages sent by a device since a time, oldest first
+	getRecentBroadcastSendsQuery = `SELECT r.sent_at FROM ` + broadcastRecipientTable + ` r
+		JOIN ` + broadcastTable + ` b ON b.id=r.broadcast_id
+		WHERE b.jid=$1 AND r.sent_at >= $2 ORDER BY r.sent_at ASC`
+	// The broadcast scheduled first is sent first
+	getBroadcastToSendQuery = `SELECT ` + broadcastColumns + ` FROM ` + broadcastTable + `
+		WHERE jid=$1 AND (status='running' OR (status='scheduled' AND sent_started_at <= now()))
+		ORDER BY sent_started_at ASC NULLS LAST, id ASC LIMIT 1`
+	broadcastStatsColumns = `COUNT(*),
+			COUNT(*) FILTER (WHERE sent_status IS NULL OR sent_status='queued'),
+			COUNT(sent_at),
+			COUNT(delivered_at),
+			COUNT(read_at),
+			COUNT(*) FILTER (WHERE sent_status='failed'),
+			COUNT(replied_at),
+			AVG(EXTRACT(EPOCH FROM read_at - sent_at))`
+	getBroadcastStatsQuery     = "SELECT " + broadcastStatsColumns + " FROM " + broadcastRecipientTable + " WHERE broadcast_id=$1"
+	getBroadcastsStatsQuery    = "SELECT broadcast_id, " + broadcastStatsColumns + " FROM " + broadcastRecipientTable + " WHERE broadcast_id = ANY($1) GROUP BY broadcast_id"
+	getBroadcastSendHoursQuery = `SELECT EXTRACT(HOUR FROM sent_at AT TIME ZONE $2)::int AS hour, COUNT(*) FROM ` + broadcastRecipientTable + `
+		WHERE broadcast_id=$1 AND sent_at IS NOT NULL GROUP BY hour`
+	getBroadcastTimelineQuery = `SELECT date_trunc('hour', t, $2) AS bucket,
+			COUNT(*) FILTER (WHERE kind='sent'),
+			COUNT(*) FILTER (WHERE kind='delivered'),
+			COUNT(*) FILTER (WHERE kind='read'),
+			COUNT(*) FILTER (WHERE kind='replied')
+		FROM (
+			SELECT sent_at AS t, 'sent' AS kind FROM ` + broadcastRecipientTable + ` WHERE broadcast_id=$1 AND sent_at IS NOT NULL
+			UNION ALL SELECT delivered_at, 'delivered' FROM ` + broadcastRecipientTable + ` WHERE broadcast_id=$1 AND delivered_at IS NOT NULL
+			UNION ALL SELECT read_at, 'read' FROM ` + broadcastRecipientTable + ` WHERE broadcast_id=$1 AND read_at IS NOT NULL
+			UNION ALL SELECT replied_at, 'replied' FROM ` + broadcastRecipientTable + ` WHERE broadcast_id=$1 AND replied_at IS NOT NULL
+		) events GROUP BY bucket ORDER BY bucket ASC`
+	eachBroadcastRecipientQuery = "SELECT " + broadcastRecipientColumns + " FROM " + broadcastRecipientTable + " WHERE broadcast_id=$1 ORDER BY id ASC"
+
+	// The reply goes to the latest broadcast sent to the chat from the
+	// device, only its first reply is kept.
+	saveBroadcastReplyQuery = `UPDATE ` + broadcastRecipientTable + ` SET replied_at=$4, reply_message_id=$5
+		WHERE replied_at IS NULL AND id=(
+			SELECT r.id FROM ` + broadcastRecipientTable + ` r
+			JOIN ` + broadcastTable + ` b ON b.id=r.broadcast_id
+			JOIN ` + userDeviceTableName + ` d ON d.jid=b.jid
+			WHERE d.id=$1 AND r.jid IN ($2, $3) AND r.sent_at >= $6 AND r.sent_at <= $4
+			ORDER BY r.sent_at DESC LIMIT 1
+		) RETURNING ` + broadcastRecipientColumns
 )
 
 func convertJsonbToString(v []uint8) (d []string) {
@@ -58,14 +153,19 @@ func convertJsonbToString(v []uint8) (d []string) {
 	return d
 }
 
+// ErrBroadcastStatusChanged is returned when the status of a broadcast is not
+// the one it was read with anymore.
+var ErrBroadcastStatusChanged = errors.New("broadcast status has been changed meanwhile, reload it")
+
 func (r *Repo) ScanBroadcast(row dbutil.Scannable) (*entity.Broadcast, error) {
 	var (
 		id                                               int64
 		broadcastUserId                                  int
 		message, contactType, contactFilter, filterValue sql.NullString
 		createdAt, completedAt, updatedAt, sentStartedAt sql.NullTime
+		snapshotAt                                       sql.NullTime
 		completed                                        bool
-		campaignName                                     string
+		campaignName, status                             string
 		phones                                           []uint8
 		media                                            entity.UploadedFile
 		broadcastJid                                     types.JID
@@ -87,6 +187,8 @@ func (r *Repo) ScanBroadcast(row dbutil.Scannable) (*entity.Broadcast, error) {
 		&updatedAt,
 		&campaignName,
 		&sentStartedAt,
+		&status,
+		&snapshotAt,
 	)
 
 	if err != nil {
@@ -107,6 +209,7 @@ func (r *Repo) ScanBroadcast(row dbutil.Scannable) (*entity.Broadcast, error) {
 		CreatedAt:     createdAt.Time,
 		CampaignName:  campaignName,
 		SentStartedAt: &sentStartedAt.Time,
+		Status:        status,
 	}
 
 	if completedAt.Valid {
@@ -115,13 +218,8 @@ func (r *Repo) ScanBroadcast(row dbutil.Scannable) (*entity.Broadcast, error) {
 	if updatedAt.Valid {
 		broadcast.UpdatedAt = &updatedAt.Time
 	}
-
-	broadcast.Status = "pending"
-	if broadcast.Completed && !completedAt.Valid {
-		broadcast.Status = "pause"
-	}
-	if broadcast.Completed && completedAt.Valid {
-		broadcast.Status = "complete"
+	if snapshotAt.Valid {
+		broadcast.SnapshotAt = &snapshotAt.Time
 	}
 
 	return &broadcast, nil
@@ -152,8 +250,49 @@ func (r *Repo) GetBroadcasts(userId int, jid types.JID, limit int, offset int) (
 			broadcasts = append(broadcasts, broadcast)
 		}
 	}
+	rows.Close()
+
+	return broadcasts, totalBroadcast, r.setBroadcastsStats(broadcasts)
+}
+
+// setBroadcastsStats sets the stats of the broadcasts in one query, a
+// broadcast without recipients has empty stats.
+func (r *Repo) setBroadcastsStats(broadcasts []*entity.Broadcast) error {
+	ids := make([]int64, 0, len(broadcasts))
+	byId := make(map[int64]*entity.Broadcast, len(broadcasts))
+	for _, broadcast := range broadcasts {
+		broadcast.Stats = &entity.BroadcastStats{}
+		ids = append(ids, broadcast.Id)
+		byId[broadcast.Id] = broadcast
+	}
+
+	rows, err := r.db.Query(getBroadcastsStatsQuery, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var broadcastId int64
+
+		stats, err := scanBroadcastStats(rows, &broadcastId)
+		if err != nil {
+			return err
+		}
+		if broadcast := byId[broadcastId]; broadcast != nil {
+			broadcast.Stats = stats
+		}
+	}
 
-	return broadcasts, totalBroadcast, nil
+	return rows.Err()
+}
+
+// GetBroadcastStatus returns the current status of the broadcast.
+func (r *Repo) GetBroadcastStatus(broadcastId int64) (string, error) {
+	var status string
+	err := r.db.QueryRow(getBroadcastStatusQuery, broadcastId).Scan(&status)
+
+	return status, err
 }
 
 func (r *Repo) GetBroadcast(broadcastId int64) (*entity.Broadcast, error) {
@@ -166,15 +305,16 @@ func (r *Repo) GetBroadcast(broadcastId int64) (*entity.Broadcast, error) {
 	device, _ := r.GetDeviceByJid(broadcast.Jid)
 
 	broadcast.Device = device
+	broadcast.Stats, err = r.GetBroadcastStats(broadcast.Id)
 
 	return broadcast, err
 }
 
+// SaveBroadcast inserts a new broadcast in its status or updates the content
+// of a broadcast, only a draft, scheduled or paused one can be changed.
 func (r *Repo) SaveBroadcast(broadcast *entity.Broadcast) error {
-	var err error
-
 	if broadcast.Id != 0 {
-		_, err = r.db.Exec(
+		res, err := r.db.Exec(
 			updateBroadcastQuery,
 			broadcast.Message,
 			broadcast.Media,
@@ -187,42 +327,153 @@ func (r *Repo) SaveBroadcast(broadcast *entity.Broadcast) error {
 			broadcast.SentStartedAt,
 			broadcast.Id,
 		)
-	} else {
-		err = r.db.QueryRow(
-			insertBroadcastQuery,
-			broadcast.UserId,
-			broadcast.Jid,
-			broadcast.Message,
-			broadcast.Media,
-			broadcast.ContactType,
-			broadcast.ContactFilter,
-			broadcast.FilterValue,
-			broadcast.Phones,
-			broadcast.CampaignName,
-			broadcast.SentStartedAt,
-		).Scan(&broadcast.Id)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return errors.New("broadcast can only be edited as draft, scheduled or paused")
+		}
+
+		return nil
 	}
 
-	return err
+	return r.db.QueryRow(
+		insertBroadcastQuery,
+		broadcast.UserId,
+		broadcast.Jid,
+		broadcast.Message,
+		broadcast.Media,
+		broadcast.ContactType,
+		broadcast.ContactFilter,
+		broadcast.FilterValue,
+		broadcast.Phones,
+		broadcast.CampaignName,
+		broadcast.SentStartedAt,
+		broadcast.Status,
+	).Scan(&broadcast.Id)
 }
 
-func (r *Repo) UpdateRunningStatusBroadcast(broadcastId int64, status string) error {
-	var completed bool
-	if status == "pause" {
-		completed = true
-	} else {
-		completed = false
+// UpdateBroadcastStatus moves the broadcast from the status it has to
+// another one and records the change, userId is 0 for the changes made by
+// the sender. It reports false when the status is no longer from.
+func (r *Repo) UpdateBroadcastStatus(broadcastId int64, from string, to string, userId int) (bool, error) {
+	return updateBroadcastStatus(r.db.Exec, broadcastId, from, to, userId)
+}
+
+func updateBroadcastStatus(exec func(string, ...any) (sql.Result, error), broadcastId int64, from string, to string, userId int) (bool, error) {
+	var changedBy *int
+	if userId != 0 {
+		changedBy = &userId
 	}
-	log.Printf("Status: %s, Completed: %v, BroadcastId: %d", status, completed, broadcastId)
-	_, err := r.db.Exec("UPDATE "+broadcastTable+" SET completed=$1 WHERE id=$2", completed, broadcastId)
 
-	return err
+	res, err := exec(updateBroadcastStatusQuery, to, broadcastId, from, changedBy)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+
+	return n > 0, err
 }
 
-func (r *Repo) UpdateCompletedBroadcast(broadcastId int64, completed bool, completedAt time.Time) error {
-	_, err := r.db.Exec(updateCompletedBroadcastQuery, completed, completedAt, time.Now(), broadcastId)
+// GetBroadcastStatusHistory returns the status changes of a broadcast, the
+// oldest first.
+func (r *Repo) GetBroadcastStatusHistory(broadcastId int64) ([]entity.BroadcastStatusChange, error) {
+	history := make([]entity.BroadcastStatusChange, 0)
 
-	return err
+	rows, err := r.db.Query(getBroadcastStatusHistoryQuery, broadcastId)
+	if err != nil {
+		return history, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			change     entity.BroadcastStatusChange
+			fromStatus sql.NullString
+			userId     sql.NullInt64
+		)
+		if err = rows.Scan(&change.Id, &change.BroadcastId, &fromStatus, &change.ToStatus, &userId, &change.CreatedAt); err != nil {
+			return history, err
+		}
+		if fromStatus.Valid {
+			change.FromStatus = &fromStatus.String
+		}
+		if userId.Valid {
+			id := int(userId.Int64)
+			change.UserId = &id
+		}
+		history = append(history, change)
+	}
+
+	return history, rows.Err()
+}
+
+func (r *Repo) ScanBroadcastRecipient(row dbutil.Scannable) (*entity.BroadcastRecipient, error) {
+	var (
+		recipient                              entity.BroadcastRecipient
+		sentStatus, messageId, replyMessageId  sql.NullString
+		sentAt, repliedAt, deliveredAt, readAt sql.NullTime
+		sendError, message                     sql.NullString
+		nextAttemptAt                          sql.NullTime
+		fields                                 []byte
+	)
+
+	err := row.Scan(
+		&recipient.Id,
+		&recipient.BroadcastId,
+		&recipient.Phone,
+		&recipient.Name,
+		&sentStatus,
+		&sentAt,
+		&messageId,
+		&repliedAt,
+		&replyMessageId,
+		&deliveredAt,
+		&readAt,
+		&sendError,
+		&recipient.Attempts,
+		&nextAttemptAt,
+		&fields,
+		&message,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	recipient.SentStatus = sentStatus.String
+	if sentAt.Valid {
+		recipient.SentAt = &sentAt.Time
+	}
+	if messageId.Valid {
+		recipient.MessageId = &messageId.String
+	}
+	if repliedAt.Valid {
+		recipient.Replied = true
+		recipient.RepliedAt = &repliedAt.Time
+	}
+	if replyMessageId.Valid {
+		recipient.ReplyMessageId = &replyMessageId.String
+	}
+	if deliveredAt.Valid {
+		recipient.DeliveredAt = &deliveredAt.Time
+	}
+	if readAt.Valid {
+		recipient.ReadAt = &readAt.Time
+	}
+	if sendError.Valid {
+		recipient.Error = &sendError.String
+	}
+	if nextAttemptAt.Valid {
+		recipient.NextAttemptAt = &nextAttemptAt.Time
+	}
+	if len(fields) > 0 {
+		_ = json.Unmarshal(fields, &recipient.Fields)
+	}
+	if message.Valid {
+		recipient.Message = &message.String
+	}
+
+	return &recipient, nil
 }
 
 func (r *Repo) GetBroadcastRecipients(broadcastId int64, limit int, offset int) ([]entity.BroadcastRecipient, int, error) {
@@ -241,273 +492,414 @@ func (r *Repo) GetBroadcastRecipients(broadcastId int64, limit int, offset int)
 	defer rows.Close()
 
 	for rows.Next() {
-		var (
-			id                    int
-			rBroadcastId          int64
-			phone, name           string
-			sentStatus, messageId sql.NullString
-			sentAt                sql.NullTime
-		)
-
-		if err := rows.Scan(
-			&id,
-			&rBroadcastId,
-			&phone,
-			&name,
-			&sentStatus,
-			&sentAt,
-			&messageId,
-		); err == nil {
-			recipient := entity.BroadcastRecipient{
-				Id:          id,
-				BroadcastId: rBroadcastId,
-				Phone:       phone,
-				Name:        name,
-				SentStatus:  sentStatus.String,
-			}
-			if sentAt.Valid {
-				recipient.SentAt = &sentAt.Time
-			}
-			if messageId.Valid {
-				recipient.MessageId = &messageId.String
-			}
-
-			recipients = append(recipients, recipient)
+		recipient, scanErr := r.ScanBroadcastRecipient(rows)
+		if scanErr == nil {
+			recipients = append(recipients, *recipient)
 		} else {
-			log.Printf("Rows Error: %v", err)
+			log.Printf("Rows Error: %v", scanErr)
 		}
 	}
 
 	return recipients, total, err
 }
 
-func (r *Repo) InsertBroadcastRecipient(br *entity.BroadcastRecipient) error {
-	err := r.db.QueryRow(
-		insertBrodcastRecipientQuery,
-		br.BroadcastId,
-		br.Name,
-		br.Phone,
-	).Scan(&br.Id)
-
-	return err
+// GetBroadcastStats counts the recipients of a broadcast by how far its
+// message got.
+func (r *Repo) GetBroadcastStats(broadcastId int64) (*entity.BroadcastStats, error) {
+	return scanBroadcastStats(r.db.QueryRow(getBroadcastStatsQuery, broadcastId))
 }
 
-func (r *Repo) UpdateSentStatus(brId int, sentStatus string, messageId types.MessageID, sentAt time.Time) error {
-	_, err := r.db.Exec(
-		updateSentStatusQuery,
-		sentStatus,
-		sentAt,
-		messageId,
-		brId,
+// scanBroadcastStats scans the broadcastStatsColumns after the columns of
+// dest.
+func scanBroadcastStats(row dbutil.Scannable, dest ...any) (*entity.BroadcastStats, error) {
+	var (
+		stats         entity.BroadcastStats
+		avgTimeToRead sql.NullFloat64
 	)
 
-	return err
+	err := row.Scan(append(dest,
+		&stats.Recipients,
+		&stats.Pending,
+		&stats.Sent,
+		&stats.Delivered,
+		&stats.Read,
+		&stats.Failed,
+		&stats.Replied,
+		&avgTimeToRead,
+	)...)
+	if err != nil {
+		return nil, err
+	}
+
+	stats.DeliveryRate = percentage(stats.Delivered, stats.Sent)
+	stats.ReadRate = percentage(stats.Read, stats.Sent)
+	stats.ReplyRate = percentage(stats.Replied, stats.Sent)
+	stats.FailureRate = percentage(stats.Failed, stats.Sent+stats.Failed)
+	if avgTimeToRead.Valid {
+		stats.AvgTimeToRead = &avgTimeToRead.Float64
+	}
+
+	return &stats, nil
 }
 
-func (r *Repo) UpdateBroadcastMessageReceipt(messageId []string, receipt string) error {
-	args := make([]interface{}, len(messageId)+1)
-	args[0] = receipt
-	ins := make([]string, 0)
-	for i, mId := range messageId {
-		args[i+1] = mId
-		ins = append(ins, "$"+strconv.Itoa(i+2))
+func percentage(n int, total int) float64 {
+	if total == 0 {
+		return 0
 	}
 
-	q := updateRecieptQuery + ` (` + strings.Join(ins, ",") + `)`
-	log.Printf("MessageID: %v, Receipt: %s >> Query: %s", messageId, receipt, q)
+	return math.Round(float64(n)*10000/float64(total)) / 100
+}
 
-	affRows, err := r.db.Exec(
-		q,
-		args...,
-	)
+// GetBroadcastSendHours counts the messages of a broadcast sent in each hour
+// of the day, in the time zone loc.
+func (r *Repo) GetBroadcastSendHours(broadcastId int64, loc *time.Location) ([24]int, error) {
+	var hours [24]int
 
-	log.Printf("Update Receipt: Rows Affected: %v Error: %v", affRows, err)
+	rows, err := r.db.Query(getBroadcastSendHoursQuery, broadcastId, loc.String())
+	if err != nil {
+		return hours, err
+	}
+	defer rows.Close()
 
-	return err
+	for rows.Next() {
+		var hour, count int
+		if err = rows.Scan(&hour, &count); err != nil {
+			return hours, err
+		}
+		if hour >= 0 && hour < 24 {
+			hours[hour] = count
+		}
+	}
+
+	return hours, rows.Err()
 }
 
-func (r *Repo) GetBroadcastToSend() (*entity.BroadcastToSend, error) {
-	var (
-		totalContact    int
-		device          *entity.Device
-		broadcastToSend entity.BroadcastToSend
-	)
+// GetBroadcastTimeline counts what happened to the messages of a broadcast
+// hour by hour, the hours start in the time zone loc.
+func (r *Repo) GetBroadcastTimeline(broadcastId int64, loc *time.Location) ([]entity.BroadcastTimelineBucket, error) {
+	timeline := make([]entity.BroadcastTimelineBucket, 0)
 
-	broadcast, err := r.ScanBroadcast(r.db.QueryRow(getRandomBroadcastToSent))
+	rows, err := r.db.Query(getBroadcastTimelineQuery, broadcastId, loc.String())
 	if err != nil {
-		return nil, err
+		return timeline, err
 	}
+	defer rows.Close()
 
-	device, _ = r.GetDeviceByJid(broadcast.Jid)
+	for rows.Next() {
+		var bucket entity.BroadcastTimelineBucket
+		if err = rows.Scan(&bucket.Time, &bucket.Sent, &bucket.Delivered, &bucket.Read, &bucket.Replied); err != nil {
+			return timeline, err
+		}
+		bucket.Time = bucket.Time.In(loc)
+		timeline = append(timeline, bucket)
+	}
 
-	broadcast.Device = device
+	return timeline, rows.Err()
+}
 
-	broadcastToSend.Broadcast = *broadcast
+// EachBroadcastRecipient calls fn with the recipients of a broadcast in the
+// order they were added, it stops at the first error of fn.
+func (r *Repo) EachBroadcastRecipient(broadcastId int64, fn func(*entity.BroadcastRecipient) error) error {
+	rows, err := r.db.Query(eachBroadcastRecipientQuery, broadcastId)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
 
-	switch broadcast.ContactType {
-	case "c":
-		filterValue := broadcast.FilterValue
-		filterField := contactFilterField(broadcast.ContactFilter)
-		if filterField == "group" {
-			cg := strings.Split(filterValue, ":")
-			filterValue = cg[0]
-			filterField = "id IN (SELECT contact_id FROM " + userContactGroupContactTableName + " WHERE group_id=$2)"
-		} else {
-			filterValue = broadcast.FilterValue + "%"
-			if filterField == "" {
-				filterField = "1=$2"
-				filterValue = "1"
-			} else {
-				filterField = filterField + " ILIKE $2"
-			}
-		}
-		countContactQuery := `SELECT count(*) FROM ` + userContactTableName + `
-		WHERE in_wa=1 AND user_id=$1 AND ` + filterField + ` AND NOT EXISTS (
-			SELECT 1 FROM "user_broadcast_recipients" WHERE broadcast_id=$3 AND phone=user_contacts.phone
-		)`
-		err = r.db.QueryRow(
-			countContactQuery,
-			broadcast.UserId,
-			filterValue,
-			broadcast.Id,
-		).Scan(&totalContact)
+	for rows.Next() {
+		recipient, err := r.ScanBroadcastRecipient(rows)
 		if err != nil {
-			return &broadcastToSend, err
+			return err
 		}
-
-		broadcastToSend.TotalRecipient = totalContact
-		if totalContact == 0 {
-			return &broadcastToSend, nil
+		if err = fn(recipient); err != nil {
+			return err
 		}
+	}
 
-		contactQuery := `SELECT name,phone,verified_name FROM ` + userContactTableName + `
-		WHERE in_wa=1 AND user_id=$1 AND ` + filterField + ` AND NOT EXISTS (
-			SELECT 1 FROM "user_broadcast_recipients" WHERE broadcast_id=$3 AND phone=user_contacts.phone
-		) ORDER BY RANDOM() LIMIT 1`
-		//log.Printf("BroadcastId: %d, field: %s, value: %s, Query: %s", broadcastToSend.Broadcast.Id, filterField, filterValue, contactQuery)
-		var contactName, contactPhone, contactVerifiedName sql.NullString
-
-		err = r.db.QueryRow(
-			contactQuery,
-			broadcastToSend.Broadcast.UserId,
-			filterValue,
-			broadcastToSend.Broadcast.Id,
-		).Scan(&contactName, &contactPhone, &contactVerifiedName)
-		if err != nil {
-			if err == sql.ErrNoRows {
-				return &broadcastToSend, nil
-			}
-			return &broadcastToSend, err
-		}
+	return rows.Err()
+}
 
-		broadcastToSend.Recipient = &entity.BroadcastRecipient{
-			BroadcastId: broadcastToSend.Broadcast.Id,
-			Phone:       contactPhone.String,
-		}
-		if contactVerifiedName.Valid {
-			broadcastToSend.Recipient.Name = contactVerifiedName.String
-		} else {
-			broadcastToSend.Recipient.Name = contactName.String
+// SaveBroadcastReply links a message received in a chat to the recipient of
+// the last broadcast the device sent to the chat since the given time. chat
+// and chatAlt are the phone number and LID of the chat, the recipient is
+// returned when the message is its first reply.
+func (r *Repo) SaveBroadcastReply(deviceId string, chat, chatAlt types.JID, messageId types.MessageID, at time.Time, since time.Time) (*entity.BroadcastRecipient, error) {
+	recipient, err := r.ScanBroadcastRecipient(r.db.QueryRow(
+		saveBroadcastReplyQuery,
+		deviceId,
+		chat,
+		chatAlt,
+		at,
+		messageId,
+		since,
+	))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+
+	return recipient, err
+}
+
+// broadcastContactsFilter returns the condition on the user contacts a
+// broadcast is sent to, its arguments are numbered from n.
+func broadcastContactsFilter(broadcast *entity.Broadcast, n int) (string, []interface{}) {
+	filterField := contactFilterField(broadcast.ContactFilter)
+	if filterField == "group" {
+		cgId, _ := strconv.Atoi(strings.Split(broadcast.FilterValue, ":")[0])
+		return " AND id IN (SELECT contact_id FROM " + userContactGroupContactTableName + " WHERE group_id=$" + strconv.Itoa(n) + ")", []interface{}{cgId}
+	}
+	if filterField != "" && broadcast.FilterValue != "" {
+		return " AND " + filterField + " ILIKE $" + strconv.Itoa(n), []interface{}{broadcast.FilterValue + "%"}
+	}
+
+	return "", nil
+}
+
+// GetBroadcastContactFieldKeys returns the keys of the custom fields every
+// user contact the broadcast is sent to has.
+func (r *Repo) GetBroadcastContactFieldKeys(broadcast *entity.Broadcast) ([]string, error) {
+	keys := make([]string, 0)
+
+	filter, filterArgs := broadcastContactsFilter(broadcast, 2)
+	contacts := " FROM " + userContactTableName + " WHERE in_wa=1 AND user_id=$1" + filter
+	rows, err := r.db.Query(
+		"SELECT k FROM (SELECT jsonb_object_keys(fields) AS k"+contacts+") f GROUP BY k HAVING COUNT(*) = (SELECT COUNT(*)"+contacts+")",
+		append([]interface{}{broadcast.UserId}, filterArgs...)...,
+	)
+	if err != nil {
+		return keys, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		if err = rows.Scan(&key); err != nil {
+			return keys, err
 		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// SnapshotBroadcastRecipients queues every recipient of the broadcast, the
+// audience is taken once: it reports false when it had been taken before.
+func (r *Repo) SnapshotBroadcastRecipients(broadcast *entity.Broadcast) (bool, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var snapshotAt time.Time
+	err = tx.QueryRow(updateBroadcastSnapshotQuery, broadcast.Id).Scan(&snapshotAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	switch broadcast.ContactType {
+	case "c":
+		filter, filterArgs := broadcastContactsFilter(broadcast, 3)
+		args := append([]interface{}{broadcast.Id, broadcast.UserId}, filterArgs...)
+		_, err = tx.Exec(snapshotUserContactsQuery+filter+snapshotConflictClause, args...)
 	case "w":
-		device := &entity.Device{
-			Jid:    &broadcastToSend.Broadcast.Jid,
-			UserId: broadcastToSend.Broadcast.UserId,
+		query, args := snapshotWhatsAppContactsQuery, []interface{}{broadcast.Id, broadcast.Jid}
+		if filterField, ok := whatsAppContactFilterFields[broadcast.ContactFilter]; ok && broadcast.FilterValue != "" {
+			query += " AND " + filterField + " ILIKE $3"
+			args = append(args, whatsAppContactFilterValue(broadcast.ContactFilter, broadcast.FilterValue)+"%")
 		}
-
-		device, err = r.GetDeviceByIdAndUserId(device)
+		_, err = tx.Exec(query+snapshotConflictClause, args...)
+	case "p":
+		var stmt *sql.Stmt
+		stmt, err = tx.Prepare(insertBrodcastRecipientQuery)
 		if err != nil {
-			return &broadcastToSend, err
+			return false, err
 		}
+		defer stmt.Close()
 
-		countContactQuery := `SELECT COUNT(*) FROM whatsmeow_contacts WHERE our_jid=$1 AND NOT EXISTS (
-			SELECT 1 FROM "user_broadcast_recipients" WHERE broadcast_id=$2 AND phone=whatsmeow_contacts.their_jid
-		)`
-		err = r.db.QueryRow(
-			countContactQuery,
-			device.Jid,
-			broadcastToSend.Broadcast.Id,
-		).Scan(&totalContact)
-		if err != nil {
-			return &broadcastToSend, err
-		}
-		broadcastToSend.TotalRecipient = totalContact
-		if totalContact == 0 {
-			return &broadcastToSend, nil
+		for _, phone := range broadcast.Phones {
+			if _, err = stmt.Exec(broadcast.Id, "", phone); err != nil {
+				break
+			}
 		}
+	}
+	if err != nil {
+		return false, err
+	}
 
-		contactQuery := `SELECT full_name, their_jid FROM whatsmeow_contacts WHERE our_jid=$1 AND NOT EXISTS (
-			SELECT 1 FROM "user_broadcast_recipients" WHERE broadcast_id=$2 AND phone=whatsmeow_contacts.their_jid
-		)`
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	broadcast.SnapshotAt = &snapshotAt
 
-		var name, phone sql.NullString
+	return true, nil
+}
 
-		err = r.db.QueryRow(
-			contactQuery,
-			device.Jid,
-			broadcastToSend.Broadcast.Id,
-		).Scan(&name, &phone)
-		if err != nil {
-			if err == sql.ErrNoRows {
-				return &broadcastToSend, nil
-			}
-			return &broadcastToSend, err
-		}
+func (r *Repo) UpdateSentStatus(brId int, sentStatus string, messageId types.MessageID, sentAt time.Time, chat types.JID) error {
+	_, err := r.db.Exec(
+		updateSentStatusQuery,
+		sentStatus,
+		sentAt,
+		messageId,
+		brId,
+		chat,
+	)
+
+	return err
+}
 
-		broadcastToSend.Recipient = &entity.BroadcastRecipient{
-			BroadcastId: broadcastToSend.Broadcast.Id,
-			Phone:       phone.String,
-			Name:        name.String,
+// UpdateSentFailed marks the recipient whose message could not be sent and
+// keeps why.
+func (r *Repo) UpdateSentFailed(brId int, reason string) error {
+	_, err := r.db.Exec(updateFailedStatusQuery, brId, reason)
+
+	return err
+}
+
+// UpdateRecipientMessage keeps the message rendered for the recipient.
+func (r *Repo) UpdateRecipientMessage(brId int, message string) error {
+	_, err := r.db.Exec(updateRecipientMessageQuery, brId, message)
+
+	return err
+}
+
+// UpdateSentRetry keeps the recipient whose message could not be sent queued,
+// it is tried again at the given time.
+func (r *Repo) UpdateSentRetry(brId int, reason string, at time.Time) error {
+	_, err := r.db.Exec(updateRetryStatusQuery, brId, reason, at)
+
+	return err
+}
+
+// RequeueFailedBroadcastRecipients queues the failed recipients of the
+// broadcast again and returns how many there were. In the same transaction
+// the broadcast moves to status when it is not in it, and its message and
+// media are saved when saveMessage is set.
+func (r *Repo) RequeueFailedBroadcastRecipients(broadcast *entity.Broadcast, status string, saveMessage bool, userId int) (int64, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if status != broadcast.Status {
+		changed, err := updateBroadcastStatus(tx.Exec, broadcast.Id, broadcast.Status, status, userId)
+		if err != nil {
+			return 0, err
 		}
-	case "p":
-		phones := make([]string, 0)
-		for _, p := range broadcastToSend.Broadcast.Phones {
-			phones = append(phones, `('', '`+p+`')`)
+		if !changed {
+			return 0, ErrBroadcastStatusChanged
 		}
-		values := strings.Join(phones, ",")
-
-		countContactQuery := `WITH phones (name,phone) AS (VALUES ` + values + `)
-		SELECT COUNT(*) FROM phones WHERE NOT EXISTS (
-			SELECT 1 FROM "user_broadcast_recipients" WHERE broadcast_id=$1 AND phone=phones.phone
-		)`
-		err = r.db.QueryRow(
-			countContactQuery,
-			broadcastToSend.Broadcast.Id,
-		).Scan(&totalContact)
+	}
+
+	if saveMessage {
+		res, err := tx.Exec(updateBroadcastMessageQuery, broadcast.Message, broadcast.Media, broadcast.Id)
 		if err != nil {
-			return &broadcastToSend, err
+			return 0, err
 		}
-		broadcastToSend.TotalRecipient = totalContact
-		if totalContact == 0 {
-			return &broadcastToSend, nil
+		if n, _ := res.RowsAffected(); n == 0 {
+			return 0, errors.New("broadcast can only be edited as draft, scheduled or paused")
 		}
+	}
 
-		contactQuery := `WITH phones (name,phone) AS (VALUES ` + values + `)
-		SELECT name,phone FROM phones WHERE NOT EXISTS (
-			SELECT 1 FROM "user_broadcast_recipients" WHERE broadcast_id=$1 AND phone=phones.phone
-		)`
+	res, err := tx.Exec(requeueFailedRecipientsQuery, broadcast.Id)
+	if err != nil {
+		return 0, err
+	}
+	requeued, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
 
-		var name, phone sql.NullString
+	return requeued, tx.Commit()
+}
 
-		err = r.db.QueryRow(
-			contactQuery,
-			broadcastToSend.Broadcast.Id,
-		).Scan(&name, &phone)
-		if err != nil {
-			if err == sql.ErrNoRows {
-				return &broadcastToSend, nil
-			}
-			return &broadcastToSend, err
-		}
+// UpdateBroadcastMessageReceipt sets the status of the recipients of the
+// messages to the receipt, delivered or read, received at the given time.
+func (r *Repo) UpdateBroadcastMessageReceipt(messageId []string, receipt string, at time.Time) error {
+	args := make([]interface{}, len(messageId)+2)
+	args[0] = receipt
+	args[1] = at
+	ins := make([]string, 0)
+	for i, mId := range messageId {
+		args[i+2] = mId
+		ins = append(ins, "$"+strconv.Itoa(i+3))
+	}
+
+	q := updateRecieptQuery + ` (` + strings.Join(ins, ",") + `)`
+	log.Printf("MessageID: %v, Receipt: %s >> Query: %s", messageId, receipt, q)
+
+	affRows, err := r.db.Exec(
+		q,
+		args...,
+	)
+
+	log.Printf("Update Receipt: Rows Affected: %v Error: %v", affRows, err)
+
+	return err
+}
 
-		broadcastToSend.Recipient = &entity.BroadcastRecipient{
-			BroadcastId: broadcastToSend.Broadcast.Id,
-			Phone:       phone.String,
-			Name:        name.String,
+// GetRecentBroadcastSends returns the times of the broadcast messages the
+// device has sent since the given time, oldest first.
+func (r *Repo) GetRecentBroadcastSends(jid types.JID, since time.Time) ([]time.Time, error) {
+	rows, err := r.db.Query(getRecentBroadcastSendsQuery, jid, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sent := make([]time.Time, 0)
+	for rows.Next() {
+		var t time.Time
+		if err = rows.Scan(&t); err != nil {
+			return nil, err
 		}
+		sent = append(sent, t)
+	}
+
+	return sent, rows.Err()
+}
+
+// GetBroadcastToSend picks a broadcast of the device that is due and the
+// next recipient queued for it, the recipient is nil when none is left.
+func (r *Repo) GetBroadcastToSend(jid types.JID) (*entity.BroadcastToSend, error) {
+	var broadcastToSend entity.BroadcastToSend
+
+	broadcast, err := r.ScanBroadcast(r.db.QueryRow(getBroadcastToSendQuery, jid))
+	if err != nil {
+		return nil, err
+	}
+
+	broadcast.Device, _ = r.GetDeviceByJid(broadcast.Jid)
+	broadcastToSend.Broadcast = *broadcast
+
+	// A broadcast is only sent once its recipients are listed
+	if broadcast.SnapshotAt == nil {
+		return &broadcastToSend, nil
 	}
 
-	return &broadcastToSend, nil
+	return &broadcastToSend, r.GetNextBroadcastRecipient(&broadcastToSend)
+}
+
+// GetNextBroadcastRecipient sets the next queued recipient of the broadcast
+// and how many are queued, the recipient is nil when none is left.
+func (r *Repo) GetNextBroadcastRecipient(broadcastToSend *entity.BroadcastToSend) error {
+	broadcastToSend.Recipient = nil
+
+	err := r.db.QueryRow(getQueuedRecipientCountQuery, broadcastToSend.Broadcast.Id).Scan(&broadcastToSend.TotalRecipient)
+	if err != nil || broadcastToSend.TotalRecipient == 0 {
+		return err
+	}
+
+	broadcastToSend.Recipient, err = r.ScanBroadcastRecipient(r.db.QueryRow(getNextQueuedRecipientQuery, broadcastToSend.Broadcast.Id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+
+	return err
 }
 
 func (r *Repo) DeleteBroadcast(broadcastId int, jid *types.JID) error {
@@ -0,0 +1,232 @@
+package store
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+	"go.mau.fi/whatsmeow/types"
+
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+const (
+	userChatTable        = "user_chats"
+	handoffSettingsTable = "user_handoff_settings"
+)
+
+// Used for devices without handoff settings
+const (
+	DefaultHandoffKeyword     = "agent"
+	DefaultHandoffIdleTimeout = 30
+)
+
+const (
+	userChatColumns = "device_id, their_jid, mode, mode_changed_at, last_activity_at, assignee, status, priority, labels, resolved_at, last_read_at"
+
+	getUserChatQuery   = "SELECT " + userChatColumns + " FROM " + userChatTable + " WHERE device_id=$1 AND their_jid=$2"
+	touchUserChatQuery = `INSERT INTO ` + userChatTable + ` (device_id, their_jid) VALUES ($1, $2)
+		ON CONFLICT (device_id, their_jid) DO UPDATE SET last_activity_at=now()`
+	setUserChatModeQuery = `INSERT INTO ` + userChatTable + ` (device_id, their_jid, mode) VALUES ($1, $2, $3)
+		ON CONFLICT (device_id, their_jid) DO UPDATE SET mode=EXCLUDED.mode, mode_changed_at=now(), last_activity_at=now()
+		RETURNING ` + userChatColumns
+	releaseIdleUserChatsQuery = `WITH idle AS (
+			SELECT c.device_id, c.their_jid FROM ` + userChatTable + ` c
+			LEFT JOIN ` + handoffSettingsTable + ` s ON s.device_id=c.device_id
+			WHERE c.mode='human' AND COALESCE(s.idle_timeout, $1) > 0
+				AND c.last_activity_at < now() - make_interval(mins => COALESCE(s.idle_timeout, $1))
+			FOR UPDATE OF c SKIP LOCKED
+		)
+		UPDATE ` + userChatTable + ` c SET mode='bot', mode_changed_at=now() FROM idle
+		WHERE c.device_id=idle.device_id AND c.their_jid=idle.their_jid
+		RETURNING c.device_id, c.their_jid, c.mode, c.mode_changed_at, c.last_activity_at,
+			c.assignee, c.status, c.priority, c.labels, c.resolved_at, c.last_read_at`
+	assignUserChatQuery = `INSERT INTO ` + userChatTable + ` (device_id, their_jid, assignee) VALUES ($1, $2, $3)
+		ON CONFLICT (device_id, their_jid) DO UPDATE SET assignee=EXCLUDED.assignee
+		RETURNING ` + userChatColumns
+	setUserChatStatusQuery = `INSERT INTO ` + userChatTable + ` (device_id, their_jid, status, resolved_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (device_id, their_jid) DO UPDATE SET status=EXCLUDED.status, resolved_at=EXCLUDED.resolved_at
+		RETURNING ` + userChatColumns
+	updateUserChatDetailsQuery = `INSERT INTO ` + userChatTable + ` (device_id, their_jid, priority, labels) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (device_id, their_jid) DO UPDATE SET priority=EXCLUDED.priority, labels=EXCLUDED.labels
+		RETURNING ` + userChatColumns
+	markUserChatReadQuery = `INSERT INTO ` + userChatTable + ` (device_id, their_jid, last_read_at) VALUES ($1, $2, $3)
+		ON CONFLICT (device_id, their_jid) DO UPDATE SET last_read_at=GREATEST(` + userChatTable + `.last_read_at, EXCLUDED.last_read_at)`
+	reopenResolvedUserChatQuery = `UPDATE ` + userChatTable + ` SET status='open', resolved_at=NULL
+		WHERE device_id=$1 AND their_jid=$2 AND status='resolved' RETURNING ` + userChatColumns
+
+	getHandoffSettingsQuery  = "SELECT device_id, keyword, idle_timeout, handoff_message FROM " + handoffSettingsTable + " WHERE device_id=$1"
+	saveHandoffSettingsQuery = `INSERT INTO ` + handoffSettingsTable + ` (device_id, keyword, idle_timeout, handoff_message) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (device_id) DO UPDATE SET keyword=EXCLUDED.keyword, idle_timeout=EXCLUDED.idle_timeout, handoff_message=EXCLUDED.handoff_message`
+)
+
+func (r *Repo) ScanUserChat(row dbutil.Scannable) (*entity.UserChat, error) {
+	var (
+		chat       entity.UserChat
+		labels     []uint8
+		resolvedAt sql.NullTime
+		lastReadAt sql.NullTime
+	)
+
+	err := row.Scan(
+		&chat.DeviceId,
+		&chat.TheirJID,
+		&chat.Mode,
+		&chat.ModeChangedAt,
+		&chat.LastActivityAt,
+		&chat.Assignee,
+		&chat.Status,
+		&chat.Priority,
+		&labels,
+		&resolvedAt,
+		&lastReadAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	chat.Labels = make([]string, 0)
+	_ = json.Unmarshal(labels, &chat.Labels)
+	if resolvedAt.Valid {
+		chat.ResolvedAt = &resolvedAt.Time
+	}
+	if lastReadAt.Valid {
+		chat.LastReadAt = &lastReadAt.Time
+	}
+
+	return &chat, nil
+}
+
+// GetUserChat returns the chat record, a chat without record is an open,
+// unassigned chat handled by the bot.
+func (r *Repo) GetUserChat(deviceId string, theirJID types.JID) (*entity.UserChat, error) {
+	chat, err := r.ScanUserChat(r.db.QueryRow(getUserChatQuery, deviceId, theirJID))
+	if err == sql.ErrNoRows {
+		return &entity.UserChat{
+			DeviceId: deviceId,
+			TheirJID: theirJID,
+			Mode:     "bot",
+			Status:   "open",
+			Priority: "normal",
+			Labels:   make([]string, 0),
+		}, nil
+	}
+
+	return chat, err
+}
+
+// TouchUserChat records activity in the chat, creating its record when
+// needed.
+func (r *Repo) TouchUserChat(deviceId string, theirJID types.JID) error {
+	_, err := r.db.Exec(touchUserChatQuery, deviceId, theirJID)
+
+	return err
+}
+
+func (r *Repo) SetUserChatMode(deviceId string, theirJID types.JID, mode string) (*entity.UserChat, error) {
+	return r.ScanUserChat(r.db.QueryRow(setUserChatModeQuery, deviceId, theirJID, mode))
+}
+
+// ReleaseIdleUserChats moves the chats handled by agents without activity
+// for the idle timeout of their device back to the bot, and returns them.
+func (r *Repo) ReleaseIdleUserChats() ([]*entity.UserChat, error) {
+	chats := make([]*entity.UserChat, 0)
+
+	rows, err := r.db.Query(releaseIdleUserChatsQuery, DefaultHandoffIdleTimeout)
+	if err != nil {
+		return chats, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		chat, scanErr := r.ScanUserChat(rows)
+		if scanErr == nil {
+			chats = append(chats, chat)
+		}
+	}
+
+	return chats, rows.Err()
+}
+
+func (r *Repo) AssignUserChat(deviceId string, theirJID types.JID, assignee string) (*entity.UserChat, error) {
+	return r.ScanUserChat(r.db.QueryRow(assignUserChatQuery, deviceId, theirJID, assignee))
+}
+
+func (r *Repo) SetUserChatStatus(deviceId string, theirJID types.JID, status string) (*entity.UserChat, error) {
+	var resolvedAt *time.Time
+
+	if status == "resolved" {
+		now := time.Now()
+		resolvedAt = &now
+	}
+
+	return r.ScanUserChat(r.db.QueryRow(setUserChatStatusQuery, deviceId, theirJID, status, resolvedAt))
+}
+
+func (r *Repo) UpdateUserChatDetails(deviceId string, theirJID types.JID, priority string, labels []string) (*entity.UserChat, error) {
+	if labels == nil {
+		labels = make([]string, 0)
+	}
+
+	l, err := json.Marshal(labels)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.ScanUserChat(r.db.QueryRow(updateUserChatDetailsQuery, deviceId, theirJID, priority, l))
+}
+
+// MarkUserChatRead records the chat has been read up to readAt, the messages
+// received after it are unread.
+func (r *Repo) MarkUserChatRead(deviceId string, theirJID types.JID, readAt time.Time) error {
+	_, err := r.db.Exec(markUserChatReadQuery, deviceId, theirJID, readAt)
+
+	return err
+}
+
+// ReopenResolvedUserChat opens the chat again when it was resolved, it
+// returns nil when the chat was not resolved.
+func (r *Repo) ReopenResolvedUserChat(deviceId string, theirJID types.JID) (*entity.UserChat, error) {
+	chat, err := r.ScanUserChat(r.db.QueryRow(reopenResolvedUserChatQuery, deviceId, theirJID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return chat, err
+}
+
+func (r *Repo) GetHandoffSettings(deviceId string) (*entity.HandoffSettings, error) {
+	var settings entity.HandoffSettings
+
+	err := r.db.QueryRow(getHandoffSettingsQuery, deviceId).Scan(
+		&settings.DeviceId,
+		&settings.Keyword,
+		&settings.IdleTimeout,
+		&settings.HandoffMessage,
+	)
+	if err == sql.ErrNoRows {
+		return &entity.HandoffSettings{
+			DeviceId:    deviceId,
+			Keyword:     DefaultHandoffKeyword,
+			IdleTimeout: DefaultHandoffIdleTimeout,
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &settings, nil
+}
+
+func (r *Repo) SaveHandoffSettings(settings *entity.HandoffSettings) error {
+	_, err := r.db.Exec(
+		saveHandoffSettingsQuery,
+		settings.DeviceId,
+		settings.Keyword,
+		settings.IdleTimeout,
+		settings.HandoffMessage,
+	)
+
+	return err
+}
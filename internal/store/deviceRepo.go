@@ -1,19 +1,72 @@
 package store
 
 import (
+	"database/sql"
 	"regexp"
-	"slices"
+	"time"
 
 	"github.com/perigiweb/go-wa-api/internal/store/entity"
+	"go.mau.fi/util/dbutil"
 	meowTypes "go.mau.fi/whatsmeow/types"
 )
 
-const userDeviceTableName = "user_devices"
+const (
+	userDeviceTableName     = "user_devices"
+	historySyncTableName    = "user_device_history_syncs"
+	senderSettingsTableName = "user_device_sender_settings"
+)
+
+// Used for devices added without history sync settings
+const (
+	DefaultHistorySyncDays   = 1
+	DefaultHistorySyncSizeMb = 10
+)
+
+// Used for devices without broadcast sender settings
+const (
+	DefaultSenderPerMinute = 2
+	DefaultSenderPerHour   = 30
+	DefaultSenderJitterMin = 5
+	DefaultSenderJitterMax = 30
+	DefaultSenderTypingMin = 5
+	DefaultSenderTypingMax = 10
+)
+
+const (
+	historySyncColumns = "device_id, days, size_mb, full_sync, status, progress, conversations, messages, updated_at"
+
+	getHistorySyncQuery  = "SELECT " + historySyncColumns + " FROM " + historySyncTableName + " WHERE device_id=$1"
+	saveHistorySyncQuery = `INSERT INTO ` + historySyncTableName + ` (device_id, days, size_mb, full_sync) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (device_id) DO UPDATE SET days=EXCLUDED.days, size_mb=EXCLUDED.size_mb, full_sync=EXCLUDED.full_sync`
+	addHistorySyncProgressQuery = `INSERT INTO ` + historySyncTableName + ` AS h (device_id, days, size_mb, status, progress, conversations, messages)
+		VALUES ($1, $2, $3, CASE WHEN $4 >= 100 THEN 'completed' ELSE 'running' END, $4, $5, $6)
+		ON CONFLICT (device_id) DO UPDATE SET
+			progress=GREATEST(h.progress, EXCLUDED.progress),
+			status=CASE WHEN GREATEST(h.progress, EXCLUDED.progress) >= 100 THEN 'completed' ELSE 'running' END,
+			conversations=h.conversations + EXCLUDED.conversations,
+			messages=h.messages + EXCLUDED.messages,
+			updated_at=now()
+		RETURNING ` + historySyncColumns
+
+	senderSettingsColumns   = "device_id, per_minute, per_hour, jitter_min, jitter_max, typing_min, typing_max, updated_at"
+	getSenderSettingsQuery  = "SELECT " + senderSettingsColumns + " FROM " + senderSettingsTableName + " WHERE device_id=$1"
+	saveSenderSettingsQuery = `INSERT INTO ` + senderSettingsTableName + ` (device_id, per_minute, per_hour, jitter_min, jitter_max, typing_min, typing_max)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (device_id) DO UPDATE SET
+			per_minute=EXCLUDED.per_minute,
+			per_hour=EXCLUDED.per_hour,
+			jitter_min=EXCLUDED.jitter_min,
+			jitter_max=EXCLUDED.jitter_max,
+			typing_min=EXCLUDED.typing_min,
+			typing_max=EXCLUDED.typing_max,
+			updated_at=now()
+		RETURNING updated_at`
+)
 
 func (r *Repo) GetConnectedDevices() ([]entity.Device, error) {
 	devices := make([]entity.Device, 0)
 
-	rows, err := r.db.Query("SELECT id,user_id,name,jid,connected FROM " + userDeviceTableName + " WHERE jid IS NOT NULL ORDER BY name ASC")
+	rows, err := r.db.Query("SELECT id,user_id,name,jid,connected,timezone FROM " + userDeviceTableName + " WHERE jid IS NOT NULL ORDER BY name ASC")
 	if err != nil {
 		return devices, err
 	}
@@ -21,7 +74,7 @@ func (r *Repo) GetConnectedDevices() ([]entity.Device, error) {
 
 	for rows.Next() {
 		var device entity.Device
-		if err := rows.Scan(&device.Id, &device.UserId, &device.Name, &device.Jid, &device.Connected); err == nil {
+		if err := rows.Scan(&device.Id, &device.UserId, &device.Name, &device.Jid, &device.Connected, &device.Timezone); err == nil {
 			devices = append(devices, device)
 		}
 	}
@@ -32,7 +85,7 @@ func (r *Repo) GetConnectedDevices() ([]entity.Device, error) {
 func (r *Repo) GetDevicesByUserId(userId int) ([]entity.Device, error) {
 	devices := make([]entity.Device, 0)
 
-	rows, err := r.db.Query("SELECT id,user_id,name,jid,connected FROM "+userDeviceTableName+" WHERE user_id=$1 ORDER BY name ASC", userId)
+	rows, err := r.db.Query("SELECT id,user_id,name,jid,connected,timezone FROM "+userDeviceTableName+" WHERE user_id=$1 ORDER BY name ASC", userId)
 	if err != nil {
 		return devices, err
 	}
@@ -40,7 +93,7 @@ func (r *Repo) GetDevicesByUserId(userId int) ([]entity.Device, error) {
 
 	for rows.Next() {
 		var device entity.Device
-		if err := rows.Scan(&device.Id, &device.UserId, &device.Name, &device.Jid, &device.Connected); err == nil {
+		if err := rows.Scan(&device.Id, &device.UserId, &device.Name, &device.Jid, &device.Connected, &device.Timezone); err == nil {
 			devices = append(devices, device)
 		}
 	}
@@ -50,7 +103,7 @@ func (r *Repo) GetDevicesByUserId(userId int) ([]entity.Device, error) {
 
 func (r *Repo) GetDeviceByIdAndUserId(device *entity.Device) (*entity.Device, error) {
 	var (
-		q = "SELECT id,user_id,name,jid,connected FROM " + userDeviceTableName + " WHERE id=$1 AND user_id=$2"
+		q = "SELECT id,user_id,name,jid,connected,timezone FROM " + userDeviceTableName + " WHERE id=$1 AND user_id=$2"
 	)
 	err := r.db.QueryRow(q, device.Id, device.UserId).Scan(
 		&device.Id,
@@ -58,6 +111,7 @@ func (r *Repo) GetDeviceByIdAndUserId(device *entity.Device) (*entity.Device, er
 		&device.Name,
 		&device.Jid,
 		&device.Connected,
+		&device.Timezone,
 	)
 
 	return device, err
@@ -68,18 +122,19 @@ func (r *Repo) GetDeviceByJid(jid meowTypes.JID) (*entity.Device, error) {
 
 	//jid.User
 
-	err := r.db.QueryRow("SELECT id,user_id,name,jid,connected FROM "+userDeviceTableName+" WHERE jid LIKE $1", jid.User+"%").Scan(
+	err := r.db.QueryRow("SELECT id,user_id,name,jid,connected,timezone FROM "+userDeviceTableName+" WHERE jid LIKE $1", jid.User+"%").Scan(
 		&device.Id,
 		&device.UserId,
 		&device.Name,
 		&device.Jid,
 		&device.Connected,
+		&device.Timezone,
 	)
 
 	return &device, err
 }
 
-func (r *Repo) InsertNewDevice(userId int, deviceName string) (entity.Device, error) {
+func (r *Repo) InsertNewDevice(userId int, deviceName string, timezone string) (entity.Device, error) {
 	var (
 		err    error
 		device entity.Device
@@ -87,9 +142,10 @@ func (r *Repo) InsertNewDevice(userId int, deviceName string) (entity.Device, er
 
 	device.UserId = userId
 	device.Name = deviceName
+	device.Timezone = timezone
 
-	const query = "INSERT INTO " + userDeviceTableName + " (user_id, name) VALUES ($1, $2) RETURNING id"
-	err = r.db.QueryRow(query, device.UserId, device.Name).Scan(&device.Id)
+	const query = "INSERT INTO " + userDeviceTableName + " (user_id, name, timezone) VALUES ($1, $2, $3) RETURNING id"
+	err = r.db.QueryRow(query, device.UserId, device.Name, device.Timezone).Scan(&device.Id)
 
 	if err != nil {
 		return device, err
@@ -106,6 +162,14 @@ func (r *Repo) UpdateJID(jid meowTypes.JID, deviceId string) error {
 	return err
 }
 
+func (r *Repo) UpdateTimezone(timezone string, deviceId string) error {
+	var q = "UPDATE " + userDeviceTableName + " SET timezone=$1 WHERE id=$2"
+
+	_, err := r.db.Exec(q, timezone, deviceId)
+
+	return err
+}
+
 func (r *Repo) UpdateConnected(connected bool, deviceId string) error {
 	var q = "UPDATE " + userDeviceTableName + " SET connected=$1 WHERE id=$2"
 
@@ -126,21 +190,133 @@ func (r *Repo) DeleteDeviceById(deviceId string, userId int) error {
 	return err
 }
 
+// Fields of whatsmeow_contacts the WhatsApp contacts are filtered by
+var whatsAppContactFilterFields = map[string]string{"p": "their_jid", "n": "full_name"}
+
+// whatsAppContactFilterValue turns a local phone prefix into an international
+// one, the JIDs of the contacts start with the country code.
+func whatsAppContactFilterValue(f string, v string) string {
+	if f == "p" {
+		return regexp.MustCompile("^0(.*)$").ReplaceAllString(v, "62$1")
+	}
+
+	return v
+}
+
 func (r *Repo) CountWhatsAppContact(device *entity.Device, f string, v string) (int, error) {
 	var err error
 	totalContact := 0
-	fKeys := []string{"p", "n"}
-	fFields := map[string]string{"p": "their_jid", "n": "full_name"}
 
-	if slices.Contains(fKeys, f) && v != "" {
-		if f == "p" {
-			r := regexp.MustCompile("^0(.*)$")
-			v = r.ReplaceAllString(v, "62$1")
-		}
-		err = r.db.QueryRow("SELECT COUNT(*) FROM whatsmeow_contacts WHERE our_jid=$1 AND "+fFields[f]+" ILIKE $2", device.Jid, v+"%").Scan(&totalContact)
+	if field, ok := whatsAppContactFilterFields[f]; ok && v != "" {
+		err = r.db.QueryRow("SELECT COUNT(*) FROM whatsmeow_contacts WHERE our_jid=$1 AND "+field+" ILIKE $2", device.Jid, whatsAppContactFilterValue(f, v)+"%").Scan(&totalContact)
 	} else {
 		err = r.db.QueryRow("SELECT COUNT(*) FROM whatsmeow_contacts WHERE our_jid=$1", device.Jid).Scan(&totalContact)
 	}
 
 	return totalContact, err
 }
+
+func (r *Repo) ScanHistorySync(row dbutil.Scannable) (*entity.HistorySync, error) {
+	var hs entity.HistorySync
+
+	err := row.Scan(
+		&hs.DeviceId,
+		&hs.Days,
+		&hs.SizeMb,
+		&hs.FullSync,
+		&hs.Status,
+		&hs.Progress,
+		&hs.Conversations,
+		&hs.Messages,
+		&hs.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &hs, nil
+}
+
+// GetHistorySync returns the history sync of the device, a device without
+// record syncs the default history.
+func (r *Repo) GetHistorySync(deviceId string) (*entity.HistorySync, error) {
+	hs, err := r.ScanHistorySync(r.db.QueryRow(getHistorySyncQuery, deviceId))
+	if err == sql.ErrNoRows {
+		return &entity.HistorySync{
+			DeviceId:  deviceId,
+			Days:      DefaultHistorySyncDays,
+			SizeMb:    DefaultHistorySyncSizeMb,
+			Status:    "pending",
+			UpdatedAt: time.Now(),
+		}, nil
+	}
+
+	return hs, err
+}
+
+func (r *Repo) SaveHistorySync(hs *entity.HistorySync) error {
+	_, err := r.db.Exec(saveHistorySyncQuery, hs.DeviceId, hs.Days, hs.SizeMb, hs.FullSync)
+
+	return err
+}
+
+// AddHistorySyncProgress adds the conversations and messages imported from a
+// history chunk, progress is the percentage of the history sent so far.
+func (r *Repo) AddHistorySyncProgress(deviceId string, progress int, conversations int, messages int) (*entity.HistorySync, error) {
+	return r.ScanHistorySync(r.db.QueryRow(
+		addHistorySyncProgressQuery,
+		deviceId,
+		DefaultHistorySyncDays,
+		DefaultHistorySyncSizeMb,
+		progress,
+		conversations,
+		messages,
+	))
+}
+
+// GetSenderSettings returns the broadcast sender settings of the device, a
+// device without record gets the defaults.
+func (r *Repo) GetSenderSettings(deviceId string) (*entity.SenderSettings, error) {
+	var settings entity.SenderSettings
+
+	err := r.db.QueryRow(getSenderSettingsQuery, deviceId).Scan(
+		&settings.DeviceId,
+		&settings.PerMinute,
+		&settings.PerHour,
+		&settings.JitterMin,
+		&settings.JitterMax,
+		&settings.TypingMin,
+		&settings.TypingMax,
+		&settings.UpdatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return &entity.SenderSettings{
+			DeviceId:  deviceId,
+			PerMinute: DefaultSenderPerMinute,
+			PerHour:   DefaultSenderPerHour,
+			JitterMin: DefaultSenderJitterMin,
+			JitterMax: DefaultSenderJitterMax,
+			TypingMin: DefaultSenderTypingMin,
+			TypingMax: DefaultSenderTypingMax,
+			UpdatedAt: time.Now(),
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &settings, nil
+}
+
+func (r *Repo) SaveSenderSettings(settings *entity.SenderSettings) error {
+	return r.db.QueryRow(
+		saveSenderSettingsQuery,
+		settings.DeviceId,
+		settings.PerMinute,
+		settings.PerHour,
+		settings.JitterMin,
+		settings.JitterMax,
+		settings.TypingMin,
+		settings.TypingMax,
+	).Scan(&settings.UpdatedAt)
+}
@@ -3,6 +3,7 @@ package entity
 import (
 	"encoding/json"
 	"errors"
+	"strings"
 	"time"
 
 	"database/sql/driver"
@@ -57,6 +58,146 @@ func (wm *WAMessage) Scan(v any) error {
 	return json.Unmarshal(b, &wm)
 }
 
+// Text returns the text a user sees for the message: the body of a text
+// message or the caption of a media message.
+func (wm *WAMessage) Text() string {
+	if wm == nil || wm.Message == nil {
+		return ""
+	}
+
+	m := wm.Message
+	switch {
+	case m.GetConversation() != "":
+		return m.GetConversation()
+	case m.GetExtendedTextMessage() != nil:
+		return m.GetExtendedTextMessage().GetText()
+	case m.GetImageMessage() != nil:
+		return m.GetImageMessage().GetCaption()
+	case m.GetVideoMessage() != nil:
+		return m.GetVideoMessage().GetCaption()
+	case m.GetDocumentMessage() != nil:
+		return m.GetDocumentMessage().GetCaption()
+	case m.GetButtonsResponseMessage() != nil:
+		return m.GetButtonsResponseMessage().GetSelectedDisplayText()
+	case m.GetListResponseMessage() != nil:
+		return m.GetListResponseMessage().GetTitle()
+	}
+
+	return ""
+}
+
+// Kind returns what the message holds, see UserMessage.
+func (wm *WAMessage) Kind() string {
+	if wm == nil || wm.Message == nil {
+		return "unknown"
+	}
+
+	m := wm.Message
+	switch {
+	case m.GetConversation() != "", m.GetExtendedTextMessage() != nil:
+		return "text"
+	case m.GetImageMessage() != nil:
+		return "image"
+	case m.GetVideoMessage() != nil:
+		return "video"
+	case m.GetAudioMessage() != nil:
+		return "audio"
+	case m.GetDocumentMessage() != nil:
+		return "document"
+	case m.GetStickerMessage() != nil:
+		return "sticker"
+	case m.GetLocationMessage() != nil, m.GetLiveLocationMessage() != nil:
+		return "location"
+	case m.GetContactMessage() != nil, m.GetContactsArrayMessage() != nil:
+		return "contact"
+	case m.GetReactionMessage() != nil:
+		return "reaction"
+	case m.GetPollCreationMessage() != nil:
+		return "poll"
+	case m.GetButtonsResponseMessage() != nil:
+		return "buttons_response"
+	case m.GetListResponseMessage() != nil:
+		return "list_response"
+	}
+
+	return "unknown"
+}
+
+// QuotedID returns the ID of the message this one replies to.
+func (wm *WAMessage) QuotedID() string {
+	if wm == nil || wm.Message == nil {
+		return ""
+	}
+
+	m := wm.Message
+	for _, ctx := range []*waE2E.ContextInfo{
+		m.GetExtendedTextMessage().GetContextInfo(),
+		m.GetImageMessage().GetContextInfo(),
+		m.GetVideoMessage().GetContextInfo(),
+		m.GetAudioMessage().GetContextInfo(),
+		m.GetDocumentMessage().GetContextInfo(),
+		m.GetStickerMessage().GetContextInfo(),
+		m.GetLocationMessage().GetContextInfo(),
+		m.GetContactMessage().GetContextInfo(),
+	} {
+		if id := ctx.GetStanzaID(); id != "" {
+			return id
+		}
+	}
+
+	return ""
+}
+
+// SearchText returns the text the message is found by: its text or caption
+// and the file name of a document.
+func (wm *WAMessage) SearchText() string {
+	text := wm.Text()
+	if wm == nil || wm.Message == nil {
+		return text
+	}
+
+	if fileName := wm.Message.GetDocumentMessage().GetFileName(); fileName != "" {
+		text = strings.TrimSpace(text + " " + fileName)
+	}
+
+	return text
+}
+
+// Preview returns a one line summary of the message for chat lists, the text
+// of the message or the kind of media it holds.
+func (wm *WAMessage) Preview() string {
+	if text := wm.Text(); text != "" {
+		return strings.Join(strings.Fields(text), " ")
+	}
+	if wm == nil || wm.Message == nil {
+		return ""
+	}
+
+	m := wm.Message
+	switch {
+	case m.GetImageMessage() != nil:
+		return "[image]"
+	case m.GetVideoMessage() != nil:
+		return "[video]"
+	case m.GetAudioMessage() != nil:
+		return "[audio]"
+	case m.GetDocumentMessage() != nil:
+		return "[document] " + m.GetDocumentMessage().GetFileName()
+	case m.GetStickerMessage() != nil:
+		return "[sticker]"
+	case m.GetLocationMessage() != nil, m.GetLiveLocationMessage() != nil:
+		return "[location]"
+	case m.GetContactMessage() != nil, m.GetContactsArrayMessage() != nil:
+		return "[contact]"
+	case m.GetReactionMessage() != nil:
+		return "[reaction] " + m.GetReactionMessage().GetText()
+	case m.GetPollCreationMessage() != nil:
+		return "[poll] " + m.GetPollCreationMessage().GetName()
+	}
+
+	return ""
+}
+
 type User struct {
 	Id          int       `json:"id"`
 	Email       string    `json:"email"`
@@ -84,6 +225,8 @@ type UserContact struct {
 	InWA         int      `json:"inWA"`
 	VerifiedName string   `json:"verifiedName"`
 	Groups       []string `json:"groups"`
+	// Custom fields, {{fields.key}} in a broadcast message
+	Fields map[string]string `json:"fields"`
 }
 
 type UserContactGroup struct {
@@ -98,8 +241,116 @@ type Device struct {
 	Name      string     `json:"name"`
 	Jid       *types.JID `json:"jid"`
 	Connected bool       `json:"connected"`
+	Timezone  string     `json:"timezone"`
+}
+
+// Location returns the time zone of the device, the one of the server when it
+// has none.
+func (d *Device) Location() *time.Location {
+	if d == nil || d.Timezone == "" {
+		return time.Local
+	}
+
+	loc, err := time.LoadLocation(d.Timezone)
+	if err != nil {
+		return time.Local
+	}
+
+	return loc
+}
+
+// HistorySync holds how much history WhatsApp sends to a device when it is
+// paired, and how much of it has been imported. Status is pending until the
+// first chunk arrives, then running until Progress reaches 100.
+type HistorySync struct {
+	DeviceId      string    `json:"deviceId"`
+	Days          int       `json:"days"`
+	SizeMb        int       `json:"sizeMb"`
+	FullSync      bool      `json:"fullSync"`
+	Status        string    `json:"status"`
+	Progress      int       `json:"progress"`
+	Conversations int       `json:"conversations"`
+	Messages      int       `json:"messages"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+// SenderSettings limits how fast the broadcasts of a device are sent. A
+// message waits a random jitter of JitterMin to JitterMax seconds on top of
+// the rate, and the device shows typing for TypingMin to TypingMax seconds
+// before it.
+type SenderSettings struct {
+	DeviceId  string    `json:"deviceId"`
+	PerMinute int       `json:"perMinute" validate:"required,min=1,max=60"`
+	PerHour   int       `json:"perHour" validate:"required,min=1,max=3600"`
+	JitterMin int       `json:"jitterMin" validate:"min=0,max=3600"`
+	JitterMax int       `json:"jitterMax" validate:"min=0,max=3600,gtefield=JitterMin"`
+	TypingMin int       `json:"typingMin" validate:"min=0,max=60"`
+	TypingMax int       `json:"typingMax" validate:"min=0,max=60,gtefield=TypingMin"`
+	UpdatedAt time.Time `json:"updatedAt"`
 }
 
+// RetentionSettings sets how many days messages, the cached files of media
+// messages and broadcast recipients are kept. Settings of a user apply to all
+// of their devices, DeviceId is set for the settings of a single device. A nil
+// value falls back to the user settings, 0 keeps the data forever.
+type RetentionSettings struct {
+	UserId        int       `json:"userId"`
+	DeviceId      string    `json:"deviceId,omitempty"`
+	MessageDays   *int      `json:"messageDays" validate:"omitempty,min=0,max=36500"`
+	MediaDays     *int      `json:"mediaDays" validate:"omitempty,min=0,max=36500"`
+	BroadcastDays *int      `json:"broadcastDays" validate:"omitempty,min=0,max=36500"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+// RetentionPolicy is the retention applying to a device, 0 keeps the data
+// forever.
+type RetentionPolicy struct {
+	UserId        int        `json:"userId"`
+	DeviceId      string     `json:"deviceId"`
+	DeviceJid     *types.JID `json:"-"`
+	MessageDays   int        `json:"messageDays"`
+	MediaDays     int        `json:"mediaDays"`
+	BroadcastDays int        `json:"broadcastDays"`
+}
+
+// RetentionPurge counts the data of a device deleted by a purge, or that a
+// purge would delete.
+type RetentionPurge struct {
+	Id                  int64     `json:"id,omitempty"`
+	UserId              int       `json:"userId"`
+	DeviceId            string    `json:"deviceId"`
+	Messages            int       `json:"messages"`
+	Media               int       `json:"media"`
+	BroadcastRecipients int       `json:"broadcastRecipients"`
+	CreatedAt           time.Time `json:"createdAt"`
+}
+
+// MessageMedia describes the file attached to a media message.
+type MessageMedia struct {
+	Mime     string `json:"mime"`
+	Size     uint64 `json:"size"`
+	FileName string `json:"fileName,omitempty"`
+	// The cached file has been deleted by a retention policy
+	Purged bool `json:"purged,omitempty"`
+}
+
+// UserMessage is a stored WhatsApp message. Kind, Text, Caption, Media,
+// QuotedID and Sender are extracted from the raw Message so clients don't
+// have to read the protobuf fields:
+//
+//   - Kind is one of text, image, video, audio, document, sticker, location,
+//     contact, reaction, poll, buttons_response, list_response or unknown
+//   - Text is the body of a text message, Caption the caption of a media one
+//   - Media is set for image, video, audio, document and sticker messages
+//   - QuotedID is the ID of the message replied to
+//   - Sender is who wrote the message, it differs from TheirJID in groups.
+//     SenderAlt is the other address of the sender, its phone number JID
+//     when Sender is a LID and the other way around. PushName is the name
+//     the sender set, in groups it is the name of the participant
+//   - Imported messages come from a WhatsApp chat export, their raw Message
+//     is rebuilt from the export and has no media keys
+//   - Type is the message type reported by WhatsApp, ReceiptType the last
+//     receipt: sent, delivered, read, played or read-self
 type UserMessage struct {
 	ID          types.MessageID `json:"ID"`
 	DeviceId    string          `json:"deviceId"`
@@ -110,6 +361,53 @@ type UserMessage struct {
 	PushName    string          `json:"pushName"`
 	Type        string          `json:"type"`
 	ReceiptType string          `json:"receiptType"`
+	Kind        string          `json:"kind"`
+	Text        string          `json:"text"`
+	Caption     string          `json:"caption"`
+	Media       *MessageMedia   `json:"media"`
+	QuotedID    types.MessageID `json:"quotedId"`
+	Sender      *types.JID      `json:"sender"`
+	SenderAlt   *types.JID      `json:"senderAlt,omitempty"`
+	Imported    bool            `json:"imported,omitempty"`
+	EditOf      types.MessageID `json:"editOf,omitempty"`
+	Note        *ChatNote       `json:"note,omitempty"`
+}
+
+// Normalize fills the fields extracted from the raw message.
+func (m *UserMessage) Normalize() {
+	wm := m.Message
+	if wm == nil || wm.Message == nil {
+		return
+	}
+
+	msg := wm.Message
+	m.Kind = wm.Kind()
+	m.Text, m.Caption, m.Media = "", "", nil
+
+	switch m.Kind {
+	case "image":
+		media := msg.GetImageMessage()
+		m.Caption = media.GetCaption()
+		m.Media = &MessageMedia{Mime: media.GetMimetype(), Size: media.GetFileLength()}
+	case "video":
+		media := msg.GetVideoMessage()
+		m.Caption = media.GetCaption()
+		m.Media = &MessageMedia{Mime: media.GetMimetype(), Size: media.GetFileLength()}
+	case "audio":
+		media := msg.GetAudioMessage()
+		m.Media = &MessageMedia{Mime: media.GetMimetype(), Size: media.GetFileLength()}
+	case "document":
+		media := msg.GetDocumentMessage()
+		m.Caption = media.GetCaption()
+		m.Media = &MessageMedia{Mime: media.GetMimetype(), Size: media.GetFileLength(), FileName: media.GetFileName()}
+	case "sticker":
+		media := msg.GetStickerMessage()
+		m.Media = &MessageMedia{Mime: media.GetMimetype(), Size: media.GetFileLength()}
+	default:
+		m.Text = wm.Text()
+	}
+
+	m.QuotedID = wm.QuotedID()
 }
 
 type Broadcast struct {
@@ -129,7 +427,49 @@ type Broadcast struct {
 	CampaignName  string        `json:"campaignName" validate:"required"`
 	SentStartedAt *time.Time    `json:"sentStartedAt"`
 	Status        string        `json:"status"`
-	Device        *Device       `json:"device"`
+	// When the recipients were listed, the broadcast is sent to them only
+	SnapshotAt *time.Time      `json:"snapshotAt"`
+	Device     *Device         `json:"device"`
+	Stats      *BroadcastStats `json:"stats,omitempty"`
+}
+
+// BroadcastStatusChange records a change of the status of a broadcast,
+// FromStatus is nil when it was created and UserId when the sender made it.
+type BroadcastStatusChange struct {
+	Id          int64     `json:"id"`
+	BroadcastId int64     `json:"broadcastId"`
+	FromStatus  *string   `json:"fromStatus"`
+	ToStatus    string    `json:"toStatus"`
+	UserId      *int      `json:"userId"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// BroadcastStats counts the recipients of a broadcast by how far its message
+// got. The rates are percentages of the recipients it has been sent to, the
+// failure rate of those it was tried on. AvgTimeToRead is in seconds.
+type BroadcastStats struct {
+	Recipients    int      `json:"recipients"`
+	Pending       int      `json:"pending"`
+	Sent          int      `json:"sent"`
+	Delivered     int      `json:"delivered"`
+	Read          int      `json:"read"`
+	Failed        int      `json:"failed"`
+	Replied       int      `json:"replied"`
+	DeliveryRate  float64  `json:"deliveryRate"`
+	ReadRate      float64  `json:"readRate"`
+	ReplyRate     float64  `json:"replyRate"`
+	FailureRate   float64  `json:"failureRate"`
+	AvgTimeToRead *float64 `json:"avgTimeToRead"`
+}
+
+// BroadcastTimelineBucket counts the messages of a broadcast sent, delivered,
+// read and replied to in the hour starting at Time.
+type BroadcastTimelineBucket struct {
+	Time      time.Time `json:"time"`
+	Sent      int       `json:"sent"`
+	Delivered int       `json:"delivered"`
+	Read      int       `json:"read"`
+	Replied   int       `json:"replied"`
 }
 
 type BroadcastRecipient struct {
@@ -140,6 +480,21 @@ type BroadcastRecipient struct {
 	SentStatus  string           `json:"sentStatus"`
 	SentAt      *time.Time       `json:"sentAt"`
 	MessageId   *types.MessageID `json:"messageId"`
+	DeliveredAt *time.Time       `json:"deliveredAt,omitempty"`
+	ReadAt      *time.Time       `json:"readAt,omitempty"`
+	// The first message the recipient sent back after the broadcast
+	Replied        bool             `json:"replied"`
+	RepliedAt      *time.Time       `json:"repliedAt,omitempty"`
+	ReplyMessageId *types.MessageID `json:"replyMessageId,omitempty"`
+	// Why the last attempt failed, a queued recipient is retried at
+	// NextAttemptAt
+	Error         *string    `json:"error,omitempty"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt *time.Time `json:"nextAttemptAt,omitempty"`
+	// The custom fields of the contact when the broadcast started and the
+	// message rendered for the recipient
+	Fields  map[string]string `json:"fields,omitempty"`
+	Message *string           `json:"message,omitempty"`
 }
 
 type BroadcastToSend struct {
@@ -147,3 +502,205 @@ type BroadcastToSend struct {
 	Broadcast      Broadcast
 	Recipient      *BroadcastRecipient
 }
+
+type Event struct {
+	Id        string    `json:"id"`
+	Type      string    `json:"type"`
+	DeviceId  string    `json:"deviceId"`
+	Timestamp time.Time `json:"timestamp"`
+	Data      any       `json:"data"`
+}
+
+type Webhook struct {
+	Id        int64     `json:"id"`
+	DeviceId  string    `json:"deviceId"`
+	Url       string    `json:"url" validate:"required,url"`
+	Secret    string    `json:"secret" validate:"omitempty,min=16"`
+	Events    []string  `json:"events"`
+	Active    *bool     `json:"active"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type WebhookDelivery struct {
+	Id          int64           `json:"id"`
+	WebhookId   int64           `json:"webhookId"`
+	EventId     string          `json:"eventId"`
+	EventType   string          `json:"eventType"`
+	Payload     json.RawMessage `json:"payload"`
+	Status      string          `json:"status"`
+	StatusCode  int             `json:"statusCode"`
+	Attempts    int             `json:"attempts"`
+	Error       string          `json:"error"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	DeliveredAt *time.Time      `json:"deliveredAt"`
+}
+
+type OutboxEvent struct {
+	Id        int64           `json:"id"`
+	EventId   string          `json:"eventId"`
+	DeviceId  string          `json:"deviceId"`
+	EventType string          `json:"eventType"`
+	Payload   json.RawMessage `json:"payload"`
+	Attempts  int             `json:"attempts"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+type AutoReplyRule struct {
+	Id              int64         `json:"id"`
+	DeviceId        string        `json:"deviceId"`
+	Name            string        `json:"name" validate:"required"`
+	MatchType       string        `json:"matchType" validate:"required,oneof=exact contains regex"`
+	Keyword         string        `json:"keyword" validate:"required"`
+	ReplyMessage    string        `json:"replyMessage" validate:"required"`
+	ReplyMedia      *UploadedFile `json:"replyMedia"`
+	CooldownSeconds int           `json:"cooldownSeconds" validate:"min=0"`
+	ActiveFrom      string        `json:"activeFrom" validate:"omitempty,datetime=15:04"`
+	ActiveTo        string        `json:"activeTo" validate:"omitempty,datetime=15:04"`
+	Priority        int           `json:"priority"`
+	IncludeGroups   bool          `json:"includeGroups"`
+	Active          *bool         `json:"active"`
+	CreatedAt       time.Time     `json:"createdAt"`
+}
+
+type AutoReplyLog struct {
+	Id             int64           `json:"id"`
+	RuleId         int64           `json:"ruleId"`
+	DeviceId       string          `json:"deviceId"`
+	TheirJID       types.JID       `json:"theirJID"`
+	MessageId      types.MessageID `json:"messageId"`
+	ReplyMessageId types.MessageID `json:"replyMessageId"`
+	CreatedAt      time.Time       `json:"createdAt"`
+}
+
+type BotFlow struct {
+	Id         int64              `json:"id"`
+	DeviceId   string             `json:"deviceId"`
+	Name       string             `json:"name"`
+	Definition *BotFlowDefinition `json:"definition"`
+	Active     bool               `json:"active"`
+	CreatedAt  time.Time          `json:"createdAt"`
+	UpdatedAt  time.Time          `json:"updatedAt"`
+}
+
+// BotFlowDefinition is a state machine: the flow starts at Start when a
+// message matches one of the Triggers, every state sends its message and then
+// waits for one of its options, collects an input or moves on to Next.
+type BotFlowDefinition struct {
+	Triggers []string                `json:"triggers" yaml:"triggers"`
+	Start    string                  `json:"start" yaml:"start"`
+	Timeout  int                     `json:"timeout" yaml:"timeout"`
+	Fallback string                  `json:"fallback" yaml:"fallback"`
+	States   map[string]BotFlowState `json:"states" yaml:"states"`
+}
+
+type BotFlowState struct {
+	Message string          `json:"message" yaml:"message"`
+	Options []BotFlowOption `json:"options,omitempty" yaml:"options"`
+	Input   *BotFlowInput   `json:"input,omitempty" yaml:"input"`
+	Next    string          `json:"next,omitempty" yaml:"next"`
+}
+
+type BotFlowOption struct {
+	Match []string `json:"match" yaml:"match"`
+	Next  string   `json:"next" yaml:"next"`
+}
+
+type BotFlowInput struct {
+	Variable string `json:"variable" yaml:"variable"`
+	Pattern  string `json:"pattern,omitempty" yaml:"pattern"`
+	Next     string `json:"next" yaml:"next"`
+}
+
+func (d *BotFlowDefinition) Value() (driver.Value, error) {
+	return json.Marshal(d)
+}
+
+func (d *BotFlowDefinition) Scan(v any) error {
+	b, ok := v.([]byte)
+	if !ok {
+		return errors.New("type assertion to []byte failed")
+	}
+
+	return json.Unmarshal(b, &d)
+}
+
+type BotSession struct {
+	DeviceId  string            `json:"deviceId"`
+	TheirJID  types.JID         `json:"theirJID"`
+	FlowId    int64             `json:"flowId"`
+	State     string            `json:"state"`
+	Variables map[string]string `json:"variables"`
+	StartedAt time.Time         `json:"startedAt"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+	ExpiresAt *time.Time        `json:"expiresAt"`
+}
+
+// Agent is a member of the team of a user, conversations of the devices of
+// the user are assigned to agents by their name.
+type Agent struct {
+	Id        int64     `json:"id"`
+	UserId    int       `json:"userId"`
+	Name      string    `json:"name" validate:"required,max=255"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// UserChat is the conversation record of a chat, who handles it and where it
+// stands in the team inbox.
+type UserChat struct {
+	DeviceId       string     `json:"deviceId"`
+	TheirJID       types.JID  `json:"theirJID"`
+	Mode           string     `json:"mode"`
+	ModeChangedAt  time.Time  `json:"modeChangedAt"`
+	LastActivityAt time.Time  `json:"lastActivityAt"`
+	Assignee       string     `json:"assignee"`
+	Status         string     `json:"status"`
+	Priority       string     `json:"priority"`
+	Labels         []string   `json:"labels"`
+	ResolvedAt     *time.Time `json:"resolvedAt"`
+	LastReadAt     *time.Time `json:"lastReadAt"`
+}
+
+// HandoffSettings configures how the chats of a device move between the bot
+// and the agents. IdleTimeout is in minutes, 0 keeps a chat with the agents
+// until it is switched back manually.
+type HandoffSettings struct {
+	DeviceId       string `json:"deviceId"`
+	Keyword        string `json:"keyword" validate:"required,max=64"`
+	IdleTimeout    int    `json:"idleTimeout" validate:"min=0"`
+	HandoffMessage string `json:"handoffMessage"`
+}
+
+// ChatNote is a private note of the agents on a chat, it is never sent to
+// WhatsApp.
+type ChatNote struct {
+	Id        int64     `json:"id"`
+	DeviceId  string    `json:"deviceId"`
+	TheirJID  types.JID `json:"theirJID"`
+	Author    string    `json:"author" validate:"max=255"`
+	Note      string    `json:"note" validate:"required"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// MessageReceipt tracks the delivery of a message sent in a group to one of
+// its participants.
+type MessageReceipt struct {
+	MessageID      types.MessageID `json:"messageId"`
+	DeviceId       string          `json:"deviceId"`
+	TheirJID       types.JID       `json:"theirJID"`
+	Participant    types.JID       `json:"participant"`
+	ParticipantAlt *types.JID      `json:"participantAlt,omitempty"`
+	DeliveredAt    *time.Time      `json:"deliveredAt"`
+	ReadAt         *time.Time      `json:"readAt"`
+	PlayedAt       *time.Time      `json:"playedAt"`
+}
+
+// CannedResponse is a reply agents pick by its shortcode, {name} and {phone}
+// are filled from the contact when it is sent.
+type CannedResponse struct {
+	Id        int64     `json:"id"`
+	DeviceId  string    `json:"deviceId"`
+	Shortcode string    `json:"shortcode" validate:"required,max=64"`
+	Title     string    `json:"title" validate:"max=255"`
+	Message   string    `json:"message" validate:"required"`
+	CreatedAt time.Time `json:"createdAt"`
+}
@@ -2,12 +2,63 @@ package store
 
 import (
 	"database/sql"
+	"errors"
 	"log"
 )
 
 type migrateFunc func(*sql.Tx) error
 
-var migrates = [...]migrateFunc{migrateV1}
+// migration moves the schema one version up, down reverts it.
+type migration struct {
+	name string
+	up   migrateFunc
+	down migrateFunc
+}
+
+var migrates = [...]migration{
+	{"users, devices, contacts and broadcasts", migrateV1, rollbackV1},
+	{"webhooks", migrateV2, rollbackV2},
+	{"event outbox", migrateV3, rollbackV3},
+	{"auto replies", migrateV4, rollbackV4},
+	{"bot flows", migrateV5, rollbackV5},
+	{"chats and handoff settings", migrateV6, rollbackV6},
+	{"team inbox", migrateV7, rollbackV7},
+	{"chat notes and canned responses", migrateV8, rollbackV8},
+	{"chat read marker", migrateV9, rollbackV9},
+	{"message search", migrateV10, rollbackV10},
+	{"message columns", migrateV11, rollbackV11},
+	{"message table", migrateV12, rollbackV12},
+	{"group senders and receipts", migrateV13, rollbackV13},
+	{"history sync", migrateV14, rollbackV14},
+	{"retention policies", migrateV15, rollbackV15},
+	{"imported messages", migrateV16, rollbackV16},
+	{"broadcast replies", migrateV17, rollbackV17},
+	{"broadcast receipts", migrateV18, rollbackV18},
+	{"broadcast status", migrateV19, rollbackV19},
+	{"broadcast sender settings", migrateV20, rollbackV20},
+	{"broadcast recipient snapshot", migrateV21, rollbackV21},
+	{"broadcast recipient attempts", migrateV22, rollbackV22},
+	{"broadcast templates", migrateV23, rollbackV23},
+	{"device timezones", migrateV24, rollbackV24},
+	{"broadcast recipient chats", migrateV25, rollbackV25},
+	{"inbox agents", migrateV26, rollbackV26},
+}
+
+type MigrationStatus struct {
+	Version int
+	Name    string
+	Applied bool
+}
+
+func execAll(tx *sql.Tx, queries ...string) error {
+	for _, q := range queries {
+		if _, err := tx.Exec(q); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
 
 func (r *Repo) getMigrateVersion() (int, error) {
 	_, err := r.db.Exec("CREATE TABLE IF NOT EXISTS migrations (version INTEGER)")
@@ -33,6 +84,24 @@ func (r *Repo) setMigrateVersion(tx *sql.Tx, version int) error {
 	return err
 }
 
+// runMigration runs mf and records version in the same transaction.
+func (r *Repo) runMigration(mf migrateFunc, version int) error {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err = mf(tx); err == nil {
+		err = r.setMigrateVersion(tx, version)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
 func (r *Repo) Migrate() error {
 	version, err := r.getMigrateVersion()
 	if err != nil {
@@ -40,31 +109,53 @@ func (r *Repo) Migrate() error {
 	}
 
 	for ; version < len(migrates); version++ {
-		var tx *sql.Tx
+		log.Printf("Migrate DB to version v%d", version+1)
 
-		tx, err = r.db.Begin()
-		if err != nil {
+		if err = r.runMigration(migrates[version].up, version+1); err != nil {
 			return err
 		}
+	}
+	return nil
+}
 
-		log.Printf("Migrate DB to version v%d", version+1)
+// Rollback reverts the last steps migrations.
+func (r *Repo) Rollback(steps int) error {
+	version, err := r.getMigrateVersion()
+	if err != nil {
+		return err
+	}
+	if steps > version {
+		return errors.New("can't roll back more migrations than applied")
+	}
 
-		mf := migrates[version]
-		err = mf(tx)
-		if err != nil {
-			_ = tx.Rollback()
-			return err
-		}
+	for ; steps > 0; steps-- {
+		log.Printf("Rollback DB from version v%d", version)
 
-		if err = r.setMigrateVersion(tx, version+1); err != nil {
+		if err = r.runMigration(migrates[version-1].down, version-1); err != nil {
 			return err
 		}
+		version--
+	}
 
-		if err = tx.Commit(); err != nil {
-			return err
+	return nil
+}
+
+func (r *Repo) MigrateStatus() ([]MigrationStatus, error) {
+	version, err := r.getMigrateVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, len(migrates))
+	for i, m := range migrates {
+		status[i] = MigrationStatus{
+			Version: i + 1,
+			Name:    m.name,
+			Applied: i < version,
 		}
 	}
-	return nil
+
+	return status, nil
 }
 
 func migrateV1(tx *sql.Tx) error {
@@ -217,3 +308,782 @@ func migrateV1(tx *sql.Tx) error {
 
 	return nil
 }
+
+func migrateV2(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE "user_webhooks" (
+		"id" bigserial NOT NULL,
+		"device_id" uuid NOT NULL,
+		"url" text NOT NULL,
+		"secret" character varying(255) NOT NULL,
+		"events" jsonb,
+		"active" boolean DEFAULT true NOT NULL,
+		"created_at" timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
+		CONSTRAINT "user_webhooks_pkey" PRIMARY KEY ("id"),
+		CONSTRAINT "user_webhooks_device_id_fkey" FOREIGN KEY (device_id) REFERENCES user_devices(id) ON DELETE CASCADE NOT DEFERRABLE
+	)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE TABLE "user_webhook_deliveries" (
+		"id" bigserial NOT NULL,
+		"webhook_id" bigint NOT NULL,
+		"event_id" character varying(64) NOT NULL,
+		"event_type" character varying(32) NOT NULL,
+		"payload" jsonb NOT NULL,
+		"status" character varying(16) DEFAULT 'pending' NOT NULL,
+		"status_code" integer DEFAULT 0 NOT NULL,
+		"attempts" integer DEFAULT 0 NOT NULL,
+		"error" text,
+		"created_at" timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
+		"delivered_at" timestamptz,
+		CONSTRAINT "user_webhook_deliveries_pkey" PRIMARY KEY ("id"),
+		CONSTRAINT "user_webhook_deliveries_webhook_id_fkey" FOREIGN KEY (webhook_id) REFERENCES user_webhooks(id) ON DELETE CASCADE NOT DEFERRABLE
+	)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE INDEX "user_webhook_deliveries_webhook_id" ON "user_webhook_deliveries" ("webhook_id", "id" DESC)`)
+
+	return err
+}
+
+func migrateV3(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE "user_event_outbox" (
+		"id" bigserial NOT NULL,
+		"event_id" character varying(64) NOT NULL,
+		"device_id" uuid NOT NULL,
+		"event_type" character varying(32) NOT NULL,
+		"payload" jsonb NOT NULL,
+		"status" character varying(16) DEFAULT 'pending' NOT NULL,
+		"attempts" integer DEFAULT 0 NOT NULL,
+		"last_error" text,
+		"available_at" timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
+		"locked_until" timestamptz,
+		"created_at" timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
+		"processed_at" timestamptz,
+		CONSTRAINT "user_event_outbox_pkey" PRIMARY KEY ("id"),
+		CONSTRAINT "user_event_outbox_event_id" UNIQUE ("event_id")
+	)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE INDEX "user_event_outbox_pending" ON "user_event_outbox" ("status", "available_at", "id")`)
+	if err != nil {
+		return err
+	}
+
+	// An event can be processed more than once, deliver it once per webhook
+	_, err = tx.Exec(`CREATE UNIQUE INDEX "user_webhook_deliveries_event" ON "user_webhook_deliveries" ("webhook_id", "event_id")`)
+
+	return err
+}
+
+func migrateV4(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE "user_auto_replies" (
+		"id" bigserial NOT NULL,
+		"device_id" uuid NOT NULL,
+		"name" character varying(64) NOT NULL,
+		"match_type" character varying(16) NOT NULL,
+		"keyword" text NOT NULL,
+		"reply_message" text NOT NULL,
+		"reply_media" jsonb,
+		"cooldown_seconds" integer DEFAULT 0 NOT NULL,
+		"active_from" character varying(5),
+		"active_to" character varying(5),
+		"priority" integer DEFAULT 0 NOT NULL,
+		"include_groups" boolean DEFAULT false NOT NULL,
+		"active" boolean DEFAULT true NOT NULL,
+		"created_at" timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
+		CONSTRAINT "user_auto_replies_pkey" PRIMARY KEY ("id"),
+		CONSTRAINT "user_auto_replies_device_id_fkey" FOREIGN KEY (device_id) REFERENCES user_devices(id) ON DELETE CASCADE NOT DEFERRABLE
+	)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE TABLE "user_auto_reply_logs" (
+		"id" bigserial NOT NULL,
+		"rule_id" bigint NOT NULL,
+		"device_id" uuid NOT NULL,
+		"their_jid" text NOT NULL,
+		"message_id" text NOT NULL,
+		"reply_message_id" text NOT NULL,
+		"created_at" timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
+		CONSTRAINT "user_auto_reply_logs_pkey" PRIMARY KEY ("id"),
+		CONSTRAINT "user_auto_reply_logs_rule_id_fkey" FOREIGN KEY (rule_id) REFERENCES user_auto_replies(id) ON DELETE CASCADE NOT DEFERRABLE
+	)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE INDEX "user_auto_reply_logs_rule_jid" ON "user_auto_reply_logs" ("rule_id", "their_jid", "created_at" DESC)`)
+
+	return err
+}
+
+func migrateV5(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE "user_bot_flows" (
+		"id" bigserial NOT NULL,
+		"device_id" uuid NOT NULL,
+		"name" character varying(64) NOT NULL,
+		"definition" jsonb NOT NULL,
+		"active" boolean DEFAULT true NOT NULL,
+		"created_at" timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
+		"updated_at" timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
+		CONSTRAINT "user_bot_flows_pkey" PRIMARY KEY ("id"),
+		CONSTRAINT "user_bot_flows_device_id_fkey" FOREIGN KEY (device_id) REFERENCES user_devices(id) ON DELETE CASCADE NOT DEFERRABLE
+	)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE TABLE "user_bot_sessions" (
+		"device_id" uuid NOT NULL,
+		"their_jid" text NOT NULL,
+		"flow_id" bigint NOT NULL,
+		"state" character varying(64) NOT NULL,
+		"variables" jsonb,
+		"started_at" timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
+		"updated_at" timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
+		"expires_at" timestamptz,
+		CONSTRAINT "user_bot_sessions_pkey" PRIMARY KEY ("device_id", "their_jid"),
+		CONSTRAINT "user_bot_sessions_flow_id_fkey" FOREIGN KEY (flow_id) REFERENCES user_bot_flows(id) ON DELETE CASCADE NOT DEFERRABLE
+	)`)
+
+	return err
+}
+
+func migrateV6(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE "user_chats" (
+		"device_id" uuid NOT NULL,
+		"their_jid" text NOT NULL,
+		"mode" character varying(16) DEFAULT 'bot' NOT NULL,
+		"mode_changed_at" timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
+		"last_activity_at" timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
+		CONSTRAINT "user_chats_pkey" PRIMARY KEY ("device_id", "their_jid"),
+		CONSTRAINT "user_chats_device_id_fkey" FOREIGN KEY (device_id) REFERENCES user_devices(id) ON DELETE CASCADE NOT DEFERRABLE
+	)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE INDEX "user_chats_mode" ON "user_chats" ("mode", "last_activity_at")`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE TABLE "user_handoff_settings" (
+		"device_id" uuid NOT NULL,
+		"keyword" character varying(64) NOT NULL,
+		"idle_timeout" integer DEFAULT 30 NOT NULL,
+		"handoff_message" text DEFAULT '' NOT NULL,
+		CONSTRAINT "user_handoff_settings_pkey" PRIMARY KEY ("device_id"),
+		CONSTRAINT "user_handoff_settings_device_id_fkey" FOREIGN KEY (device_id) REFERENCES user_devices(id) ON DELETE CASCADE NOT DEFERRABLE
+	)`)
+
+	return err
+}
+
+func migrateV7(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE "user_chats"
+		ADD COLUMN "assignee" character varying(255) DEFAULT '' NOT NULL,
+		ADD COLUMN "status" character varying(16) DEFAULT 'open' NOT NULL,
+		ADD COLUMN "priority" character varying(16) DEFAULT 'normal' NOT NULL,
+		ADD COLUMN "labels" jsonb DEFAULT '[]' NOT NULL,
+		ADD COLUMN "resolved_at" timestamptz`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE INDEX "user_chats_inbox" ON "user_chats" ("device_id", "status", "assignee")`)
+
+	return err
+}
+
+func migrateV8(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE "user_chat_notes" (
+		"id" bigserial NOT NULL,
+		"device_id" uuid NOT NULL,
+		"their_jid" text NOT NULL,
+		"author" character varying(255) DEFAULT '' NOT NULL,
+		"note" text NOT NULL,
+		"created_at" timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
+		CONSTRAINT "user_chat_notes_pkey" PRIMARY KEY ("id"),
+		CONSTRAINT "user_chat_notes_device_id_fkey" FOREIGN KEY (device_id) REFERENCES user_devices(id) ON DELETE CASCADE NOT DEFERRABLE
+	)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE INDEX "user_chat_notes_chat" ON "user_chat_notes" ("device_id", "their_jid", "created_at" DESC)`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE TABLE "user_canned_responses" (
+		"id" bigserial NOT NULL,
+		"device_id" uuid NOT NULL,
+		"shortcode" character varying(64) NOT NULL,
+		"title" character varying(255) DEFAULT '' NOT NULL,
+		"message" text NOT NULL,
+		"created_at" timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
+		CONSTRAINT "user_canned_responses_pkey" PRIMARY KEY ("id"),
+		CONSTRAINT "user_canned_responses_shortcode" UNIQUE ("device_id", "shortcode"),
+		CONSTRAINT "user_canned_responses_device_id_fkey" FOREIGN KEY (device_id) REFERENCES user_devices(id) ON DELETE CASCADE NOT DEFERRABLE
+	)`)
+
+	return err
+}
+
+func migrateV9(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE "user_chats" ADD COLUMN "last_read_at" timestamptz`)
+
+	return err
+}
+
+// migrateV10 creates the message table on fresh installs, earlier versions
+// created it outside of the migrations, and makes the messages searchable.
+func migrateV10(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS "user_messages" (
+		"id" character varying(128) NOT NULL,
+		"their_jid" text NOT NULL,
+		"message" jsonb,
+		"timestamp" timestamptz NOT NULL,
+		"device_id" uuid NOT NULL,
+		"from_me" boolean DEFAULT false NOT NULL,
+		"type" character varying(32),
+		"push_name" text,
+		"receipt_type" character varying(32)
+	)`)
+	if err != nil {
+		return err
+	}
+
+	return addMessageSearch(tx)
+}
+
+func addMessageSearch(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE "user_messages"
+		ADD COLUMN "search_text" text,
+		ADD COLUMN "search_vector" tsvector GENERATED ALWAYS AS (to_tsvector('simple', COALESCE("search_text", ''))) STORED`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE "user_messages" SET "search_text"=NULLIF(concat_ws(' ',
+		message::jsonb->>'conversation',
+		message::jsonb->'extendedTextMessage'->>'text',
+		message::jsonb->'imageMessage'->>'caption',
+		message::jsonb->'videoMessage'->>'caption',
+		message::jsonb->'documentMessage'->>'caption',
+		message::jsonb->'documentMessage'->>'fileName',
+		message::jsonb->'buttonsResponseMessage'->>'selectedDisplayText',
+		message::jsonb->'listResponseMessage'->>'title'
+	), '')`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE INDEX "user_messages_search_vector" ON "user_messages" USING gin ("search_vector")`)
+	if err != nil {
+		return err
+	}
+
+	// Trigram index for partial words like invoice numbers, the extension
+	// may not be available to the database user
+	_, err = tx.Exec("SAVEPOINT pg_trgm")
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm")
+	if err != nil {
+		log.Printf("pg_trgm extension not available, partial word search is not indexed: %s", err.Error())
+		_, err = tx.Exec("ROLLBACK TO SAVEPOINT pg_trgm")
+		return err
+	}
+
+	_, err = tx.Exec(`CREATE INDEX "user_messages_search_text" ON "user_messages" USING gin ("search_text" gin_trgm_ops)`)
+
+	return err
+}
+
+// migrateV11 adds the columns extracted from the raw message, existing rows
+// are filled by a background job.
+func migrateV11(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE "user_messages"
+		ADD COLUMN "kind" character varying(32),
+		ADD COLUMN "text" text,
+		ADD COLUMN "caption" text,
+		ADD COLUMN "media_mime" character varying(255),
+		ADD COLUMN "media_size" bigint,
+		ADD COLUMN "media_filename" text,
+		ADD COLUMN "quoted_id" character varying(128),
+		ADD COLUMN "sender_jid" text`)
+
+	return err
+}
+
+// migrateV12 gives the message table its keys. The messages without a device
+// or of a deleted device and the duplicated ones can't have them, they are
+// moved to user_messages_removed first.
+func migrateV12(tx *sql.Tx) error {
+	err := execAll(tx,
+		`CREATE TABLE IF NOT EXISTS "user_messages_removed" AS SELECT * FROM "user_messages" WITH NO DATA`,
+		`WITH d AS (
+			DELETE FROM "user_messages" WHERE "id" IS NULL OR "device_id" IS NULL
+				OR "device_id"::text NOT IN (SELECT "id"::text FROM "user_devices")
+			RETURNING *
+		) INSERT INTO "user_messages_removed" SELECT * FROM d`,
+		`WITH d AS (
+			DELETE FROM "user_messages" a USING "user_messages" b
+				WHERE a.ctid < b.ctid AND a."device_id"=b."device_id" AND a."id"=b."id"
+			RETURNING a.*
+		) INSERT INTO "user_messages_removed" SELECT * FROM d`,
+	)
+	if err != nil {
+		return err
+	}
+
+	removed := 0
+	if err = tx.QueryRow(`SELECT COUNT(*) FROM "user_messages_removed"`).Scan(&removed); err != nil {
+		return err
+	}
+	if removed > 0 {
+		log.Printf("Migrate v12: %d messages without a device or duplicated have been moved to user_messages_removed", removed)
+	} else if _, err = tx.Exec(`DROP TABLE "user_messages_removed"`); err != nil {
+		return err
+	}
+
+	return execAll(tx,
+		`ALTER TABLE "user_messages" ALTER COLUMN "device_id" TYPE uuid USING "device_id"::text::uuid`,
+		`ALTER TABLE "user_messages"
+			ADD CONSTRAINT "user_messages_pkey" PRIMARY KEY ("device_id", "id"),
+			ADD CONSTRAINT "user_messages_device_id_fkey" FOREIGN KEY (device_id) REFERENCES user_devices(id) ON DELETE CASCADE NOT DEFERRABLE`,
+		`CREATE INDEX "user_messages_chat" ON "user_messages" ("device_id", "their_jid", "timestamp" DESC)`,
+		`CREATE INDEX "user_messages_receipts" ON "user_messages" ("device_id", "receipt_type")`,
+	)
+}
+
+// migrateV13 stores the alternative address of the sender of a message, and
+// the receipts of the participants of a group for the messages sent in it.
+func migrateV13(tx *sql.Tx) error {
+	return execAll(tx,
+		`ALTER TABLE "user_messages" ADD COLUMN "sender_alt" text`,
+		`CREATE TABLE "user_message_receipts" (
+			"device_id" uuid NOT NULL,
+			"message_id" character varying(128) NOT NULL,
+			"their_jid" text NOT NULL,
+			"participant" text NOT NULL,
+			"participant_alt" text,
+			"delivered_at" timestamptz,
+			"read_at" timestamptz,
+			"played_at" timestamptz,
+			CONSTRAINT "user_message_receipts_pkey" PRIMARY KEY ("device_id", "message_id", "participant"),
+			CONSTRAINT "user_message_receipts_device_id_fkey" FOREIGN KEY (device_id) REFERENCES user_devices(id) ON DELETE CASCADE NOT DEFERRABLE
+		)`,
+	)
+}
+
+// migrateV14 stores the history sync settings of a device chosen when it is
+// added, and the progress of the sync once it is paired.
+func migrateV14(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE "user_device_history_syncs" (
+		"device_id" uuid NOT NULL,
+		"days" integer DEFAULT 1 NOT NULL,
+		"size_mb" integer DEFAULT 10 NOT NULL,
+		"full_sync" boolean DEFAULT false NOT NULL,
+		"status" character varying(32) DEFAULT 'pending' NOT NULL,
+		"progress" integer DEFAULT 0 NOT NULL,
+		"conversations" integer DEFAULT 0 NOT NULL,
+		"messages" integer DEFAULT 0 NOT NULL,
+		"updated_at" timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
+		CONSTRAINT "user_device_history_syncs_pkey" PRIMARY KEY ("device_id"),
+		CONSTRAINT "user_device_history_syncs_device_id_fkey" FOREIGN KEY (device_id) REFERENCES user_devices(id) ON DELETE CASCADE NOT DEFERRABLE
+	)`)
+
+	return err
+}
+
+// migrateV15 adds the retention settings of users and devices, a device
+// setting overrides the one of its user, and the record of the purges.
+func migrateV15(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE "user_retention_settings" (
+			"id" bigserial NOT NULL,
+			"user_id" integer NOT NULL,
+			"device_id" uuid,
+			"message_days" integer,
+			"media_days" integer,
+			"broadcast_days" integer,
+			"updated_at" timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
+			CONSTRAINT "user_retention_settings_pkey" PRIMARY KEY ("id"),
+			CONSTRAINT "user_retention_settings_user_id_fkey" FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE NOT DEFERRABLE,
+			CONSTRAINT "user_retention_settings_device_id_fkey" FOREIGN KEY (device_id) REFERENCES user_devices(id) ON DELETE CASCADE NOT DEFERRABLE
+		)`,
+		`CREATE UNIQUE INDEX "user_retention_settings_user" ON "user_retention_settings" ("user_id") WHERE "device_id" IS NULL`,
+		`CREATE UNIQUE INDEX "user_retention_settings_device" ON "user_retention_settings" ("device_id") WHERE "device_id" IS NOT NULL`,
+		`CREATE TABLE "user_retention_purges" (
+			"id" bigserial NOT NULL,
+			"user_id" integer NOT NULL,
+			"device_id" uuid NOT NULL,
+			"messages" integer DEFAULT 0 NOT NULL,
+			"media" integer DEFAULT 0 NOT NULL,
+			"broadcast_recipients" integer DEFAULT 0 NOT NULL,
+			"created_at" timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
+			CONSTRAINT "user_retention_purges_pkey" PRIMARY KEY ("id"),
+			CONSTRAINT "user_retention_purges_user_id_fkey" FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE NOT DEFERRABLE
+		)`,
+		`CREATE INDEX "user_retention_purges_device" ON "user_retention_purges" ("device_id", "created_at" DESC)`,
+		`ALTER TABLE "user_messages" ADD COLUMN "media_purged_at" timestamptz`,
+		`CREATE INDEX "user_messages_timestamp" ON "user_messages" ("device_id", "timestamp")`,
+	)
+}
+
+// migrateV16 flags the messages imported from a WhatsApp chat export.
+func migrateV16(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE "user_messages" ADD COLUMN "imported" boolean DEFAULT false NOT NULL`)
+
+	return err
+}
+
+// migrateV17 links the first reply of a broadcast recipient to its row.
+func migrateV17(tx *sql.Tx) error {
+	return execAll(tx,
+		`ALTER TABLE "user_broadcast_recipients"
+			ADD COLUMN "replied_at" timestamptz,
+			ADD COLUMN "reply_message_id" text`,
+		`CREATE INDEX "user_broadcast_recipients_broadcast" ON "user_broadcast_recipients" ("broadcast_id")`,
+		`CREATE INDEX "user_broadcast_recipients_sent_at" ON "user_broadcast_recipients" ("sent_at")`,
+	)
+}
+
+// migrateV18 keeps when a broadcast recipient received and read the message.
+func migrateV18(tx *sql.Tx) error {
+	return execAll(tx,
+		`ALTER TABLE "user_broadcast_recipients"
+			ADD COLUMN "delivered_at" timestamptz,
+			ADD COLUMN "read_at" timestamptz`,
+		`CREATE INDEX "user_broadcast_recipients_message" ON "user_broadcast_recipients" ("message_id")`,
+	)
+}
+
+// migrateV19 replaces the status inferred from completed and completed_at by
+// an explicit one and records its changes.
+func migrateV19(tx *sql.Tx) error {
+	return execAll(tx,
+		`ALTER TABLE "user_broadcasts" ADD COLUMN "status" character varying(16) DEFAULT 'scheduled' NOT NULL`,
+		`UPDATE "user_broadcasts" SET "status"=CASE
+			WHEN completed AND completed_at IS NOT NULL THEN 'completed'
+			WHEN completed THEN 'paused'
+			WHEN sent_started_at <= now() THEN 'running'
+			ELSE 'scheduled' END`,
+		`CREATE INDEX "user_broadcasts_status" ON "user_broadcasts" ("status")`,
+		`CREATE TABLE "user_broadcast_status_history" (
+			"id" bigserial NOT NULL,
+			"broadcast_id" bigint NOT NULL,
+			"from_status" character varying(16),
+			"to_status" character varying(16) NOT NULL,
+			"user_id" integer,
+			"created_at" timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
+			CONSTRAINT "user_broadcast_status_history_pkey" PRIMARY KEY ("id"),
+			CONSTRAINT "user_broadcast_status_history_broadcast_id_fkey" FOREIGN KEY (broadcast_id) REFERENCES user_broadcasts(id) ON DELETE CASCADE NOT DEFERRABLE
+		)`,
+		`CREATE INDEX "user_broadcast_status_history_broadcast" ON "user_broadcast_status_history" ("broadcast_id", "created_at")`,
+	)
+}
+
+// migrateV20 adds the rate limits of the broadcast sender of each device.
+func migrateV20(tx *sql.Tx) error {
+	_, err := tx.Exec(`CREATE TABLE "user_device_sender_settings" (
+		"device_id" uuid NOT NULL,
+		"per_minute" integer NOT NULL,
+		"per_hour" integer NOT NULL,
+		"jitter_min" integer NOT NULL,
+		"jitter_max" integer NOT NULL,
+		"typing_min" integer NOT NULL,
+		"typing_max" integer NOT NULL,
+		"updated_at" timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
+		CONSTRAINT "user_device_sender_settings_pkey" PRIMARY KEY ("device_id"),
+		CONSTRAINT "user_device_sender_settings_device_id_fkey" FOREIGN KEY (device_id) REFERENCES user_devices(id) ON DELETE CASCADE NOT DEFERRABLE
+	)`)
+
+	return err
+}
+
+// migrateV21 lets the recipients of a broadcast be listed once when it
+// starts, each phone once, and queued.
+func migrateV21(tx *sql.Tx) error {
+	return execAll(tx,
+		`DELETE FROM "user_broadcast_recipients" r USING "user_broadcast_recipients" d
+			WHERE r.broadcast_id=d.broadcast_id AND r.phone=d.phone AND r.id > d.id`,
+		`CREATE UNIQUE INDEX "user_broadcast_recipients_phone" ON "user_broadcast_recipients" ("broadcast_id", "phone")`,
+		`CREATE INDEX "user_broadcast_recipients_queued" ON "user_broadcast_recipients" ("broadcast_id", "id") WHERE "sent_status"='queued'`,
+		`ALTER TABLE "user_broadcasts" ADD COLUMN "recipients_snapshot_at" timestamptz`,
+	)
+}
+
+// migrateV22 keeps why the message to a recipient failed and how many times
+// it was tried, a queued recipient waits for next_attempt_at to be retried.
+// The recipients not sent yet by older versions have no status, they are
+// queued.
+func migrateV22(tx *sql.Tx) error {
+	return execAll(tx,
+		`UPDATE "user_broadcast_recipients" SET "sent_status"='queued' WHERE "sent_status" IS NULL`,
+		`ALTER TABLE "user_broadcast_recipients" ADD COLUMN "error" text`,
+		`ALTER TABLE "user_broadcast_recipients" ADD COLUMN "attempts" integer NOT NULL DEFAULT 0`,
+		`ALTER TABLE "user_broadcast_recipients" ADD COLUMN "next_attempt_at" timestamptz`,
+		`UPDATE "user_broadcast_recipients" SET "attempts"=1 WHERE "sent_status" IS NOT NULL AND "sent_status"<>'queued'`,
+	)
+}
+
+// migrateV23 gives contacts custom fields, the recipients keep the fields
+// their message is rendered with and the message sent to them.
+func migrateV23(tx *sql.Tx) error {
+	return execAll(tx,
+		`ALTER TABLE "user_contacts" ADD COLUMN "fields" jsonb NOT NULL DEFAULT '{}'`,
+		`ALTER TABLE "user_broadcast_recipients" ADD COLUMN "fields" jsonb`,
+		`ALTER TABLE "user_broadcast_recipients" ADD COLUMN "message" text`,
+	)
+}
+
+// migrateV24 adds the time zone messages of a device are rendered in, empty
+// for the one of the server.
+func migrateV24(tx *sql.Tx) error {
+	_, err := tx.Exec(`ALTER TABLE "user_devices" ADD COLUMN "timezone" character varying(64) DEFAULT '' NOT NULL`)
+
+	return err
+}
+
+// migrateV25 keeps the chat a broadcast message was sent to, a reply is
+// attributed after the message is purged. The chats of the messages still
+// kept are filled in.
+func migrateV25(tx *sql.Tx) error {
+	return execAll(tx,
+		`ALTER TABLE "user_broadcast_recipients" ADD COLUMN "jid" text`,
+		`UPDATE "user_broadcast_recipients" r SET "jid"=m."their_jid"
+			FROM "user_broadcasts" b, "user_devices" d, "user_messages" m
+			WHERE b."id"=r."broadcast_id" AND d."jid"=b."jid" AND m."device_id"=d."id" AND m."id"=r."message_id"`,
+	)
+}
+
+// migrateV26 adds the agents conversations are assigned to, the assignees of
+// the conversations are added as agents of the user of their device.
+func migrateV26(tx *sql.Tx) error {
+	return execAll(tx,
+		`CREATE TABLE "user_agents" (
+			"id" bigserial NOT NULL,
+			"user_id" integer NOT NULL,
+			"name" character varying(255) NOT NULL,
+			"created_at" timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
+			CONSTRAINT "user_agents_pkey" PRIMARY KEY ("id"),
+			CONSTRAINT "user_agents_name" UNIQUE ("user_id", "name"),
+			CONSTRAINT "user_agents_user_id_fkey" FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE NOT DEFERRABLE
+		)`,
+		`INSERT INTO "user_agents" ("user_id", "name")
+			SELECT DISTINCT d."user_id", c."assignee" FROM "user_chats" c
+			JOIN "user_devices" d ON d."id"=c."device_id"
+			WHERE c."assignee"<>''
+			ON CONFLICT DO NOTHING`,
+	)
+}
+
+func rollbackV1(tx *sql.Tx) error {
+	return execAll(tx,
+		`DROP TABLE IF EXISTS "user_broadcast_recipients"`,
+		`DROP SEQUENCE IF EXISTS user_broadcast_recipients_id_seq`,
+		`DROP TABLE IF EXISTS "user_broadcasts"`,
+		`DROP SEQUENCE IF EXISTS user_broadcasts_id_seq`,
+		`DROP TABLE IF EXISTS "user_contact_groups_contacts"`,
+		`DROP TABLE IF EXISTS "user_contact_groups"`,
+		`DROP SEQUENCE IF EXISTS user_contact_groups_id_seq`,
+		`DROP TABLE IF EXISTS "user_contacts"`,
+		`DROP SEQUENCE IF EXISTS user_contacts_id_seq`,
+		`DROP TABLE IF EXISTS "user_devices"`,
+		`DROP TABLE IF EXISTS "user_sessions"`,
+		`DROP TABLE IF EXISTS "users"`,
+		`DROP SEQUENCE IF EXISTS users_id_seq`,
+	)
+}
+
+func rollbackV2(tx *sql.Tx) error {
+	return execAll(tx,
+		`DROP TABLE IF EXISTS "user_webhook_deliveries"`,
+		`DROP TABLE IF EXISTS "user_webhooks"`,
+	)
+}
+
+func rollbackV3(tx *sql.Tx) error {
+	return execAll(tx,
+		`DROP INDEX IF EXISTS "user_webhook_deliveries_event"`,
+		`DROP TABLE IF EXISTS "user_event_outbox"`,
+	)
+}
+
+func rollbackV4(tx *sql.Tx) error {
+	return execAll(tx,
+		`DROP TABLE IF EXISTS "user_auto_reply_logs"`,
+		`DROP TABLE IF EXISTS "user_auto_replies"`,
+	)
+}
+
+func rollbackV5(tx *sql.Tx) error {
+	return execAll(tx,
+		`DROP TABLE IF EXISTS "user_bot_sessions"`,
+		`DROP TABLE IF EXISTS "user_bot_flows"`,
+	)
+}
+
+func rollbackV6(tx *sql.Tx) error {
+	return execAll(tx,
+		`DROP TABLE IF EXISTS "user_handoff_settings"`,
+		`DROP TABLE IF EXISTS "user_chats"`,
+	)
+}
+
+func rollbackV7(tx *sql.Tx) error {
+	return execAll(tx,
+		`DROP INDEX IF EXISTS "user_chats_inbox"`,
+		`ALTER TABLE IF EXISTS "user_chats"
+			DROP COLUMN IF EXISTS "assignee",
+			DROP COLUMN IF EXISTS "status",
+			DROP COLUMN IF EXISTS "priority",
+			DROP COLUMN IF EXISTS "labels",
+			DROP COLUMN IF EXISTS "resolved_at"`,
+	)
+}
+
+func rollbackV8(tx *sql.Tx) error {
+	return execAll(tx,
+		`DROP TABLE IF EXISTS "user_canned_responses"`,
+		`DROP TABLE IF EXISTS "user_chat_notes"`,
+	)
+}
+
+func rollbackV9(tx *sql.Tx) error {
+	return execAll(tx, `ALTER TABLE IF EXISTS "user_chats" DROP COLUMN IF EXISTS "last_read_at"`)
+}
+
+func rollbackV10(tx *sql.Tx) error {
+	return execAll(tx,
+		`DROP INDEX IF EXISTS "user_messages_search_text"`,
+		`DROP INDEX IF EXISTS "user_messages_search_vector"`,
+		`ALTER TABLE IF EXISTS "user_messages" DROP COLUMN IF EXISTS "search_vector", DROP COLUMN IF EXISTS "search_text"`,
+	)
+}
+
+func rollbackV11(tx *sql.Tx) error {
+	return execAll(tx, `ALTER TABLE IF EXISTS "user_messages"
+		DROP COLUMN IF EXISTS "kind",
+		DROP COLUMN IF EXISTS "text",
+		DROP COLUMN IF EXISTS "caption",
+		DROP COLUMN IF EXISTS "media_mime",
+		DROP COLUMN IF EXISTS "media_size",
+		DROP COLUMN IF EXISTS "media_filename",
+		DROP COLUMN IF EXISTS "quoted_id",
+		DROP COLUMN IF EXISTS "sender_jid"`)
+}
+
+// rollbackV12 keeps the messages, only the keys and indexes are removed.
+func rollbackV12(tx *sql.Tx) error {
+	return execAll(tx,
+		`DROP INDEX IF EXISTS "user_messages_receipts"`,
+		`DROP INDEX IF EXISTS "user_messages_chat"`,
+		`ALTER TABLE IF EXISTS "user_messages"
+			DROP CONSTRAINT IF EXISTS "user_messages_device_id_fkey",
+			DROP CONSTRAINT IF EXISTS "user_messages_pkey"`,
+	)
+}
+
+func rollbackV13(tx *sql.Tx) error {
+	return execAll(tx,
+		`DROP TABLE IF EXISTS "user_message_receipts"`,
+		`ALTER TABLE IF EXISTS "user_messages" DROP COLUMN IF EXISTS "sender_alt"`,
+	)
+}
+
+func rollbackV14(tx *sql.Tx) error {
+	return execAll(tx, `DROP TABLE IF EXISTS "user_device_history_syncs"`)
+}
+
+func rollbackV15(tx *sql.Tx) error {
+	return execAll(tx,
+		`DROP INDEX IF EXISTS "user_messages_timestamp"`,
+		`ALTER TABLE IF EXISTS "user_messages" DROP COLUMN IF EXISTS "media_purged_at"`,
+		`DROP TABLE IF EXISTS "user_retention_purges"`,
+		`DROP TABLE IF EXISTS "user_retention_settings"`,
+	)
+}
+
+func rollbackV16(tx *sql.Tx) error {
+	return execAll(tx, `ALTER TABLE IF EXISTS "user_messages" DROP COLUMN IF EXISTS "imported"`)
+}
+
+func rollbackV17(tx *sql.Tx) error {
+	return execAll(tx,
+		`DROP INDEX IF EXISTS "user_broadcast_recipients_sent_at"`,
+		`DROP INDEX IF EXISTS "user_broadcast_recipients_broadcast"`,
+		`ALTER TABLE IF EXISTS "user_broadcast_recipients"
+			DROP COLUMN IF EXISTS "reply_message_id",
+			DROP COLUMN IF EXISTS "replied_at"`,
+	)
+}
+
+func rollbackV18(tx *sql.Tx) error {
+	return execAll(tx,
+		`DROP INDEX IF EXISTS "user_broadcast_recipients_message"`,
+		`ALTER TABLE IF EXISTS "user_broadcast_recipients"
+			DROP COLUMN IF EXISTS "read_at",
+			DROP COLUMN IF EXISTS "delivered_at"`,
+	)
+}
+
+func rollbackV19(tx *sql.Tx) error {
+	return execAll(tx,
+		`DROP TABLE IF EXISTS "user_broadcast_status_history"`,
+		`DROP INDEX IF EXISTS "user_broadcasts_status"`,
+		`ALTER TABLE IF EXISTS "user_broadcasts" DROP COLUMN IF EXISTS "status"`,
+	)
+}
+
+func rollbackV20(tx *sql.Tx) error {
+	return execAll(tx, `DROP TABLE IF EXISTS "user_device_sender_settings"`)
+}
+
+func rollbackV21(tx *sql.Tx) error {
+	return execAll(tx,
+		`ALTER TABLE IF EXISTS "user_broadcasts" DROP COLUMN IF EXISTS "recipients_snapshot_at"`,
+		`DROP INDEX IF EXISTS "user_broadcast_recipients_queued"`,
+		`DROP INDEX IF EXISTS "user_broadcast_recipients_phone"`,
+	)
+}
+
+func rollbackV22(tx *sql.Tx) error {
+	return execAll(tx,
+		`ALTER TABLE IF EXISTS "user_broadcast_recipients" DROP COLUMN IF EXISTS "next_attempt_at"`,
+		`ALTER TABLE IF EXISTS "user_broadcast_recipients" DROP COLUMN IF EXISTS "attempts"`,
+		`ALTER TABLE IF EXISTS "user_broadcast_recipients" DROP COLUMN IF EXISTS "error"`,
+	)
+}
+
+func rollbackV23(tx *sql.Tx) error {
+	return execAll(tx,
+		`ALTER TABLE IF EXISTS "user_broadcast_recipients" DROP COLUMN IF EXISTS "message"`,
+		`ALTER TABLE IF EXISTS "user_broadcast_recipients" DROP COLUMN IF EXISTS "fields"`,
+		`ALTER TABLE IF EXISTS "user_contacts" DROP COLUMN IF EXISTS "fields"`,
+	)
+}
+
+func rollbackV24(tx *sql.Tx) error {
+	return execAll(tx, `ALTER TABLE IF EXISTS "user_devices" DROP COLUMN IF EXISTS "timezone"`)
+}
+
+func rollbackV25(tx *sql.Tx) error {
+	return execAll(tx, `ALTER TABLE IF EXISTS "user_broadcast_recipients" DROP COLUMN IF EXISTS "jid"`)
+}
+
+func rollbackV26(tx *sql.Tx) error {
+	return execAll(tx, `DROP TABLE IF EXISTS "user_agents"`)
+}
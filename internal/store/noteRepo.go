@@ -0,0 +1,169 @@
+package store
+
+import (
+	"strconv"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+	"go.mau.fi/whatsmeow/types"
+
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+const (
+	chatNoteTable       = "user_chat_notes"
+	cannedResponseTable = "user_canned_responses"
+)
+
+const (
+	chatNoteColumns       = "id, device_id, their_jid, author, note, created_at"
+	cannedResponseColumns = "id, device_id, shortcode, title, message, created_at"
+
+	getChatNotesQuery   = "SELECT " + chatNoteColumns + " FROM " + chatNoteTable + " WHERE device_id=$1 AND their_jid=$2"
+	insertChatNoteQuery = `INSERT INTO ` + chatNoteTable + ` (device_id, their_jid, author, note) VALUES ($1, $2, $3, $4) RETURNING id, created_at`
+	deleteChatNoteQuery = `DELETE FROM ` + chatNoteTable + ` WHERE id=$1 AND device_id=$2 AND their_jid=$3`
+
+	getCannedResponsesQuery         = "SELECT " + cannedResponseColumns + " FROM " + cannedResponseTable + " WHERE device_id=$1 ORDER BY shortcode ASC"
+	getCannedResponseByIdQuery      = "SELECT " + cannedResponseColumns + " FROM " + cannedResponseTable + " WHERE id=$1 AND device_id=$2"
+	getCannedResponseShortcodeQuery = "SELECT " + cannedResponseColumns + " FROM " + cannedResponseTable + " WHERE device_id=$1 AND shortcode=$2"
+	insertCannedResponseQuery       = `INSERT INTO ` + cannedResponseTable + ` (device_id, shortcode, title, message) VALUES ($1, $2, $3, $4) RETURNING id, created_at`
+	updateCannedResponseQuery       = `UPDATE ` + cannedResponseTable + ` SET shortcode=$1, title=$2, message=$3 WHERE id=$4 AND device_id=$5 RETURNING created_at`
+	deleteCannedResponseQuery       = `DELETE FROM ` + cannedResponseTable + ` WHERE id=$1 AND device_id=$2`
+)
+
+func (r *Repo) ScanChatNote(row dbutil.Scannable) (*entity.ChatNote, error) {
+	var note entity.ChatNote
+
+	err := row.Scan(
+		&note.Id,
+		&note.DeviceId,
+		&note.TheirJID,
+		&note.Author,
+		&note.Note,
+		&note.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &note, nil
+}
+
+// GetChatNotes returns the notes of the chat written from from until to,
+// newest first. A zero time leaves that side of the period open.
+func (r *Repo) GetChatNotes(deviceId string, theirJID types.JID, from time.Time, to time.Time) ([]*entity.ChatNote, error) {
+	notes := make([]*entity.ChatNote, 0)
+
+	q, args := getChatNotesQuery, []any{deviceId, theirJID}
+	if !from.IsZero() {
+		args = append(args, from)
+		q += " AND created_at >= $" + strconv.Itoa(len(args))
+	}
+	if !to.IsZero() {
+		args = append(args, to)
+		q += " AND created_at < $" + strconv.Itoa(len(args))
+	}
+
+	rows, err := r.db.Query(q+" ORDER BY created_at DESC", args...)
+	if err != nil {
+		return notes, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		note, scanErr := r.ScanChatNote(rows)
+		if scanErr == nil {
+			notes = append(notes, note)
+		}
+	}
+
+	return notes, nil
+}
+
+func (r *Repo) InsertChatNote(note *entity.ChatNote) error {
+	return r.db.QueryRow(
+		insertChatNoteQuery,
+		note.DeviceId,
+		note.TheirJID,
+		note.Author,
+		note.Note,
+	).Scan(&note.Id, &note.CreatedAt)
+}
+
+func (r *Repo) DeleteChatNote(noteId int64, deviceId string, theirJID types.JID) error {
+	_, err := r.db.Exec(deleteChatNoteQuery, noteId, deviceId, theirJID)
+
+	return err
+}
+
+func (r *Repo) ScanCannedResponse(row dbutil.Scannable) (*entity.CannedResponse, error) {
+	var canned entity.CannedResponse
+
+	err := row.Scan(
+		&canned.Id,
+		&canned.DeviceId,
+		&canned.Shortcode,
+		&canned.Title,
+		&canned.Message,
+		&canned.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &canned, nil
+}
+
+func (r *Repo) GetCannedResponses(deviceId string) ([]*entity.CannedResponse, error) {
+	responses := make([]*entity.CannedResponse, 0)
+
+	rows, err := r.db.Query(getCannedResponsesQuery, deviceId)
+	if err != nil {
+		return responses, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		canned, scanErr := r.ScanCannedResponse(rows)
+		if scanErr == nil {
+			responses = append(responses, canned)
+		}
+	}
+
+	return responses, nil
+}
+
+func (r *Repo) GetCannedResponse(cannedId int64, deviceId string) (*entity.CannedResponse, error) {
+	return r.ScanCannedResponse(r.db.QueryRow(getCannedResponseByIdQuery, cannedId, deviceId))
+}
+
+func (r *Repo) GetCannedResponseByShortcode(deviceId string, shortcode string) (*entity.CannedResponse, error) {
+	return r.ScanCannedResponse(r.db.QueryRow(getCannedResponseShortcodeQuery, deviceId, shortcode))
+}
+
+func (r *Repo) SaveCannedResponse(canned *entity.CannedResponse) error {
+	if canned.Id != 0 {
+		return r.db.QueryRow(
+			updateCannedResponseQuery,
+			canned.Shortcode,
+			canned.Title,
+			canned.Message,
+			canned.Id,
+			canned.DeviceId,
+		).Scan(&canned.CreatedAt)
+	}
+
+	return r.db.QueryRow(
+		insertCannedResponseQuery,
+		canned.DeviceId,
+		canned.Shortcode,
+		canned.Title,
+		canned.Message,
+	).Scan(&canned.Id, &canned.CreatedAt)
+}
+
+func (r *Repo) DeleteCannedResponse(cannedId int64, deviceId string) error {
+	_, err := r.db.Exec(deleteCannedResponseQuery, cannedId, deviceId)
+
+	return err
+}
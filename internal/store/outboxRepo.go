@@ -0,0 +1,118 @@
+package store
+
+import (
+	"cmp"
+	"database/sql"
+	"slices"
+	"time"
+
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+const outboxTable = "user_event_outbox"
+
+const (
+	insertOutboxEventQuery = `INSERT INTO ` + outboxTable + ` (
+			event_id, device_id, event_type, payload
+		) VALUES ($1, $2, $3, $4) ON CONFLICT (event_id) DO NOTHING RETURNING id, created_at`
+	// Claim pending events, and events whose worker died while processing them,
+	// of the devices of the shard $4 of $3
+	claimOutboxEventsQuery = `UPDATE ` + outboxTable + ` SET
+			status='processing', attempts=attempts+1, locked_until=now() + ($2 * interval '1 second')
+		WHERE id IN (
+			SELECT id FROM ` + outboxTable + `
+			WHERE ((status='pending' AND available_at <= now()) OR (status='processing' AND locked_until < now()))
+				AND (hashtext(device_id::text) & 2147483647) % $3 = $4
+			ORDER BY id ASC LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		) RETURNING id, event_id, device_id, event_type, payload, attempts, created_at`
+	completeOutboxEventQuery = `UPDATE ` + outboxTable + ` SET status='done', last_error=NULL, locked_until=NULL, processed_at=now() WHERE id=$1`
+	failOutboxEventQuery     = `UPDATE ` + outboxTable + ` SET status=$1, last_error=$2, locked_until=NULL, available_at=$3 WHERE id=$4`
+	deleteOutboxEventsQuery  = `DELETE FROM ` + outboxTable + ` WHERE status='done' AND processed_at < $1`
+)
+
+func (r *Repo) InsertOutboxEvent(oe *entity.OutboxEvent) error {
+	err := r.db.QueryRow(
+		insertOutboxEventQuery,
+		oe.EventId,
+		oe.DeviceId,
+		oe.EventType,
+		[]byte(oe.Payload),
+	).Scan(&oe.Id, &oe.CreatedAt)
+
+	// The event has been stored before, nothing to do
+	if err == sql.ErrNoRows {
+		return nil
+	}
+
+	return err
+}
+
+// ClaimOutboxEvents claims the next events of the devices of a shard, the
+// events of a device are always in the same shard.
+func (r *Repo) ClaimOutboxEvents(limit int, lock time.Duration, shard int, shards int) ([]*entity.OutboxEvent, error) {
+	events := make([]*entity.OutboxEvent, 0)
+
+	rows, err := r.db.Query(claimOutboxEventsQuery, limit, int(lock.Seconds()), shards, shard)
+	if err != nil {
+		return events, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			oe      entity.OutboxEvent
+			payload []uint8
+		)
+
+		if err := rows.Scan(
+			&oe.Id,
+			&oe.EventId,
+			&oe.DeviceId,
+			&oe.EventType,
+			&payload,
+			&oe.Attempts,
+			&oe.CreatedAt,
+		); err == nil {
+			oe.Payload = payload
+			events = append(events, &oe)
+		}
+	}
+
+	// UPDATE ... RETURNING doesn't keep the subquery order
+	slices.SortFunc(events, func(a, b *entity.OutboxEvent) int {
+		return cmp.Compare(a.Id, b.Id)
+	})
+
+	return events, rows.Err()
+}
+
+func (r *Repo) CompleteOutboxEvent(id int64) error {
+	_, err := r.db.Exec(completeOutboxEventQuery, id)
+
+	return err
+}
+
+// FailOutboxEvent puts the event back in the queue to be retried at retryAt,
+// or marks it as failed when retryAt is nil.
+func (r *Repo) FailOutboxEvent(id int64, errMsg string, retryAt *time.Time) error {
+	status := "failed"
+	availableAt := time.Now()
+	if retryAt != nil {
+		status = "pending"
+		availableAt = *retryAt
+	}
+
+	_, err := r.db.Exec(failOutboxEventQuery, status, errMsg, availableAt, id)
+
+	return err
+}
+
+func (r *Repo) DeleteProcessedOutboxEvents(before time.Time) (int64, error) {
+	res, err := r.db.Exec(deleteOutboxEventsQuery, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
@@ -0,0 +1,117 @@
+package store
+
+import (
+	"database/sql"
+
+	"go.mau.fi/util/dbutil"
+	"go.mau.fi/whatsmeow/types"
+
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+const messageReceiptTable = "user_message_receipts"
+
+const (
+	messageReceiptColumns = "message_id, device_id, their_jid, participant, participant_alt, delivered_at, read_at, played_at"
+
+	getMessageReceiptsQuery = "SELECT " + messageReceiptColumns + " FROM " + messageReceiptTable + " WHERE device_id=$1 AND message_id=$2 ORDER BY participant ASC"
+
+	// A later receipt implies the earlier ones, a read message has been
+	// delivered too. The first time of each receipt is kept.
+	upsertMessageReceiptQuery = `INSERT INTO ` + messageReceiptTable + ` (device_id, message_id, their_jid, participant, participant_alt, delivered_at, read_at, played_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (device_id, message_id, participant) DO UPDATE SET
+			participant_alt=COALESCE(EXCLUDED.participant_alt, ` + messageReceiptTable + `.participant_alt),
+			delivered_at=COALESCE(` + messageReceiptTable + `.delivered_at, EXCLUDED.delivered_at),
+			read_at=COALESCE(` + messageReceiptTable + `.read_at, EXCLUDED.read_at),
+			played_at=COALESCE(` + messageReceiptTable + `.played_at, EXCLUDED.played_at)`
+)
+
+func (r *Repo) ScanMessageReceipt(row dbutil.Scannable) (*entity.MessageReceipt, error) {
+	var (
+		receipt                       entity.MessageReceipt
+		participantAlt                sql.NullString
+		deliveredAt, readAt, playedAt sql.NullTime
+	)
+
+	err := row.Scan(
+		&receipt.MessageID,
+		&receipt.DeviceId,
+		&receipt.TheirJID,
+		&receipt.Participant,
+		&participantAlt,
+		&deliveredAt,
+		&readAt,
+		&playedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if participantAlt.Valid {
+		if alt, err := types.ParseJID(participantAlt.String); err == nil {
+			receipt.ParticipantAlt = &alt
+		}
+	}
+	if deliveredAt.Valid {
+		receipt.DeliveredAt = &deliveredAt.Time
+	}
+	if readAt.Valid {
+		receipt.ReadAt = &readAt.Time
+	}
+	if playedAt.Valid {
+		receipt.PlayedAt = &playedAt.Time
+	}
+
+	return &receipt, nil
+}
+
+// GetMessageReceipts returns the receipts of the participants of the group a
+// message has been sent in.
+func (r *Repo) GetMessageReceipts(deviceId string, messageId types.MessageID) ([]*entity.MessageReceipt, error) {
+	receipts := make([]*entity.MessageReceipt, 0)
+
+	rows, err := r.db.Query(getMessageReceiptsQuery, deviceId, messageId)
+	if err != nil {
+		return receipts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		receipt, err := r.ScanMessageReceipt(rows)
+		if err != nil {
+			return receipts, err
+		}
+		receipts = append(receipts, receipt)
+	}
+
+	return receipts, rows.Err()
+}
+
+// SaveMessageReceipts records the receipt of a participant for each of the
+// messages.
+func (r *Repo) SaveMessageReceipts(receipt *entity.MessageReceipt, messageIds []types.MessageID) error {
+	var participantAlt *types.JID
+	if receipt.ParticipantAlt != nil && !receipt.ParticipantAlt.IsEmpty() {
+		participantAlt = receipt.ParticipantAlt
+	}
+
+	for _, messageId := range messageIds {
+		_, err := r.db.Exec(
+			upsertMessageReceiptQuery,
+			receipt.DeviceId,
+			messageId,
+			receipt.TheirJID,
+			receipt.Participant,
+			participantAlt,
+			receipt.DeliveredAt,
+			receipt.ReadAt,
+			receipt.PlayedAt,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
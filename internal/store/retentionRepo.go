@@ -0,0 +1,375 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+	"go.mau.fi/whatsmeow/types"
+
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+const (
+	retentionSettingsTable = "user_retention_settings"
+	retentionPurgeTable    = "user_retention_purges"
+)
+
+const (
+	retentionSettingsColumns = "user_id, COALESCE(device_id::text, ''), message_days, media_days, broadcast_days, updated_at"
+	retentionPurgeColumns    = "id, user_id, device_id, messages, media, broadcast_recipients, created_at"
+
+	getUserRetentionSettingsQuery   = "SELECT " + retentionSettingsColumns + " FROM " + retentionSettingsTable + " WHERE user_id=$1 AND device_id IS NULL"
+	getDeviceRetentionSettingsQuery = "SELECT " + retentionSettingsColumns + " FROM " + retentionSettingsTable + " WHERE user_id=$1 AND device_id=$2"
+	saveUserRetentionSettingsQuery  = `INSERT INTO ` + retentionSettingsTable + ` (user_id, message_days, media_days, broadcast_days) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id) WHERE device_id IS NULL DO UPDATE SET
+			message_days=EXCLUDED.message_days, media_days=EXCLUDED.media_days, broadcast_days=EXCLUDED.broadcast_days, updated_at=now()
+		RETURNING updated_at`
+	saveDeviceRetentionSettingsQuery = `INSERT INTO ` + retentionSettingsTable + ` (user_id, device_id, message_days, media_days, broadcast_days) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (device_id) WHERE device_id IS NOT NULL DO UPDATE SET
+			message_days=EXCLUDED.message_days, media_days=EXCLUDED.media_days, broadcast_days=EXCLUDED.broadcast_days, updated_at=now()
+		RETURNING updated_at`
+
+	// The settings of a device override the ones of its user
+	retentionPolicyQuery = `SELECT d.user_id, d.id, d.jid,
+			COALESCE(ds.message_days, us.message_days, 0),
+			COALESCE(ds.media_days, us.media_days, 0),
+			COALESCE(ds.broadcast_days, us.broadcast_days, 0)
+		FROM ` + userDeviceTableName + ` d
+		LEFT JOIN ` + retentionSettingsTable + ` us ON us.user_id=d.user_id AND us.device_id IS NULL
+		LEFT JOIN ` + retentionSettingsTable + ` ds ON ds.device_id=d.id`
+
+	// The receipts of the messages go with them
+	purgeMessagesQuery = `WITH d AS (
+			DELETE FROM user_messages WHERE device_id=$1 AND id IN (
+				SELECT id FROM user_messages WHERE device_id=$1 AND timestamp < $2 LIMIT $3
+			) RETURNING id, media_mime IS NOT NULL AND media_purged_at IS NULL
+		), r AS (
+			DELETE FROM ` + messageReceiptTable + ` WHERE device_id=$1 AND message_id IN (SELECT id FROM d)
+		)
+		SELECT * FROM d`
+	getExpiredMediaQuery = `SELECT id FROM user_messages WHERE device_id=$1 AND timestamp < $2
+		AND media_mime IS NOT NULL AND media_purged_at IS NULL LIMIT $3`
+	markMediaPurgedQuery = "UPDATE user_messages SET media_purged_at=now() WHERE device_id=$1 AND id = ANY($2)"
+	// Recipients still queued are kept until their broadcast has ended
+	expiredBroadcastRecipientsWhere = `b.jid=$1 AND (r.sent_at < $2
+			OR (r.sent_at IS NULL AND b.status IN ('completed', 'cancelled', 'failed') AND b.created_at < $2))`
+	purgeBroadcastRecipientsQuery = `DELETE FROM user_broadcast_recipients WHERE id IN (
+			SELECT r.id FROM user_broadcast_recipients r JOIN user_broadcasts b ON b.id=r.broadcast_id
+			WHERE ` + expiredBroadcastRecipientsWhere + ` LIMIT $3
+		)`
+	// Webhook deliveries and processed events keep copies of the messages
+	purgeWebhookDeliveriesQuery = `DELETE FROM ` + webhookDeliveryTable + ` WHERE id IN (
+			SELECT d.id FROM ` + webhookDeliveryTable + ` d JOIN ` + webhookTable + ` w ON w.id=d.webhook_id
+			WHERE w.device_id=$1 AND d.created_at < $2 AND d.status <> 'pending' LIMIT $3
+		)`
+	purgeOutboxEventsQuery = `DELETE FROM ` + outboxTable + ` WHERE id IN (
+			SELECT id FROM ` + outboxTable + ` WHERE device_id=$1 AND created_at < $2 AND status IN ('done', 'failed') LIMIT $3
+		)`
+
+	countExpiredMessagesQuery            = "SELECT COUNT(*) FROM user_messages WHERE device_id=$1 AND timestamp < $2"
+	countExpiredMediaQuery               = "SELECT COUNT(*) FROM user_messages WHERE device_id=$1 AND timestamp < $2 AND media_mime IS NOT NULL AND media_purged_at IS NULL"
+	countExpiredBroadcastRecipientsQuery = `SELECT COUNT(*) FROM user_broadcast_recipients r JOIN user_broadcasts b ON b.id=r.broadcast_id
+		WHERE ` + expiredBroadcastRecipientsWhere
+
+	insertRetentionPurgeQuery = `INSERT INTO ` + retentionPurgeTable + ` (user_id, device_id, messages, media, broadcast_recipients)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`
+	getRetentionPurgesQuery = "SELECT " + retentionPurgeColumns + " FROM " + retentionPurgeTable + " WHERE device_id=$1 ORDER BY created_at DESC LIMIT $2"
+)
+
+// RetentionCutoff returns the time before which data kept for days is
+// expired, the zero time when it is kept forever.
+func RetentionCutoff(days int) time.Time {
+	if days <= 0 {
+		return time.Time{}
+	}
+
+	return time.Now().AddDate(0, 0, -days)
+}
+
+func (r *Repo) ScanRetentionSettings(row dbutil.Scannable) (*entity.RetentionSettings, error) {
+	var (
+		settings                              entity.RetentionSettings
+		messageDays, mediaDays, broadcastDays sql.NullInt32
+	)
+
+	err := row.Scan(
+		&settings.UserId,
+		&settings.DeviceId,
+		&messageDays,
+		&mediaDays,
+		&broadcastDays,
+		&settings.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	nullDays := func(n sql.NullInt32) *int {
+		if !n.Valid {
+			return nil
+		}
+		days := int(n.Int32)
+		return &days
+	}
+	settings.MessageDays = nullDays(messageDays)
+	settings.MediaDays = nullDays(mediaDays)
+	settings.BroadcastDays = nullDays(broadcastDays)
+
+	return &settings, nil
+}
+
+// GetRetentionSettings returns the retention settings of the user, or of one
+// of their devices when deviceId is not empty.
+func (r *Repo) GetRetentionSettings(userId int, deviceId string) (*entity.RetentionSettings, error) {
+	var row *sql.Row
+	if deviceId == "" {
+		row = r.db.QueryRow(getUserRetentionSettingsQuery, userId)
+	} else {
+		row = r.db.QueryRow(getDeviceRetentionSettingsQuery, userId, deviceId)
+	}
+
+	settings, err := r.ScanRetentionSettings(row)
+	if err == sql.ErrNoRows {
+		return &entity.RetentionSettings{
+			UserId:   userId,
+			DeviceId: deviceId,
+		}, nil
+	}
+
+	return settings, err
+}
+
+func (r *Repo) SaveRetentionSettings(settings *entity.RetentionSettings) error {
+	if settings.DeviceId == "" {
+		return r.db.QueryRow(
+			saveUserRetentionSettingsQuery,
+			settings.UserId,
+			settings.MessageDays,
+			settings.MediaDays,
+			settings.BroadcastDays,
+		).Scan(&settings.UpdatedAt)
+	}
+
+	return r.db.QueryRow(
+		saveDeviceRetentionSettingsQuery,
+		settings.UserId,
+		settings.DeviceId,
+		settings.MessageDays,
+		settings.MediaDays,
+		settings.BroadcastDays,
+	).Scan(&settings.UpdatedAt)
+}
+
+func (r *Repo) ScanRetentionPolicy(row dbutil.Scannable) (*entity.RetentionPolicy, error) {
+	var policy entity.RetentionPolicy
+
+	err := row.Scan(
+		&policy.UserId,
+		&policy.DeviceId,
+		&policy.DeviceJid,
+		&policy.MessageDays,
+		&policy.MediaDays,
+		&policy.BroadcastDays,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &policy, nil
+}
+
+func (r *Repo) GetRetentionPolicy(deviceId string) (*entity.RetentionPolicy, error) {
+	return r.ScanRetentionPolicy(r.db.QueryRow(retentionPolicyQuery+" WHERE d.id=$1", deviceId))
+}
+
+// GetRetentionPolicies returns the policies of the devices with data to
+// purge.
+func (r *Repo) GetRetentionPolicies() ([]*entity.RetentionPolicy, error) {
+	policies := make([]*entity.RetentionPolicy, 0)
+
+	rows, err := r.db.Query(`SELECT * FROM (` + retentionPolicyQuery + `) p(user_id, device_id, jid, message_days, media_days, broadcast_days)
+		WHERE message_days > 0 OR media_days > 0 OR broadcast_days > 0`)
+	if err != nil {
+		return policies, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		policy, scanErr := r.ScanRetentionPolicy(rows)
+		if scanErr == nil {
+			policies = append(policies, policy)
+		}
+	}
+
+	return policies, rows.Err()
+}
+
+// PurgeExpiredMessages deletes up to limit messages of the device sent before
+// the cutoff, and their receipts. It returns the IDs of the deleted messages,
+// any of them may have a cached file, and how many had media not purged yet.
+func (r *Repo) PurgeExpiredMessages(deviceId string, before time.Time, limit int) ([]types.MessageID, int, error) {
+	ids := make([]types.MessageID, 0)
+	media := 0
+
+	rows, err := r.db.Query(purgeMessagesQuery, deviceId, before, limit)
+	if err != nil {
+		return ids, media, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			id       types.MessageID
+			hasMedia bool
+		)
+		if err = rows.Scan(&id, &hasMedia); err != nil {
+			return ids, media, err
+		}
+
+		ids = append(ids, id)
+		if hasMedia {
+			media++
+		}
+	}
+
+	return ids, media, rows.Err()
+}
+
+// GetExpiredMedia returns the IDs of up to limit media messages of the device
+// sent before the cutoff whose media has not been purged.
+func (r *Repo) GetExpiredMedia(deviceId string, before time.Time, limit int) ([]types.MessageID, error) {
+	ids := make([]types.MessageID, 0)
+
+	rows, err := r.db.Query(getExpiredMediaQuery, deviceId, before, limit)
+	if err != nil {
+		return ids, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id types.MessageID
+		if err = rows.Scan(&id); err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// MarkMediaPurged flags the media of the messages as purged once their cached
+// files are deleted, the messages are kept.
+func (r *Repo) MarkMediaPurged(deviceId string, messageIds []types.MessageID) error {
+	_, err := r.db.Exec(markMediaPurgedQuery, deviceId, messageIds)
+
+	return err
+}
+
+// PurgeExpiredEvents deletes up to limit webhook deliveries and up to limit
+// processed events of the device recorded before the cutoff. It returns how
+// many rows have been deleted.
+func (r *Repo) PurgeExpiredEvents(deviceId string, before time.Time, limit int) (int, error) {
+	deleted := 0
+	for _, q := range []string{purgeWebhookDeliveriesQuery, purgeOutboxEventsQuery} {
+		res, err := r.db.Exec(q, deviceId, before, limit)
+		if err != nil {
+			return deleted, err
+		}
+
+		n, err := res.RowsAffected()
+		deleted += int(n)
+		if err != nil {
+			return deleted, err
+		}
+	}
+
+	return deleted, nil
+}
+
+// PurgeExpiredBroadcastRecipients deletes up to limit recipients of the
+// broadcasts of the device sent before the cutoff.
+func (r *Repo) PurgeExpiredBroadcastRecipients(deviceJid types.JID, before time.Time, limit int) (int, error) {
+	res, err := r.db.Exec(purgeBroadcastRecipientsQuery, deviceJid, before, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	deleted, err := res.RowsAffected()
+
+	return int(deleted), err
+}
+
+// CountExpiredData counts the data of the device a purge with the policy
+// would delete.
+func (r *Repo) CountExpiredData(policy *entity.RetentionPolicy) (*entity.RetentionPurge, error) {
+	purge := &entity.RetentionPurge{
+		UserId:    policy.UserId,
+		DeviceId:  policy.DeviceId,
+		CreatedAt: time.Now(),
+	}
+
+	if before := RetentionCutoff(policy.MessageDays); !before.IsZero() {
+		if err := r.db.QueryRow(countExpiredMessagesQuery, policy.DeviceId, before).Scan(&purge.Messages); err != nil {
+			return nil, err
+		}
+	}
+
+	// Media of the expired messages is deleted with them
+	before := RetentionCutoff(policy.MediaDays)
+	if messagesBefore := RetentionCutoff(policy.MessageDays); messagesBefore.After(before) {
+		before = messagesBefore
+	}
+	if !before.IsZero() {
+		if err := r.db.QueryRow(countExpiredMediaQuery, policy.DeviceId, before).Scan(&purge.Media); err != nil {
+			return nil, err
+		}
+	}
+
+	if before := RetentionCutoff(policy.BroadcastDays); !before.IsZero() && policy.DeviceJid != nil {
+		if err := r.db.QueryRow(countExpiredBroadcastRecipientsQuery, policy.DeviceJid, before).Scan(&purge.BroadcastRecipients); err != nil {
+			return nil, err
+		}
+	}
+
+	return purge, nil
+}
+
+func (r *Repo) InsertRetentionPurge(purge *entity.RetentionPurge) error {
+	return r.db.QueryRow(
+		insertRetentionPurgeQuery,
+		purge.UserId,
+		purge.DeviceId,
+		purge.Messages,
+		purge.Media,
+		purge.BroadcastRecipients,
+	).Scan(&purge.Id, &purge.CreatedAt)
+}
+
+func (r *Repo) GetRetentionPurges(deviceId string, limit int) ([]*entity.RetentionPurge, error) {
+	purges := make([]*entity.RetentionPurge, 0)
+
+	rows, err := r.db.Query(getRetentionPurgesQuery, deviceId, limit)
+	if err != nil {
+		return purges, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var purge entity.RetentionPurge
+		err = rows.Scan(
+			&purge.Id,
+			&purge.UserId,
+			&purge.DeviceId,
+			&purge.Messages,
+			&purge.Media,
+			&purge.BroadcastRecipients,
+			&purge.CreatedAt,
+		)
+		if err == nil {
+			purges = append(purges, &purge)
+		}
+	}
+
+	return purges, rows.Err()
+}
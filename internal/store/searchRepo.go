@@ -0,0 +1,104 @@
+package store
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+// Highlighted words of a snippet are wrapped in <mark></mark>
+const searchHeadlineOptions = "StartSel=<mark>, StopSel=</mark>, MaxWords=30, MinWords=10, MaxFragments=2, FragmentDelimiter=\" … \""
+
+// MessageSearch filters the stored messages of a device. Query matches whole
+// words, or any part of the text of a message. Sender is "me" for the
+// messages sent by the device, or the JID of a contact. Kind is one of the
+// kinds of entity.UserMessage.
+type MessageSearch struct {
+	Query  string
+	Chat   types.JID
+	Sender string
+	From   time.Time
+	To     time.Time
+	Kind   string
+	Limit  int
+	Offset int
+}
+
+type MessageSearchResult struct {
+	Message entity.UserMessage `json:"message"`
+	Snippet string             `json:"snippet"`
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// SearchWAMessages returns a page of the messages matching the search, newest
+// first, with the total number of matches.
+func (r *Repo) SearchWAMessages(deviceId string, search MessageSearch) ([]MessageSearchResult, int, error) {
+	results := make([]MessageSearchResult, 0)
+	total := 0
+
+	args := []any{deviceId, search.Query, "%" + escapeLike(search.Query) + "%"}
+	where := ` WHERE device_id=$1 AND (search_vector @@ websearch_to_tsquery('simple', $2) OR search_text ILIKE $3)`
+
+	if !search.Chat.IsEmpty() {
+		args = append(args, search.Chat)
+		where += " AND their_jid=$" + strconv.Itoa(len(args))
+	}
+	if search.Sender == "me" {
+		where += " AND from_me"
+	} else if search.Sender != "" {
+		args = append(args, search.Sender)
+		// In a group the chat is the group, the sender is the participant
+		n := "$" + strconv.Itoa(len(args))
+		where += " AND NOT from_me AND (sender_jid=" + n + " OR sender_alt=" + n + " OR (sender_jid IS NULL AND their_jid=" + n + "))"
+	}
+	if !search.From.IsZero() {
+		args = append(args, search.From)
+		where += " AND timestamp >= $" + strconv.Itoa(len(args))
+	}
+	if !search.To.IsZero() {
+		args = append(args, search.To)
+		where += " AND timestamp < $" + strconv.Itoa(len(args))
+	}
+	if search.Kind != "" {
+		args = append(args, search.Kind)
+		where += " AND kind=$" + strconv.Itoa(len(args))
+	}
+
+	err := r.db.QueryRow("SELECT COUNT(*) FROM user_messages"+where, args...).Scan(&total)
+	if err != nil || total == 0 {
+		return results, total, err
+	}
+
+	n := len(args)
+	args = append(args, search.Limit, search.Offset)
+	rows, err := r.db.Query(`SELECT `+userMessageColumns+`,
+			ts_headline('simple', COALESCE(search_text, ''), websearch_to_tsquery('simple', $2), '`+searchHeadlineOptions+`')
+		FROM user_messages`+where+`
+		ORDER BY timestamp DESC, id DESC LIMIT $`+strconv.Itoa(n+1)+` OFFSET $`+strconv.Itoa(n+2), args...)
+	if err != nil {
+		return results, total, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var snippet sql.NullString
+
+		m, scanErr := r.ScanChat(scanAppend{rows, &snippet})
+		if scanErr == nil {
+			results = append(results, MessageSearchResult{
+				Message: *m,
+				Snippet: snippet.String,
+			})
+		}
+	}
+
+	return results, total, rows.Err()
+}
@@ -2,7 +2,10 @@ package store
 
 import (
 	"database/sql"
+	"errors"
 	"log"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -22,69 +25,171 @@ func NewRepo(db *sql.DB) *Repo {
 	}
 }
 
+// InsertWAMessage stores the message once, inserting the same message again
+// is a no-op so it is safe to call for redelivered events.
 func (r *Repo) InsertWAMessage(m entity.UserMessage) error {
-	_, err := r.db.Exec(`INSERT INTO user_messages (
-		id, their_jid, message, timestamp, device_id, from_me, type, push_name, receipt_type
-	) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
-		m.ID, m.TheirJID, m.Message, m.Timestamp, m.DeviceId, m.FromMe, m.Type, m.PushName, m.ReceiptType)
+	_, err := r.InsertNewWAMessage(m)
 
 	return err
 }
 
-func (r *Repo) UpdateWAMessageReceiptType(messageId []string, receiptType types.ReceiptType) error {
-	if receiptType == types.ReceiptTypeDelivered {
-		receiptType = "delivered"
+// InsertNewWAMessage stores the message and reports whether it is new, a
+// message already stored is left as it is.
+func (r *Repo) InsertNewWAMessage(m entity.UserMessage) (bool, error) {
+	return insertWAMessage(r.db.Exec, m)
+}
+
+func insertWAMessage(exec func(string, ...any) (sql.Result, error), m entity.UserMessage) (bool, error) {
+	m.Normalize()
+	media := m.Media
+	if media == nil {
+		media = &entity.MessageMedia{}
 	}
-	args := make([]any, len(messageId)+1)
-	args[0] = receiptType
-	ins := make([]string, 0)
-	for i, mId := range messageId {
-		args[i+1] = mId
-		ins = append(ins, "$"+strconv.Itoa(i+2))
+
+	res, err := exec(`INSERT INTO user_messages (
+		id, their_jid, message, timestamp, device_id, from_me, type, push_name, receipt_type, search_text,
+		kind, text, caption, media_mime, media_size, media_filename, quoted_id, sender_jid, sender_alt, imported
+	) VALUES (
+		$1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, ''),
+		$11, $12, $13, NULLIF($14, ''), NULLIF($15::bigint, 0), NULLIF($16, ''), NULLIF($17, ''), $18, $19, $20
+	) ON CONFLICT (device_id, id) DO NOTHING`,
+		m.ID, m.TheirJID, m.Message, m.Timestamp, m.DeviceId, m.FromMe, m.Type, m.PushName, m.ReceiptType, m.Message.SearchText(),
+		m.Kind, m.Text, m.Caption, media.Mime, int64(media.Size), media.FileName, m.QuotedID, m.Sender, m.SenderAlt, m.Imported)
+	if err != nil {
+		return false, err
 	}
 
-	q := `UPDATE user_messages SET receipt_type=$1 WHERE id IN (` + strings.Join(ins, ",") + `)`
-	log.Printf("Update Message Receipt Type: args: %v, Receipt: %s >> Query: %s", args, receiptType, q)
+	inserted, err := res.RowsAffected()
+
+	return inserted > 0, err
+}
+
+// UpdateWAMessageReceiptType sets the receipt of the messages of the device.
+func (r *Repo) UpdateWAMessageReceiptType(deviceId string, messageId []string, receiptType types.ReceiptType) error {
+	if receiptType == types.ReceiptTypeDelivered {
+		receiptType = "delivered"
+	}
 
 	_, err := r.db.Exec(
-		q,
-		args...,
+		`UPDATE user_messages SET receipt_type=$3 WHERE device_id=$1 AND id = ANY($2)`,
+		deviceId,
+		messageId,
+		receiptType,
 	)
 
 	return err
 }
 
-func (r *Repo) DeleteWAMessages(messageId []string) error {
-	args := make([]any, len(messageId)+1)
-	ins := make([]string, 0)
-	for i, mId := range messageId {
-		args[i+1] = mId
-		ins = append(ins, "$"+strconv.Itoa(i+2))
+// DeleteWAMessages deletes the messages of the device.
+func (r *Repo) DeleteWAMessages(deviceId string, messageId []string) error {
+	if len(messageId) == 0 {
+		return nil
 	}
-	_, err := r.db.Exec("DELETE FROM user_messages WHERE id IN ("+strings.Join(ins, ",")+")", args...)
+
+	_, err := r.db.Exec("DELETE FROM user_messages WHERE device_id=$1 AND id = ANY($2)", deviceId, messageId)
 
 	return err
 }
 
-func (r *Repo) DeleteWAMessage(messageId string) error {
-	ids := make([]string, 1)
-	ids = append(ids, messageId)
+func (r *Repo) DeleteWAMessage(deviceId string, messageId string) error {
+	return r.DeleteWAMessages(deviceId, []string{messageId})
+}
+
+const (
+	userMessageColumns = `id, their_jid, message, timestamp, device_id, from_me, type, push_name, receipt_type,
+		kind, text, caption, media_mime, media_size, media_filename, quoted_id, sender_jid, sender_alt,
+		media_purged_at, imported`
 
-	return r.DeleteWAMessages(ids)
+	// DefaultPageLimit and MaxPageLimit bound the page size of cursor paginated lists
+	DefaultPageLimit = 50
+	MaxPageLimit     = 200
+)
+
+// Cursor points to a position in a list ordered by message timestamp. The
+// position is a timestamp, Id breaks the ties between messages with the same
+// timestamp. After reads the messages newer than the position instead of the
+// older ones.
+type Cursor struct {
+	Timestamp time.Time
+	Id        string
+	After     bool
+	Limit     int
 }
 
+func (cur *Cursor) limit() int {
+	if cur.Limit <= 0 {
+		return DefaultPageLimit
+	}
+	if cur.Limit > MaxPageLimit {
+		return MaxPageLimit
+	}
+
+	return cur.Limit
+}
+
+// condition returns the SQL condition selecting the rows past the cursor,
+// its parameters are numbered from n.
+func (cur *Cursor) condition(prefix string, n int) (string, []any) {
+	if cur.Timestamp.IsZero() {
+		return "", nil
+	}
+
+	op := "<"
+	if cur.After {
+		op = ">"
+	}
+
+	if cur.Id == "" {
+		return " AND " + prefix + "timestamp " + op + " $" + strconv.Itoa(n), []any{cur.Timestamp}
+	}
+
+	return " AND (" + prefix + "timestamp, " + prefix + "id) " + op + " ($" + strconv.Itoa(n) + ", $" + strconv.Itoa(n+1) + ")", []any{cur.Timestamp, cur.Id}
+}
+
+func (cur *Cursor) order(prefix string) string {
+	if cur.After {
+		return " ORDER BY " + prefix + "timestamp ASC, " + prefix + "id ASC"
+	}
+
+	return " ORDER BY " + prefix + "timestamp DESC, " + prefix + "id DESC"
+}
+
+// ResolveCursor sets the timestamp of a cursor given as a message ID.
+func (r *Repo) ResolveCursor(deviceId string, cur *Cursor) error {
+	if cur.Id == "" || !cur.Timestamp.IsZero() {
+		return nil
+	}
+
+	err := r.db.QueryRow("SELECT timestamp FROM user_messages WHERE device_id=$1 AND id=$2", deviceId, cur.Id).Scan(&cur.Timestamp)
+	if err == sql.ErrNoRows {
+		return errors.New("cursor message not found: " + cur.Id)
+	}
+
+	return err
+}
+
+// Chat is an entry of the chat list: the last message of the chat and what
+// is known about the contact.
 type Chat struct {
-	Message entity.UserMessage `json:"message"`
-	From    types.ContactInfo  `json:"from"`
+	Message     entity.UserMessage `json:"message"`
+	From        types.ContactInfo  `json:"from"`
+	Name        string             `json:"name"`
+	Preview     string             `json:"preview"`
+	UnreadCount int                `json:"unreadCount"`
 }
 
+// ScanChat scans a row of userMessageColumns. Rows stored before the message
+// columns were filled are normalized from the raw message.
 func (r *Repo) ScanChat(row dbutil.Scannable) (*entity.UserMessage, error) {
 	var (
 		id, deviceId, pushName, messageType, receiptType sql.NullString
+		kind, text, caption, mediaMime, mediaFileName    sql.NullString
+		quotedId, senderJid, senderAlt                   sql.NullString
+		mediaSize                                        sql.NullInt64
 		theirJid                                         types.JID
 		message                                          entity.WAMessage
-		timestamp                                        sql.NullTime
-		fromMe                                           bool
+		timestamp, mediaPurgedAt                         sql.NullTime
+		fromMe, imported                                 bool
 	)
 
 	err := row.Scan(
@@ -97,6 +202,17 @@ func (r *Repo) ScanChat(row dbutil.Scannable) (*entity.UserMessage, error) {
 		&messageType,
 		&pushName,
 		&receiptType,
+		&kind,
+		&text,
+		&caption,
+		&mediaMime,
+		&mediaSize,
+		&mediaFileName,
+		&quotedId,
+		&senderJid,
+		&senderAlt,
+		&mediaPurgedAt,
+		&imported,
 	)
 
 	if err != nil {
@@ -104,102 +220,405 @@ func (r *Repo) ScanChat(row dbutil.Scannable) (*entity.UserMessage, error) {
 	}
 
 	chat := entity.UserMessage{
-		ID:        id.String,
-		TheirJID:  &theirJid,
-		Message:   &message,
-		Timestamp: timestamp.Time,
-		DeviceId:  deviceId.String,
-		FromMe:    fromMe,
-		Type:      receiptType.String,
-		PushName:  pushName.String,
+		ID:          id.String,
+		TheirJID:    &theirJid,
+		Message:     &message,
+		Timestamp:   timestamp.Time,
+		DeviceId:    deviceId.String,
+		FromMe:      fromMe,
+		Type:        messageType.String,
+		PushName:    pushName.String,
+		ReceiptType: receiptType.String,
+		Kind:        kind.String,
+		Text:        text.String,
+		Caption:     caption.String,
+		QuotedID:    quotedId.String,
+		Imported:    imported,
+	}
+
+	if !kind.Valid {
+		chat.Normalize()
+	} else if mediaMime.Valid {
+		chat.Media = &entity.MessageMedia{
+			Mime:     mediaMime.String,
+			Size:     uint64(mediaSize.Int64),
+			FileName: mediaFileName.String,
+			Purged:   mediaPurgedAt.Valid,
+		}
+	}
+
+	if senderJid.Valid {
+		if sender, err := types.ParseJID(senderJid.String); err == nil {
+			chat.Sender = &sender
+		}
+	}
+	if senderAlt.Valid {
+		if alt, err := types.ParseJID(senderAlt.String); err == nil {
+			chat.SenderAlt = &alt
+		}
 	}
 
 	return &chat, nil
 }
 
-func (r *Repo) GetWAChats(deviceId string) ([]*entity.UserMessage, error) {
-	var (
-		chats []*entity.UserMessage
-	)
-
-	/*
-		_ = r.db.QueryRow(`SELECT COUNT(*) FROM user_messages WHERE (their_jid, timestamp) IN (
-			SELECT their_jid, max(timestamp) FROM user_messages WHERE device_id=$1 GROUP BY their_jid
-		)`, deviceId).Scan(&total)
+// waChatColumns are the columns of the last message of a chat, in the order
+// ScanChat reads them.
+var waChatColumns = prefixColumns("l.", userMessageColumns)
+
+// GetWAChats returns a page of the chats of the device with their last
+// message, newest first. The chats can be filtered by the assignee of their
+// conversation, "none" for the unassigned ones, and by its status. The
+// cursor points to the last message of a chat.
+//
+// A message received after the chat has been read, or after the last message
+// sent in the chat, is unread.
+func (r *Repo) GetWAChats(deviceId string, assignee string, status string, cur Cursor) ([]*Chat, bool, error) {
+	chats := make([]*Chat, 0)
+
+	// user_messages predates the typed device_id columns, keep the parameter
+	// of the conversation join apart
+	args := []any{deviceId, deviceId}
+	filter := ""
+	if assignee == "none" {
+		filter += " AND COALESCE(c.assignee, '')=''"
+	} else if assignee != "" {
+		args = append(args, assignee)
+		filter += " AND c.assignee=$" + strconv.Itoa(len(args))
+	}
+	if status != "" {
+		args = append(args, status)
+		filter += " AND COALESCE(c.status, 'open')=$" + strconv.Itoa(len(args))
+	}
 
-		log.Printf("GetWAChats: %s; Total: %d", deviceId, total)
-	*/
-	rows, err := r.db.Query(`SELECT * FROM user_messages WHERE (their_jid, timestamp) IN (
-		SELECT their_jid, max(timestamp) FROM user_messages WHERE device_id=$1 GROUP BY their_jid
-	) ORDER BY timestamp DESC`, deviceId)
+	cond, curArgs := cur.condition("l.", len(args)+1)
+	args = append(args, curArgs...)
+	filter += cond
+
+	limit := cur.limit()
+	args = append(args, limit+1)
+
+	rows, err := r.db.Query(`WITH l AS (
+			SELECT DISTINCT ON (their_jid) `+userMessageColumns+` FROM user_messages
+			WHERE device_id=$1 ORDER BY their_jid, timestamp DESC, id DESC
+		)
+		SELECT `+waChatColumns+`,
+			(SELECT COUNT(*) FROM user_messages u WHERE u.device_id=$1 AND u.their_jid=l.their_jid AND NOT u.from_me
+				AND u.timestamp > GREATEST(
+					COALESCE(c.last_read_at, 'epoch'),
+					COALESCE((SELECT max(o.timestamp) FROM user_messages o WHERE o.device_id=$1 AND o.their_jid=l.their_jid AND o.from_me), 'epoch')
+				)
+			)
+		FROM l LEFT JOIN `+userChatTable+` c ON c.device_id=$2 AND c.their_jid=l.their_jid
+		WHERE true`+filter+cur.order("l.")+` LIMIT $`+strconv.Itoa(len(args)), args...)
 	if err != nil {
-		return chats, err
+		return chats, false, err
 	}
 	defer rows.Close()
+
 	for rows.Next() {
-		chat, err := r.ScanChat(rows)
-		log.Printf("Err: %+v", err)
-		if chat != nil {
-			chats = append(chats, chat)
+		var (
+			chat   Chat
+			unread int
+		)
+
+		m, err := r.ScanChat(scanAppend{rows, &unread})
+		if err != nil {
+			return chats, false, err
 		}
+
+		chat.Message = *m
+		chat.UnreadCount = unread
+		chat.Preview = m.Message.Preview()
+		chats = append(chats, &chat)
+	}
+
+	hasMore := len(chats) > limit
+	if hasMore {
+		chats = chats[:limit]
+	}
+	if cur.After {
+		slices.Reverse(chats)
+	}
+
+	return chats, hasMore, rows.Err()
+}
+
+// prefixColumns qualifies each column of the list with the prefix.
+func prefixColumns(prefix string, columns string) string {
+	list := strings.Split(columns, ",")
+	for i, column := range list {
+		list[i] = prefix + strings.TrimSpace(column)
 	}
 
-	log.Printf("Chats: %d", len(chats))
+	return strings.Join(list, ", ")
+}
 
-	return chats, err
+// scanAppend scans the columns of a row followed by extra columns.
+type scanAppend struct {
+	row   dbutil.Scannable
+	extra any
 }
 
-func (r *Repo) GetWaConversation(deviceId string, theirJID types.JID, maxTimestamp time.Time) (messages []entity.UserMessage, err error) {
+func (s scanAppend) Scan(dest ...any) error {
+	return s.row.Scan(append(dest, s.extra)...)
+}
+
+// GetWaConversation returns a page of the messages of the chat, newest first,
+// with the notes of the agents written in the same period.
+func (r *Repo) GetWaConversation(deviceId string, theirJID types.JID, cur Cursor) (messages []entity.UserMessage, hasMore bool, err error) {
 	var rows *sql.Rows
 
-	if maxTimestamp.IsZero() {
-		maxTimestamp = time.Now()
-	}
+	messages = make([]entity.UserMessage, 0)
+	args := []any{deviceId, theirJID}
+	cond, curArgs := cur.condition("", len(args)+1)
+	args = append(args, curArgs...)
+
+	limit := cur.limit()
+	args = append(args, limit+1)
 
 	rows, err = r.db.Query(
-		"SELECT * FROM user_messages WHERE device_id=$1 AND their_jid=$2 AND timestamp < $3 ORDER BY timestamp DESC",
-		deviceId,
-		theirJID,
-		maxTimestamp,
+		"SELECT "+userMessageColumns+" FROM user_messages WHERE device_id=$1 AND their_jid=$2"+cond+cur.order("")+" LIMIT $"+strconv.Itoa(len(args)),
+		args...,
 	)
 
 	if err == nil {
 		defer rows.Close()
 		for rows.Next() {
-			var (
-				id, device_id, push_name, message_type, receipt_type sql.NullString
-				their_jid                                            types.JID
-				message                                              entity.WAMessage
-				timestamp                                            sql.NullTime
-				from_me                                              bool
-			)
+			var m *entity.UserMessage
 
-			if err = rows.Scan(
-				&id,
-				&their_jid,
-				&message,
-				&timestamp,
-				&device_id,
-				&from_me,
-				&message_type,
-				&push_name,
-				&receipt_type,
-			); err == nil {
-				m := entity.UserMessage{
-					ID:        id.String,
-					TheirJID:  &their_jid,
-					Message:   &message,
-					Timestamp: timestamp.Time,
-					DeviceId:  device_id.String,
-					FromMe:    from_me,
-					Type:      message_type.String,
-					PushName:  push_name.String,
-				}
-
-				messages = append(messages, m)
+			if m, err = r.ScanChat(rows); err == nil {
+				messages = append(messages, *m)
 			}
 		}
 	}
+	if err != nil {
+		return
+	}
+
+	hasMore = len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+	if cur.After {
+		slices.Reverse(messages)
+	}
+
+	// The notes of the period covered by the page: from its oldest message,
+	// or the start of the chat, to the cursor, or now. Timestamps are stored
+	// with microsecond precision.
+	var from, to time.Time
+	if cur.After {
+		from = cur.Timestamp.Add(time.Microsecond)
+		if hasMore {
+			to = messages[0].Timestamp.Add(time.Microsecond)
+		}
+	} else {
+		to = cur.Timestamp
+		if hasMore {
+			from = messages[len(messages)-1].Timestamp
+		}
+	}
+
+	messages, err = r.mergeChatNotes(messages, deviceId, theirJID, from, to)
 
 	return
 }
+
+// HasWAMessages reports whether the chat of the device has any message.
+func (r *Repo) HasWAMessages(deviceId string, theirJID types.JID) (bool, error) {
+	var exists bool
+
+	err := r.db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM user_messages WHERE device_id=$1 AND their_jid=$2)",
+		deviceId, theirJID,
+	).Scan(&exists)
+
+	return exists, err
+}
+
+// EachWAMessage calls fn with every message of the chat, oldest first. It
+// stops at the first error returned by fn.
+func (r *Repo) EachWAMessage(deviceId string, theirJID types.JID, fn func(m *entity.UserMessage) error) error {
+	rows, err := r.db.Query(
+		"SELECT "+userMessageColumns+" FROM user_messages WHERE device_id=$1 AND their_jid=$2 ORDER BY timestamp ASC, id ASC",
+		deviceId, theirJID,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		m, err := r.ScanChat(rows)
+		if err != nil {
+			return err
+		}
+		if err = fn(m); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// WAMessageImport stores the messages of a chat export in one transaction.
+type WAMessageImport struct {
+	tx *sql.Tx
+}
+
+// BeginWAMessageImport starts the transaction of a chat import, nothing is
+// stored until it is committed.
+func (r *Repo) BeginWAMessageImport() (*WAMessageImport, error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	return &WAMessageImport{tx: tx}, nil
+}
+
+// InsertWAMessage stores the message and reports whether it is new.
+func (imp *WAMessageImport) InsertWAMessage(m entity.UserMessage) (bool, error) {
+	return insertWAMessage(imp.tx.Exec, m)
+}
+
+// SimilarWAMessageCount counts the messages of the chat sent from from until
+// to with the text, media messages when media is set. It finds the messages
+// already stored among the ones of a chat export, which only keeps their text
+// and time. A message without text matches nothing unless it is media.
+func (imp *WAMessageImport) SimilarWAMessageCount(deviceId string, theirJID types.JID, fromMe bool, from time.Time, to time.Time, text string, media bool) (int, error) {
+	var count int
+
+	if text == "" && !media {
+		return 0, nil
+	}
+
+	err := imp.tx.QueryRow(`SELECT COUNT(*) FROM user_messages
+		WHERE device_id=$1 AND their_jid=$2 AND from_me=$3 AND timestamp >= $4 AND timestamp < $5
+			AND (NOT $6 OR media_mime IS NOT NULL)
+			AND ($7 = '' OR COALESCE(NULLIF(text, ''), caption, '') = $7)`,
+		deviceId, theirJID, fromMe, from, to, media, text,
+	).Scan(&count)
+
+	return count, err
+}
+
+// Commit stores the imported messages.
+func (imp *WAMessageImport) Commit() error {
+	return imp.tx.Commit()
+}
+
+// Rollback drops the imported messages, it is a no-op after Commit.
+func (imp *WAMessageImport) Rollback() error {
+	return imp.tx.Rollback()
+}
+
+// mergeChatNotes adds the notes of the chat to its messages, a note is a
+// timeline entry of type "note" without WhatsApp message.
+func (r *Repo) mergeChatNotes(messages []entity.UserMessage, deviceId string, theirJID types.JID, from time.Time, to time.Time) ([]entity.UserMessage, error) {
+	notes, err := r.GetChatNotes(deviceId, theirJID, from, to)
+	if err != nil || len(notes) == 0 {
+		return messages, err
+	}
+
+	for _, note := range notes {
+		messages = append(messages, entity.UserMessage{
+			ID:        "note-" + strconv.FormatInt(note.Id, 10),
+			DeviceId:  note.DeviceId,
+			TheirJID:  &note.TheirJID,
+			FromMe:    true,
+			Timestamp: note.CreatedAt,
+			PushName:  note.Author,
+			Type:      "note",
+			Note:      note,
+		})
+	}
+
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Timestamp.After(messages[j].Timestamp)
+	})
+
+	return messages, nil
+}
+
+// GetWAMessagesToNormalize returns messages stored before the message columns
+// existed, with the JID of their device.
+func (r *Repo) GetWAMessagesToNormalize(limit int) ([]entity.UserMessage, []*types.JID, error) {
+	messages := make([]entity.UserMessage, 0)
+	deviceJids := make([]*types.JID, 0)
+
+	rows, err := r.db.Query(`SELECT m.device_id, m.id, m.their_jid, m.from_me, m.message, d.jid
+		FROM user_messages m LEFT JOIN `+userDeviceTableName+` d ON d.id::text=m.device_id::text
+		WHERE m.kind IS NULL LIMIT $1`, limit)
+	if err != nil {
+		return messages, deviceJids, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			m          entity.UserMessage
+			theirJid   types.JID
+			message    entity.WAMessage
+			rawJid     string
+			rawMessage []byte
+			deviceJid  sql.NullString
+		)
+
+		if err = rows.Scan(&m.DeviceId, &m.ID, &rawJid, &m.FromMe, &rawMessage, &deviceJid); err != nil {
+			return messages, deviceJids, err
+		}
+
+		// A row that can't be read is flagged instead of stopping the others
+		if theirJid, err = types.ParseJID(rawJid); err != nil {
+			log.Printf("Message backfill: message %s of device %s has an invalid chat %q: %s", m.ID, m.DeviceId, rawJid, err.Error())
+			m.Kind = invalidMessageKind
+		} else if err = message.Scan(rawMessage); err != nil {
+			log.Printf("Message backfill: message %s of device %s can't be decoded: %s", m.ID, m.DeviceId, err.Error())
+			m.Kind = invalidMessageKind
+		}
+		err = nil
+		m.TheirJID = &theirJid
+		m.Message = &message
+
+		var jid *types.JID
+		if deviceJid.Valid {
+			if j, err := types.ParseJID(deviceJid.String); err == nil {
+				j = j.ToNonAD()
+				jid = &j
+			}
+		}
+
+		messages = append(messages, m)
+		deviceJids = append(deviceJids, jid)
+	}
+
+	return messages, deviceJids, rows.Err()
+}
+
+// Kind of the stored messages whose raw message can't be read
+const invalidMessageKind = "invalid"
+
+// MarkWAMessageUnknown gives the message the unknown kind when its columns
+// can't be stored, it is not picked again by GetWAMessagesToNormalize.
+func (r *Repo) MarkWAMessageUnknown(deviceId string, messageId string) error {
+	_, err := r.db.Exec(`UPDATE user_messages SET kind='unknown' WHERE device_id=$1 AND id=$2`, deviceId, messageId)
+
+	return err
+}
+
+// UpdateWAMessageColumns stores the columns extracted from the raw message.
+func (r *Repo) UpdateWAMessageColumns(m entity.UserMessage) error {
+	media := m.Media
+	if media == nil {
+		media = &entity.MessageMedia{}
+	}
+
+	_, err := r.db.Exec(`UPDATE user_messages SET
+			kind=$1, text=$2, caption=$3, media_mime=NULLIF($4, ''), media_size=NULLIF($5::bigint, 0),
+			media_filename=NULLIF($6, ''), quoted_id=NULLIF($7, ''), sender_jid=$8
+		WHERE device_id=$9 AND id=$10`,
+		m.Kind, m.Text, m.Caption, media.Mime, int64(media.Size), media.FileName, m.QuotedID, m.Sender, m.DeviceId, m.ID)
+
+	return err
+}
@@ -0,0 +1,47 @@
+package store
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// countScan records how many columns are scanned.
+type countScan struct {
+	n int
+}
+
+func (c *countScan) Scan(dest ...any) error {
+	c.n = len(dest)
+
+	return errors.New("not scanned")
+}
+
+func TestScanChatColumns(t *testing.T) {
+	tests := []struct {
+		name    string
+		columns string
+	}{
+		{"messages", userMessageColumns},
+		{"chats", waChatColumns},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c countScan
+			if _, err := (&Repo{}).ScanChat(&c); err == nil {
+				t.Fatal("ScanChat() did not return the scan error")
+			}
+			if got := len(strings.Split(tt.columns, ",")); got != c.n {
+				t.Errorf("query selects %d columns, ScanChat scans %d", got, c.n)
+			}
+		})
+	}
+}
+
+func TestPrefixColumns(t *testing.T) {
+	got := prefixColumns("l.", "id, their_jid,\n\t\tmessage")
+	if want := "l.id, l.their_jid, l.message"; got != want {
+		t.Errorf("prefixColumns() = %q, want %q", got, want)
+	}
+}
@@ -2,6 +2,7 @@ package store
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"log"
 	"strconv"
@@ -181,7 +182,7 @@ func (r *Repo) GetUserContacts(userId int, limit int, offset int, s string) ([]e
 		return contacts, totalContact, nil
 	}
 
-	q := "SELECT id,user_id,name,phone,in_wa,verified_name FROM " + userContactTableName + " WHERE user_id=$1" + f + " ORDER BY in_wa DESC, name ASC LIMIT $2 OFFSET $3"
+	q := "SELECT id,user_id,name,phone,in_wa,verified_name,fields FROM " + userContactTableName + " WHERE user_id=$1" + f + " ORDER BY in_wa DESC, name ASC LIMIT $2 OFFSET $3"
 	rows, err := r.db.Query(q, userId, limit, offset)
 	if err != nil {
 		return contacts, totalContact, err
@@ -193,6 +194,7 @@ func (r *Repo) GetUserContacts(userId int, limit int, offset int, s string) ([]e
 			id, inWa                  int
 			contactUserId             sql.NullInt64
 			name, phone, verifiedName sql.NullString
+			fields                    []byte
 		)
 
 		if err := rows.Scan(
@@ -202,6 +204,7 @@ func (r *Repo) GetUserContacts(userId int, limit int, offset int, s string) ([]e
 			&phone,
 			&inWa,
 			&verifiedName,
+			&fields,
 		); err == nil {
 			contact := entity.UserContact{
 				Id:           id,
@@ -212,6 +215,7 @@ func (r *Repo) GetUserContacts(userId int, limit int, offset int, s string) ([]e
 				VerifiedName: verifiedName.String,
 				Groups:       r.GetContactGroupByContactId(id),
 			}
+			_ = json.Unmarshal(fields, &contact.Fields)
 			contacts = append(contacts, contact)
 		}
 	}
@@ -325,27 +329,37 @@ func (r *Repo) GetUserContactGroups(userId int) ([]entity.UserContactGroup, erro
 	return contactGroups, err
 }
 
+// SaveUserContact inserts or updates the contact, its custom fields are left
+// as they are when Fields is nil.
 func (r *Repo) SaveUserContact(contact *entity.UserContact) (err error) {
+	var fields interface{}
+	if contact.Fields != nil {
+		fields, _ = json.Marshal(contact.Fields)
+	}
+
 	if contact.Id != 0 {
 		_, err = r.db.Exec(
-			"UPDATE "+userContactTableName+" SET name=$1, phone=$2, in_wa=$3, verified_name=$4 WHERE id=$5",
+			"UPDATE "+userContactTableName+" SET name=$1, phone=$2, in_wa=$3, verified_name=$4, fields=COALESCE($5::jsonb, fields) WHERE id=$6",
 			contact.Name,
 			contact.Phone,
 			contact.InWA,
 			contact.VerifiedName,
+			fields,
 			contact.Id,
 		)
 	} else {
 		err = r.db.QueryRow(
-			"INSERT INTO "+userContactTableName+" (user_id, name, phone, in_wa, verified_name) VALUES ($1, $2, $3, $4, $5) ON CONFLICT ON CONSTRAINT user_contacts_phone_user DO UPDATE SET name=$6, phone=$7, verified_name=$8 RETURNING id",
+			"INSERT INTO "+userContactTableName+" (user_id, name, phone, in_wa, verified_name, fields) VALUES ($1, $2, $3, $4, $5, COALESCE($6::jsonb, '{}')) ON CONFLICT ON CONSTRAINT user_contacts_phone_user DO UPDATE SET name=$7, phone=$8, verified_name=$9, fields=COALESCE($10::jsonb, "+userContactTableName+".fields) RETURNING id",
 			contact.UserId,
 			contact.Name,
 			contact.Phone,
 			contact.InWA,
 			contact.VerifiedName,
+			fields,
 			contact.Name,
 			contact.Phone,
 			contact.VerifiedName,
+			fields,
 		).Scan(&contact.Id)
 	}
 
@@ -373,7 +387,7 @@ func (r *Repo) SaveUserContact(contact *entity.UserContact) (err error) {
 
 func (r *Repo) GetUserContactById(contactId int, userId int) (contact entity.UserContact, err error) {
 	var query *sql.Row
-	var q = "SELECT id,user_id,name,phone,in_wa,verified_name FROM " + userContactTableName + " WHERE id=$1"
+	var q = "SELECT id,user_id,name,phone,in_wa,verified_name,fields FROM " + userContactTableName + " WHERE id=$1"
 	if userId != 0 {
 		query = r.db.QueryRow(q+" AND user_id=$2", contactId, userId)
 	} else {
@@ -384,6 +398,7 @@ func (r *Repo) GetUserContactById(contactId int, userId int) (contact entity.Use
 		id, in_wa                  int
 		user_id                    sql.NullInt64
 		name, phone, verified_name sql.NullString
+		fields                     []byte
 	)
 	err = query.Scan(
 		&id,
@@ -392,6 +407,7 @@ func (r *Repo) GetUserContactById(contactId int, userId int) (contact entity.Use
 		&phone,
 		&in_wa,
 		&verified_name,
+		&fields,
 	)
 
 	contact = entity.UserContact{
@@ -402,6 +418,7 @@ func (r *Repo) GetUserContactById(contactId int, userId int) (contact entity.Use
 		InWA:         in_wa,
 		VerifiedName: verified_name.String,
 	}
+	_ = json.Unmarshal(fields, &contact.Fields)
 
 	return contact, err
 }
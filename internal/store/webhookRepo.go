@@ -0,0 +1,258 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+
+	"go.mau.fi/util/dbutil"
+
+	"github.com/perigiweb/go-wa-api/internal/store/entity"
+)
+
+const (
+	webhookTable         = "user_webhooks"
+	webhookDeliveryTable = "user_webhook_deliveries"
+)
+
+const (
+	webhookColumns         = "id, device_id, url, secret, events, active, created_at"
+	webhookDeliveryColumns = "id, webhook_id, event_id, event_type, payload, status, status_code, attempts, error, created_at, delivered_at"
+
+	getWebhooksQuery             = "SELECT " + webhookColumns + " FROM " + webhookTable + " WHERE device_id=$1 ORDER BY id DESC"
+	getActiveWebhooksQuery       = "SELECT " + webhookColumns + " FROM " + webhookTable + " WHERE device_id=$1 AND active=true"
+	getWebhookByIdQuery          = "SELECT " + webhookColumns + " FROM " + webhookTable + " WHERE id=$1 AND device_id=$2"
+	getActiveWebhookQuery        = "SELECT " + webhookColumns + " FROM " + webhookTable + " WHERE id=$1 AND active=true"
+	insertWebhookQuery           = `INSERT INTO ` + webhookTable + ` (device_id, url, secret, events, active) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`
+	updateWebhookQuery           = `UPDATE ` + webhookTable + ` SET url=$1, secret=$2, events=$3, active=$4 WHERE id=$5 AND device_id=$6`
+	deleteWebhookQuery           = `DELETE FROM ` + webhookTable + ` WHERE id=$1 AND device_id=$2`
+	getWebhookDeliveriesQuery    = "SELECT " + webhookDeliveryColumns + " FROM " + webhookDeliveryTable + " WHERE webhook_id=$1 ORDER BY id DESC LIMIT $2 OFFSET $3"
+	getWebhookDeliveryCountQuery = "SELECT COUNT(*) FROM " + webhookDeliveryTable + " WHERE webhook_id=$1"
+	getWebhookDeliveryByIdQuery  = "SELECT " + webhookDeliveryColumns + " FROM " + webhookDeliveryTable + " WHERE id=$1 AND webhook_id=$2"
+	insertWebhookDeliveryQuery   = `INSERT INTO ` + webhookDeliveryTable + ` (
+			webhook_id, event_id, event_type, payload, status
+		) VALUES ($1, $2, $3, $4, $5) ON CONFLICT (webhook_id, event_id) DO NOTHING RETURNING id, created_at`
+	// Deliveries left pending by a process that stopped before sending them
+	getStaleWebhookDeliveriesQuery = "SELECT " + webhookDeliveryColumns + " FROM " + webhookDeliveryTable + " WHERE status='pending' AND created_at < $1 ORDER BY id ASC LIMIT $2"
+	updateWebhookDeliveryQuery     = `UPDATE ` + webhookDeliveryTable + ` SET
+			status=$1, status_code=$2, attempts=$3, error=$4, delivered_at=$5
+		WHERE id=$6`
+)
+
+func (r *Repo) ScanWebhook(row dbutil.Scannable) (*entity.Webhook, error) {
+	var (
+		webhook entity.Webhook
+		events  []uint8
+		active  bool
+	)
+
+	err := row.Scan(
+		&webhook.Id,
+		&webhook.DeviceId,
+		&webhook.Url,
+		&webhook.Secret,
+		&events,
+		&active,
+		&webhook.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	webhook.Events = convertJsonbToString(events)
+	webhook.Active = &active
+
+	return &webhook, nil
+}
+
+func (r *Repo) ScanWebhookDelivery(row dbutil.Scannable) (*entity.WebhookDelivery, error) {
+	var (
+		delivery    entity.WebhookDelivery
+		payload     []uint8
+		errMsg      sql.NullString
+		deliveredAt sql.NullTime
+	)
+
+	err := row.Scan(
+		&delivery.Id,
+		&delivery.WebhookId,
+		&delivery.EventId,
+		&delivery.EventType,
+		&payload,
+		&delivery.Status,
+		&delivery.StatusCode,
+		&delivery.Attempts,
+		&errMsg,
+		&delivery.CreatedAt,
+		&deliveredAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	delivery.Payload = payload
+	delivery.Error = errMsg.String
+	if deliveredAt.Valid {
+		delivery.DeliveredAt = &deliveredAt.Time
+	}
+
+	return &delivery, nil
+}
+
+func (r *Repo) scanWebhooks(rows *sql.Rows) []*entity.Webhook {
+	webhooks := make([]*entity.Webhook, 0)
+	for rows.Next() {
+		webhook, err := r.ScanWebhook(rows)
+		if err == nil {
+			webhooks = append(webhooks, webhook)
+		}
+	}
+
+	return webhooks
+}
+
+func (r *Repo) GetWebhooks(deviceId string) ([]*entity.Webhook, error) {
+	rows, err := r.db.Query(getWebhooksQuery, deviceId)
+	if err != nil {
+		return make([]*entity.Webhook, 0), err
+	}
+	defer rows.Close()
+
+	return r.scanWebhooks(rows), nil
+}
+
+func (r *Repo) GetActiveWebhooks(deviceId string) ([]*entity.Webhook, error) {
+	rows, err := r.db.Query(getActiveWebhooksQuery, deviceId)
+	if err != nil {
+		return make([]*entity.Webhook, 0), err
+	}
+	defer rows.Close()
+
+	return r.scanWebhooks(rows), nil
+}
+
+func (r *Repo) GetWebhook(webhookId int64, deviceId string) (*entity.Webhook, error) {
+	return r.ScanWebhook(r.db.QueryRow(getWebhookByIdQuery, webhookId, deviceId))
+}
+
+// SaveWebhook inserts or updates the webhook, a webhook without Active is
+// active.
+func (r *Repo) SaveWebhook(webhook *entity.Webhook) error {
+	var err error
+
+	if webhook.Active == nil {
+		active := true
+		webhook.Active = &active
+	}
+
+	if webhook.Id != 0 {
+		_, err = r.db.Exec(
+			updateWebhookQuery,
+			webhook.Url,
+			webhook.Secret,
+			webhook.Events,
+			*webhook.Active,
+			webhook.Id,
+			webhook.DeviceId,
+		)
+	} else {
+		err = r.db.QueryRow(
+			insertWebhookQuery,
+			webhook.DeviceId,
+			webhook.Url,
+			webhook.Secret,
+			webhook.Events,
+			*webhook.Active,
+		).Scan(&webhook.Id, &webhook.CreatedAt)
+	}
+
+	return err
+}
+
+func (r *Repo) DeleteWebhook(webhookId int64, deviceId string) error {
+	_, err := r.db.Exec(deleteWebhookQuery, webhookId, deviceId)
+
+	return err
+}
+
+func (r *Repo) GetWebhookDeliveries(webhookId int64, limit int, offset int) ([]*entity.WebhookDelivery, int, error) {
+	deliveries := make([]*entity.WebhookDelivery, 0)
+	total := 0
+
+	err := r.db.QueryRow(getWebhookDeliveryCountQuery, webhookId).Scan(&total)
+	if err != nil || total == 0 {
+		return deliveries, total, err
+	}
+
+	rows, err := r.db.Query(getWebhookDeliveriesQuery, webhookId, limit, offset)
+	if err != nil {
+		return deliveries, total, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		delivery, scanErr := r.ScanWebhookDelivery(rows)
+		if scanErr == nil {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+
+	return deliveries, total, nil
+}
+
+// GetActiveWebhook returns the webhook when it is active.
+func (r *Repo) GetActiveWebhook(webhookId int64) (*entity.Webhook, error) {
+	return r.ScanWebhook(r.db.QueryRow(getActiveWebhookQuery, webhookId))
+}
+
+// GetStaleWebhookDeliveries returns the deliveries still pending since before
+// the given time, the oldest first.
+func (r *Repo) GetStaleWebhookDeliveries(before time.Time, limit int) ([]*entity.WebhookDelivery, error) {
+	deliveries := make([]*entity.WebhookDelivery, 0)
+
+	rows, err := r.db.Query(getStaleWebhookDeliveriesQuery, before, limit)
+	if err != nil {
+		return deliveries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		delivery, err := r.ScanWebhookDelivery(rows)
+		if err != nil {
+			return deliveries, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, rows.Err()
+}
+
+func (r *Repo) GetWebhookDelivery(deliveryId int64, webhookId int64) (*entity.WebhookDelivery, error) {
+	return r.ScanWebhookDelivery(r.db.QueryRow(getWebhookDeliveryByIdQuery, deliveryId, webhookId))
+}
+
+// InsertWebhookDelivery returns sql.ErrNoRows when the event has already been
+// recorded for the webhook.
+func (r *Repo) InsertWebhookDelivery(delivery *entity.WebhookDelivery) error {
+	return r.db.QueryRow(
+		insertWebhookDeliveryQuery,
+		delivery.WebhookId,
+		delivery.EventId,
+		delivery.EventType,
+		[]byte(delivery.Payload),
+		delivery.Status,
+	).Scan(&delivery.Id, &delivery.CreatedAt)
+}
+
+func (r *Repo) UpdateWebhookDelivery(delivery *entity.WebhookDelivery) error {
+	_, err := r.db.Exec(
+		updateWebhookDeliveryQuery,
+		delivery.Status,
+		delivery.StatusCode,
+		delivery.Attempts,
+		delivery.Error,
+		delivery.DeliveredAt,
+		delivery.Id,
+	)
+
+	return err
+}
@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 
 	//	"strings"
 	"log"
@@ -25,6 +28,9 @@ import (
 
 	_ "modernc.org/sqlite"
 
+	// The time zones of the devices without depending on the system database
+	_ "time/tzdata"
+
 	"github.com/perigiweb/go-wa-api/internal"
 	"github.com/perigiweb/go-wa-api/internal/action"
 	"github.com/perigiweb/go-wa-api/internal/service"
@@ -113,6 +119,15 @@ func main() {
 	dbConn := internal.DBConnect()
 	defer dbConn.Close()
 
+	// The migrate command only runs the migrations of the app
+	r := store.NewRepo(dbConn)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err = migrateCommand(r, os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	waDbLog := waLog.Stdout("Database", "DEBUG", true)
 	container = sqlstore.NewWithDB(dbConn, "pgx", waDbLog)
 	err = container.Upgrade()
@@ -120,7 +135,6 @@ func main() {
 		panic(err)
 	}
 
-	r := store.NewRepo(dbConn)
 	err = r.Migrate()
 	if err != nil {
 		panic(err)
@@ -131,6 +145,14 @@ func main() {
 
 	a.Routes(e)
 
+	err = s.ConnectBroker()
+	if err != nil {
+		panic(err)
+	}
+
+	s.StartEventWorkers()
+	s.StartHistorySyncWorker()
+	s.StartMessageBackfill()
 	s.StartUp()
 	s.CronJobs(c)
 
@@ -170,6 +192,48 @@ func main() {
 		log.Fatal(err.Error())
 	}
 
+	// Stop the event workers, pending events stay in the outbox
+	s.Shutdown()
+
 	// Try To Shutdown Cron
 	//c.Stop()
 }
+
+// migrateCommand handles `migrate up`, `migrate down [steps]` and
+// `migrate status`.
+func migrateCommand(r *store.Repo, args []string) error {
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+
+	switch cmd {
+	case "up":
+		return r.Migrate()
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return errors.New("steps should be a positive number")
+			}
+			steps = n
+		}
+		return r.Rollback(steps)
+	case "status":
+		status, err := r.MigrateStatus()
+		if err != nil {
+			return err
+		}
+		for _, m := range status {
+			applied := "pending"
+			if m.Applied {
+				applied = "applied"
+			}
+			fmt.Printf("v%-3d %-8s %s\n", m.Version, applied, m.Name)
+		}
+		return nil
+	}
+
+	return errors.New("unknown migrate command \"" + cmd + "\", use up, down [steps] or status")
+}